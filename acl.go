@@ -0,0 +1,158 @@
+package alcatraz
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Permission is an operation a client could be allowed to do.
+type Permission string
+
+const (
+	PermissionUpload   Permission = "upload"
+	PermissionDownload Permission = "download"
+	PermissionList     Permission = "list"
+	PermissionDelete   Permission = "delete"
+	// PermissionAdmin allows to place legal holds on the files of all clients, it's not one
+	// of the permissions given to AllowedClients
+	PermissionAdmin Permission = "admin"
+	// PermissionReplicate allows a primary server to write and list the files of all clients and
+	// spaces, it's not one of the permissions given to AllowedClients either
+	PermissionReplicate Permission = "replicate"
+)
+
+var allPermissions = []Permission{PermissionUpload, PermissionDownload, PermissionList, PermissionDelete}
+
+// knownPermissions are all valid permissions in the config
+var knownPermissions = append(allPermissions, PermissionAdmin, PermissionReplicate)
+
+// Quota limits the storage used by a client, zero means unlimited.
+type Quota struct {
+	MaxBytes int64 `yaml:"max_bytes"`
+	MaxFiles int64 `yaml:"max_files"`
+}
+
+// ClientACL describes a client, identified by its certificate, and what it's allowed to do.
+type ClientACL struct {
+	Identity    string       `yaml:"identity"`
+	Permissions []Permission `yaml:"permissions"`
+	Quota       Quota        `yaml:"quota"`
+	// Limits override the server's default limits
+	Limits Limits `yaml:"limits"`
+	// WORM makes the client's files immutable
+	WORM WORM `yaml:"worm"`
+	// Storage is the client's folder, relative to the storage path. Default is the identity,
+	// SPIFFE IDs without the scheme.
+	Storage string `yaml:"storage"`
+}
+
+// Can reports if the client has the permission.
+func (c *ClientACL) Can(permission Permission) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// accessList is the validated list of clients, by identity.
+type accessList map[string]*ClientACL
+
+// newAccessList validates the clients. AllowedClients are kept for backward compatibility,
+// they get all permissions.
+func newAccessList(config ServerConfig) (accessList, error) {
+	acl := accessList{}
+	storages := map[string]string{}
+
+	clients := append([]ClientACL{}, config.Clients...)
+	for name, allowed := range config.AllowedClients {
+		if allowed {
+			clients = append(clients, ClientACL{Identity: name, Permissions: allPermissions})
+		}
+	}
+
+	for i, client := range clients {
+		client := client
+		if client.Identity == "" {
+			return nil, fmt.Errorf("clients[%d]: identity is required", i)
+		}
+		if _, ok := acl[client.Identity]; ok {
+			return nil, fmt.Errorf("clients[%d]: identity %q is defined more than once", i, client.Identity)
+		}
+
+		for _, p := range client.Permissions {
+			if !validPermission(p) {
+				return nil, fmt.Errorf("clients[%d] (%q): unknown permission %q, expected one of %v", i, client.Identity, p,
+					knownPermissions)
+			}
+		}
+
+		if client.Quota.MaxBytes < 0 || client.Quota.MaxFiles < 0 {
+			return nil, fmt.Errorf("clients[%d] (%q): quota can't be negative", i, client.Identity)
+		}
+		if err := client.Limits.validate(); err != nil {
+			return nil, fmt.Errorf("clients[%d] (%q): %v", i, client.Identity, err)
+		}
+		if client.WORM.Retention < 0 {
+			return nil, fmt.Errorf("clients[%d] (%q): retention can't be negative", i, client.Identity)
+		}
+		client.Limits = client.Limits.merge(config.Limits)
+
+		if client.Storage == "" {
+			client.Storage = defaultStorage(client.Identity)
+		}
+		storage, err := cleanStoragePath(client.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("clients[%d] (%q): %v", i, client.Identity, err)
+		}
+		for used, other := range storages {
+			if overlaps(storage, used) {
+				return nil, fmt.Errorf("clients[%d] (%q): storage %q overlaps with %q of %q", i, client.Identity, storage, used, other)
+			}
+		}
+		storages[storage] = client.Identity
+		client.Storage = storage
+
+		acl[client.Identity] = &client
+	}
+
+	return acl, nil
+}
+
+// lookup returns the client with the identity. Without access list, the client has only its own folder.
+func (acl accessList) lookup(identity string) (*ClientACL, bool) {
+	if acl == nil {
+		return &ClientACL{Identity: identity, Storage: defaultStorage(identity)}, false
+	}
+	client, ok := acl[identity]
+	return client, ok
+}
+
+func validPermission(permission Permission) bool {
+	for _, p := range knownPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanStoragePath verifies the path stays inside the storage and is not the server's internal folder.
+func cleanStoragePath(storage string) (string, error) {
+	if filepath.IsAbs(storage) {
+		return "", fmt.Errorf("storage %q must be relative to the storage path", storage)
+	}
+
+	cleaned := path.Clean(filepath.ToSlash(storage))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("storage %q must be inside the storage path", storage)
+	}
+	if cleaned == internalDir || strings.HasPrefix(cleaned, internalDir+"/") {
+		return "", fmt.Errorf("storage %q is reserved for the server", storage)
+	}
+
+	return filepath.FromSlash(cleaned), nil
+}
@@ -0,0 +1,118 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avalchev94/alcatraz/pb/mock"
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestNewAccessList(t *testing.T) {
+	tests := []struct {
+		clients []ClientACL
+		err     string
+	}{
+		{[]ClientACL{{Permissions: allPermissions}}, "identity is required"},
+		{[]ClientACL{{Identity: "Reese"}, {Identity: "Reese"}}, "more than once"},
+		{[]ClientACL{{Identity: "Reese", Permissions: []Permission{"uplaod"}}}, `unknown permission "uplaod"`},
+		{[]ClientACL{{Identity: "Reese", Quota: Quota{MaxBytes: -1}}}, "negative"},
+		{[]ClientACL{{Identity: "Reese", Storage: "../etc"}}, "inside the storage"},
+		{[]ClientACL{{Identity: "Reese", Storage: "/etc"}}, "relative"},
+		{[]ClientACL{{Identity: "Reese", Storage: internalDir}}, "reserved"},
+		{[]ClientACL{{Identity: "Reese", Storage: "kids"}, {Identity: "Dewey", Storage: "kids/dewey"}}, "overlaps"},
+	}
+
+	for _, test := range tests {
+		_, err := newAccessList(ServerConfig{Clients: test.clients})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error with %q, got %v", test.err, err)
+		}
+	}
+
+	acl, err := newAccessList(ServerConfig{
+		Clients:        []ClientACL{{Identity: "Reese", Permissions: []Permission{PermissionUpload}, Storage: "kids/reese/"}},
+		AllowedClients: map[string]bool{"Malcolm": true, "Dewey": false},
+	})
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+
+	if reese, ok := acl.lookup("Reese"); !ok || reese.Storage != filepath.Join("kids", "reese") || reese.Can(PermissionDelete) {
+		t.Errorf("unexpected Reese: %+v", reese)
+	}
+	if malcolm, ok := acl.lookup("Malcolm"); !ok || !malcolm.Can(PermissionDelete) || malcolm.Storage != "Malcolm" {
+		t.Errorf("allowed clients should get all permissions: %+v", malcolm)
+	}
+	if _, ok := acl.lookup("Dewey"); ok {
+		t.Error("Dewey is not allowed")
+	}
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "alcatrazd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("port: 9000\nclients:\n  - identity: Reese\n    permissions: [upload]\n")
+	file.Close()
+
+	config := ServerConfig{StoragePath: "storage"}
+	if err := LoadServerConfig(file.Name(), &config); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if config.Port != 9000 || config.StoragePath != "storage" || len(config.Clients) != 1 {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	ioutil.WriteFile(file.Name(), []byte("clients:\n  - identity: Reese\n    permission: [upload]\n"), 0644)
+	if err := LoadServerConfig(file.Name(), &config); err == nil {
+		t.Error("unknown field, error should be returned")
+	}
+}
+
+func TestAuthClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	acl, _ := newAccessList(ServerConfig{Clients: []ClientACL{
+		{Identity: "Reese", Permissions: []Permission{PermissionUpload}},
+		{Identity: "Dewey", Permissions: []Permission{PermissionList}},
+	}})
+	server := Server{acl: acl}
+	info := &grpc.StreamServerInfo{FullMethod: "/pb.Alcatraz/UploadFile"}
+	handler := func(interface{}, grpc.ServerStream) error { return nil }
+
+	streamOf := func(name string) grpc.ServerStream {
+		stream := mock.NewMockAlcatraz_UploadFileServer(ctrl)
+		stream.EXPECT().Context().AnyTimes().Return(peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: name}}},
+			}},
+		}))
+		return stream
+	}
+
+	if err := server.authClient(nil, streamOf("Reese"), info, handler); err != nil {
+		t.Errorf("Reese can upload, got %v", err)
+	}
+	if err := server.authClient(nil, streamOf("Dewey"), info, handler); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+	if err := server.authClient(nil, streamOf("Eve"), info, handler); grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package alcatraz
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newAuditLogger returns a logger writing JSON lines to the file.
+// Without file, the audit events go to the standard log.
+func newAuditLogger(filename string) (*logrus.Logger, error) {
+	if filename == "" {
+		return logrus.StandardLogger(), nil
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(file)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	return logger, nil
+}
+
+// audit records a security relevant event, like rejected client or revoked certificate.
+func (s *Server) audit(event, identity string, fields logrus.Fields) {
+	logger := s.auditLog
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+
+	entry := logger.WithFields(fields).WithField("audit", event)
+	if identity != "" {
+		entry = entry.WithField("identity", identity)
+	}
+	entry.Info(event)
+}
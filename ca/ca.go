@@ -0,0 +1,518 @@
+// Package ca is a small certificate authority for the alcatraz servers and clients.
+// The CA keeps its certificate, key, CRL and the index of the issued certificates in one folder.
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The files in the CA folder
+const (
+	CertFile  = "ca.crt"
+	KeyFile   = "ca.key"
+	CRLFile   = "ca.crl"
+	IndexFile = "index.json"
+	// LockFile exists while the CA is open, the CA is used by one process at a time
+	LockFile = "ca.lock"
+)
+
+// LockTimeout is how long Open waits for the CA used by another process.
+var LockTimeout = 10 * time.Second
+
+// Default validity periods
+const (
+	DefaultCAValidity   = 10 * 365 * 24 * time.Hour
+	DefaultCertValidity = 365 * 24 * time.Hour
+	DefaultCRLValidity  = 7 * 24 * time.Hour
+)
+
+// Kind is the purpose of the certificate, it defines the key usages.
+type Kind string
+
+const (
+	KindServer Kind = "server"
+	KindClient Kind = "client"
+)
+
+// Record describes an issued certificate.
+type Record struct {
+	Serial         string     `json:"serial"`
+	Name           string     `json:"name"`
+	Kind           Kind       `json:"kind"`
+	DNSNames       []string   `json:"dns_names,omitempty"`
+	IPAddresses    []string   `json:"ip_addresses,omitempty"`
+	EmailAddresses []string   `json:"email_addresses,omitempty"`
+	URIs           []string   `json:"uris,omitempty"`
+	NotBefore      time.Time  `json:"not_before"`
+	NotAfter       time.Time  `json:"not_after"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Revoked reports if the certificate is revoked.
+func (r Record) Revoked() bool {
+	return r.RevokedAt != nil
+}
+
+// Expired reports if the certificate is expired at t.
+func (r Record) Expired(t time.Time) bool {
+	return t.After(r.NotAfter)
+}
+
+// index is stored in IndexFile.
+type index struct {
+	CRLNumber    int64    `json:"crl_number"`
+	Certificates []Record `json:"certificates"`
+	Tokens       []token  `json:"tokens,omitempty"`
+}
+
+// CA issues and revokes certificates.
+type CA struct {
+	dir   string
+	cert  *x509.Certificate
+	key   crypto.Signer
+	index index
+	lock  *os.File
+	// CRLValidity is how long the generated CRLs are valid, default is DefaultCRLValidity.
+	CRLValidity time.Duration
+}
+
+// Init creates a new CA in dir, which must not contain one already.
+func Init(dir, name string, validity time.Duration) (*CA, error) {
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if validity <= 0 {
+		validity = DefaultCAValidity
+	}
+	if _, err := os.Stat(filepath.Join(dir, CertFile)); err == nil {
+		return nil, fmt.Errorf("CA already exists in %q", dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create CA folder: %v", err)
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ca := &CA{dir: dir, lock: lock}
+	if err := ca.create(name, validity); err != nil {
+		ca.Close()
+		return nil, err
+	}
+	return ca, nil
+}
+
+// create generates the key and the certificate of the new CA.
+func (ca *CA) create(name string, validity time.Duration) error {
+	if _, err := os.Stat(filepath.Join(ca.dir, CertFile)); err == nil {
+		return fmt.Errorf("CA already exists in %q", ca.dir)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %v", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	if err := writeKey(filepath.Join(ca.dir, KeyFile), key); err != nil {
+		return err
+	}
+	if err := writePEM(filepath.Join(ca.dir, CertFile), "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+
+	ca.cert, ca.key = cert, key
+	if err := ca.save(); err != nil {
+		return err
+	}
+	return ca.WriteCRL()
+}
+
+// Open loads the CA from dir and locks it until Close, so the index isn't changed by two
+// processes at once.
+func Open(dir string) (*CA, error) {
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ca := &CA{dir: dir, lock: lock}
+	if err := ca.load(); err != nil {
+		ca.Close()
+		return nil, err
+	}
+	return ca, nil
+}
+
+// load reads the certificate, the key and the index.
+func (ca *CA) load() error {
+	data, err := ioutil.ReadFile(filepath.Join(ca.dir, CertFile))
+	if err != nil {
+		return fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("no certificate in %q", CertFile)
+	}
+	if ca.cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(ca.dir, KeyFile))
+	if err != nil {
+		return fmt.Errorf("failed to read CA key: %v", err)
+	}
+	block, _ = pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("no key in %q", KeyFile)
+	}
+	if ca.key, err = parseKey(block.Bytes); err != nil {
+		return fmt.Errorf("failed to parse CA key: %v", err)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(ca.dir, IndexFile))
+	if err != nil {
+		return fmt.Errorf("failed to read index: %v", err)
+	}
+	if err := json.Unmarshal(data, &ca.index); err != nil {
+		return fmt.Errorf("failed to parse index: %v", err)
+	}
+	return nil
+}
+
+// parseKey parses an EC, PKCS#1 RSA or PKCS#8 private key.
+func parseKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("expected an EC, RSA or PKCS#8 private key")
+	}
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey, ed25519.PrivateKey:
+		return key.(crypto.Signer), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// lockDir creates the lock file of the CA folder, it waits up to LockTimeout while the
+// file exists.
+func lockDir(dir string) (*os.File, error) {
+	filename := filepath.Join(dir, LockFile)
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			return file, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock CA: %v", err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("CA is used by another process, remove %q if none is running", filename)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Close unlocks the CA, it can't be used after that.
+func (ca *CA) Close() error {
+	if ca.lock == nil {
+		return nil
+	}
+	ca.lock.Close()
+	err := os.Remove(ca.lock.Name())
+	ca.lock = nil
+	return err
+}
+
+// Certificate returns the CA certificate.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// Dir returns the CA folder.
+func (ca *CA) Dir() string {
+	return ca.dir
+}
+
+// Request describes the certificate to issue.
+type Request struct {
+	// Name is the subject common name
+	Name           string
+	Kind           Kind
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+	// Validity default is DefaultCertValidity, it's limited to the CA validity
+	Validity time.Duration
+}
+
+// Issued is a new certificate with its private key.
+type Issued struct {
+	Record
+	Certificate *x509.Certificate
+	// CertPEM and KeyPEM are the PEM encoded certificate and key
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// Issue generates a key and signs a certificate for it.
+func (ca *CA) Issue(req Request) (*Issued, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	issued, err := ca.Sign(req, &key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	issued.KeyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	return issued, nil
+}
+
+// Sign issues a certificate for the public key and records it in the index.
+func (ca *CA) Sign(req Request, pub crypto.PublicKey) (*Issued, error) {
+	if req.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if req.Validity <= 0 {
+		req.Validity = DefaultCertValidity
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: req.Name},
+		NotBefore:      now.Add(-time.Minute),
+		NotAfter:       now.Add(req.Validity),
+		DNSNames:       req.DNSNames,
+		IPAddresses:    req.IPAddresses,
+		EmailAddresses: req.EmailAddresses,
+		URIs:           req.URIs,
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+	}
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+
+	switch req.Kind {
+	case KindServer:
+		// servers need a name to be verified by the clients
+		if len(template.DNSNames) == 0 && len(template.IPAddresses) == 0 {
+			template.DNSNames = []string{req.Name}
+		}
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	case KindClient:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	default:
+		return nil, fmt.Errorf("unknown kind %q, expected %q or %q", req.Kind, KindServer, KindClient)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, pub, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	record := Record{
+		Serial:         cert.SerialNumber.String(),
+		Name:           req.Name,
+		Kind:           req.Kind,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+	}
+	for _, ip := range cert.IPAddresses {
+		record.IPAddresses = append(record.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		record.URIs = append(record.URIs, uri.String())
+	}
+
+	ca.index.Certificates = append(ca.index.Certificates, record)
+	if err := ca.save(); err != nil {
+		return nil, err
+	}
+
+	return &Issued{
+		Record:      record,
+		Certificate: cert,
+		CertPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}, nil
+}
+
+// Revoke revokes the certificate with the serial number, or all valid certificates
+// with the name, and regenerates the CRL. It returns the revoked records.
+func (ca *CA) Revoke(serialOrName string) ([]Record, error) {
+	now := time.Now().UTC()
+	revoked := []Record{}
+
+	for i, record := range ca.index.Certificates {
+		if record.Serial == serialOrName && record.Revoked() {
+			return nil, fmt.Errorf("certificate %s is already revoked", record.Serial)
+		}
+		if record.Serial == serialOrName || (record.Name == serialOrName && !record.Revoked() && !record.Expired(now)) {
+			ca.index.Certificates[i].RevokedAt = &now
+			revoked = append(revoked, ca.index.Certificates[i])
+		}
+	}
+	if len(revoked) == 0 {
+		return nil, fmt.Errorf("no valid certificate with serial or name %q", serialOrName)
+	}
+
+	if err := ca.save(); err != nil {
+		return nil, err
+	}
+	return revoked, ca.WriteCRL()
+}
+
+// WriteCRL generates a new CRL with the revoked certificates, which are not expired.
+func (ca *CA) WriteCRL() error {
+	validity := ca.CRLValidity
+	if validity <= 0 {
+		validity = DefaultCRLValidity
+	}
+
+	now := time.Now()
+	revoked := []pkix.RevokedCertificate{}
+	for _, record := range ca.index.Certificates {
+		if !record.Revoked() || record.Expired(now) {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(record.Serial, 10)
+		if !ok {
+			return fmt.Errorf("invalid serial %q in index", record.Serial)
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: *record.RevokedAt})
+	}
+
+	ca.index.CRLNumber++
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(ca.index.CRLNumber),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(validity),
+		RevokedCertificates: revoked,
+	}, ca.cert, ca.key)
+	if err != nil {
+		return fmt.Errorf("failed to create CRL: %v", err)
+	}
+
+	if err := ca.save(); err != nil {
+		return err
+	}
+	return writePEM(filepath.Join(ca.dir, CRLFile), "X509 CRL", der, 0644)
+}
+
+// List returns the issued certificates, ordered by expiry.
+func (ca *CA) List() []Record {
+	records := append([]Record{}, ca.index.Certificates...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].NotAfter.Before(records[j].NotAfter)
+	})
+	return records
+}
+
+// save writes the index, through a temporary file so it's never left half written.
+func (ca *CA) save() error {
+	data, err := json.MarshalIndent(ca.index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(ca.dir, IndexFile)
+	if err := ioutil.WriteFile(filename+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write index: %v", err)
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// newSerial returns a random 128 bit serial number.
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	return serial, nil
+}
+
+func writeKey(filename string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(filename, "EC PRIVATE KEY", der, 0600)
+}
+
+func writePEM(filename, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(filename, data, perm); err != nil {
+		return fmt.Errorf("failed to write %q: %v", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package ca
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authority, err := Init(dir, "Test CA", 0)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	authority.Close()
+	if _, err := Init(dir, "Test CA", 0); err == nil {
+		t.Error("CA already exists, error should be returned")
+	}
+
+	authority, err = Open(dir)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.Certificate())
+
+	server, err := authority.Issue(Request{Name: "localhost", Kind: KindServer})
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if _, err := tls.X509KeyPair(server.CertPEM, server.KeyPEM); err != nil {
+		t.Errorf("invalid key pair: %v", err)
+	}
+	_, err = server.Certificate.Verify(x509.VerifyOptions{
+		DNSName:   "localhost",
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		t.Errorf("server certificate should be valid for localhost: %v", err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://example.org/reese")
+	client, err := authority.Issue(Request{Name: "Reese", Kind: KindClient, URIs: []*url.URL{spiffe}, Validity: time.Hour})
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	_, err = client.Certificate.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Errorf("client certificate should be valid: %v", err)
+	}
+	if _, err := client.Certificate.Verify(x509.VerifyOptions{Roots: roots}); err == nil {
+		t.Error("client certificate should not be valid for servers")
+	}
+	if len(client.URIs) != 1 || client.URIs[0] != spiffe.String() {
+		t.Errorf("unexpected URIs %v", client.URIs)
+	}
+
+	if _, err := authority.Issue(Request{Name: "Dewey", Kind: "admin"}); err == nil {
+		t.Error("unknown kind, error should be returned")
+	}
+
+	// revoke by name and the CRL has the serial
+	revoked, err := authority.Revoke("Reese")
+	if err != nil || len(revoked) != 1 || revoked[0].Serial != client.Serial {
+		t.Fatalf("expected Reese to be revoked, got %v, %v", revoked, err)
+	}
+	if _, err := authority.Revoke(client.Serial); err == nil {
+		t.Error("already revoked, error should be returned")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, CRLFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(authority.Certificate()); err != nil {
+		t.Errorf("CRL should be signed by the CA: %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.String() != client.Serial {
+		t.Errorf("CRL should have the revoked certificate")
+	}
+	if crl.Number.Int64() != 2 {
+		t.Errorf("expected CRL number 2, got %s", crl.Number)
+	}
+
+	// the CA is used by one process at a time
+	defer func(timeout time.Duration) { LockTimeout = timeout }(LockTimeout)
+	LockTimeout = 100 * time.Millisecond
+	if _, err := Open(dir); err == nil {
+		t.Error("CA is locked, error should be returned")
+	}
+	authority.Close()
+
+	// the index is persisted, and listed by expiry
+	authority, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer authority.Close()
+	records := authority.List()
+	if len(records) != 2 || records[0].Name != "Reese" || !records[0].Revoked() || records[1].Name != "localhost" {
+		t.Errorf("unexpected records %+v", records)
+	}
+}
+
+func TestOpenKeyTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authority, err := Init(dir, "Test CA", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority.Close()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPKCS8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		{Type: "PRIVATE KEY", Bytes: pkcs8},
+		{Type: "PRIVATE KEY", Bytes: edPKCS8},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, KeyFile), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		authority, err := Open(dir)
+		if err != nil {
+			t.Errorf("%s: expected success, got %v", block.Type, err)
+			continue
+		}
+		authority.Close()
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, KeyFile), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}), 0600)
+	if _, err := Open(dir); err == nil || !strings.Contains(err.Error(), "private key") {
+		t.Errorf("invalid key, expected error, got %v", err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authority, err := Init(dir, "Test CA", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://example.org/reese")
+	token, err := authority.CreateToken(Request{Name: "Reese", URIs: []*url.URL{spiffe}}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	expired, _ := authority.CreateToken(Request{Name: "Dewey"}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	// the tokens are persisted, only their hashes
+	data, _ := ioutil.ReadFile(filepath.Join(dir, IndexFile))
+	if strings.Contains(string(data), token) {
+		t.Error("index should not contain the token")
+	}
+	authority.Close()
+	authority, _ = Open(dir)
+	defer authority.Close()
+
+	if _, err := authority.RedeemToken(expired); err != ErrInvalidToken {
+		t.Errorf("expired token, expected ErrInvalidToken, got %v", err)
+	}
+	req, err := authority.RedeemToken(token)
+	if err != nil || req.Name != "Reese" || req.Kind != KindClient || len(req.URIs) != 1 || req.URIs[0].String() != spiffe.String() {
+		t.Errorf("unexpected request %+v, %v", req, err)
+	}
+	if _, err := authority.RedeemToken(token); err != ErrInvalidToken {
+		t.Errorf("used token, expected ErrInvalidToken, got %v", err)
+	}
+}
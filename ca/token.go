@@ -0,0 +1,125 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// DefaultTokenValidity is how long a bootstrap token could be used, when not given.
+const DefaultTokenValidity = 24 * time.Hour
+
+// ErrInvalidToken is returned for unknown, used or expired tokens.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// token is a one-time bootstrap token. Only its hash is stored, with the certificate
+// it allows to be issued.
+type token struct {
+	Hash           string    `json:"hash"`
+	Name           string    `json:"name"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	Expires        time.Time `json:"expires"`
+}
+
+// CreateToken creates a one-time token, which allows a client certificate for req to be
+// signed. Validity and kind of req are ignored, it's always a client certificate.
+func (ca *CA) CreateToken(req Request, ttl time.Duration) (string, error) {
+	if req.Name == "" {
+		return "", errors.New("name is required")
+	}
+	if ttl <= 0 {
+		ttl = DefaultTokenValidity
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	value := hex.EncodeToString(secret)
+
+	t := token{
+		Hash:           hashToken(value),
+		Name:           req.Name,
+		DNSNames:       req.DNSNames,
+		EmailAddresses: req.EmailAddresses,
+		Expires:        time.Now().Add(ttl).UTC(),
+	}
+	for _, ip := range req.IPAddresses {
+		t.IPAddresses = append(t.IPAddresses, ip.String())
+	}
+	for _, uri := range req.URIs {
+		t.URIs = append(t.URIs, uri.String())
+	}
+
+	ca.index.Tokens = append(ca.pendingTokens(), t)
+	if err := ca.save(); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// RedeemToken removes the token and returns the client certificate request it allows.
+func (ca *CA) RedeemToken(value string) (Request, error) {
+	hash := hashToken(value)
+	tokens := ca.pendingTokens()
+
+	for i, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) != 1 {
+			continue
+		}
+
+		ca.index.Tokens = append(tokens[:i], tokens[i+1:]...)
+		if err := ca.save(); err != nil {
+			return Request{}, err
+		}
+		return t.request()
+	}
+
+	return Request{}, ErrInvalidToken
+}
+
+// pendingTokens returns the tokens, which are not expired.
+func (ca *CA) pendingTokens() []token {
+	now := time.Now()
+	tokens := []token{}
+	for _, t := range ca.index.Tokens {
+		if now.Before(t.Expires) {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+func (t token) request() (Request, error) {
+	req := Request{
+		Name:           t.Name,
+		Kind:           KindClient,
+		DNSNames:       t.DNSNames,
+		EmailAddresses: t.EmailAddresses,
+	}
+	for _, value := range t.IPAddresses {
+		req.IPAddresses = append(req.IPAddresses, net.ParseIP(value))
+	}
+	for _, value := range t.URIs {
+		uri, err := url.Parse(value)
+		if err != nil {
+			return Request{}, fmt.Errorf("invalid URI %q in token: %v", value, err)
+		}
+		req.URIs = append(req.URIs, uri)
+	}
+	return req, nil
+}
+
+func hashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -13,9 +13,9 @@ import (
 )
 
 type CertFiles struct {
-	Certificate string
-	Key         string
-	CertAuth    string
+	Certificate string `yaml:"crt"`
+	Key         string `yaml:"key"`
+	CertAuth    string `yaml:"ca"`
 }
 
 func (c CertFiles) getCertificate() (tls.Certificate, error) {
@@ -40,20 +40,29 @@ func (c CertFiles) getCertAuthPool() (*x509.CertPool, error) {
 	return certPool, nil
 }
 
-func getCommonNameFromCtx(ctx context.Context) (string, error) {
+func getPeerCertificate(ctx context.Context) (*x509.Certificate, error) {
 	p, ok := peer.FromContext(ctx)
 	if !ok {
-		return "", errors.New("failed to get peer for context")
+		return nil, errors.New("failed to get peer for context")
 	}
 
 	tlsAuth, ok := p.AuthInfo.(credentials.TLSInfo)
 	if !ok {
-		return "", errors.New("failed to cast AuthInfo to TLSInfo")
+		return nil, errors.New("failed to cast AuthInfo to TLSInfo")
 	}
 
 	if len(tlsAuth.State.PeerCertificates) == 0 {
-		return "", errors.New("no certificate found for the peer")
+		return nil, errors.New("no certificate found for the peer")
 	}
 
-	return tlsAuth.State.PeerCertificates[0].Subject.CommonName, nil
+	return tlsAuth.State.PeerCertificates[0], nil
+}
+
+// peerAddress returns the network address of the client, empty if it's unknown.
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return p.Addr.String()
 }
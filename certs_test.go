@@ -0,0 +1,119 @@
+package alcatraz
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority for the tests, its files are in dir.
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// certFile is the PEM encoded certificate of the CA
+	certFile string
+	serial   int64
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TestCA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	ca := &testCA{dir: dir, cert: cert, key: key, certFile: filepath.Join(dir, "ca.crt"), serial: 1}
+	writePEM(t, ca.certFile, "CERTIFICATE", der)
+	return ca
+}
+
+// issue creates a certificate for the common name, modified by the optional fn.
+// It returns the certificate and the paths to its certificate and key files.
+func (ca *testCA) issue(t *testing.T, cn string, fn func(*x509.Certificate)) (*x509.Certificate, CertFiles) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	if fn != nil {
+		fn(template)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := CertFiles{
+		Certificate: filepath.Join(ca.dir, cn+".crt"),
+		Key:         filepath.Join(ca.dir, cn+".key"),
+		CertAuth:    ca.certFile,
+	}
+	writePEM(t, files.Certificate, "CERTIFICATE", der)
+	writePEM(t, files.Key, "EC PRIVATE KEY", keyDER)
+
+	return cert, files
+}
+
+// writeCRL writes CRL revoking the certificates and returns its path.
+func (ca *testCA) writeCRL(t *testing.T, revoked ...*x509.Certificate) string {
+	list := []pkix.RevokedCertificate{}
+	for _, cert := range revoked {
+		list = append(list, pkix.RevokedCertificate{SerialNumber: cert.SerialNumber, RevocationTime: time.Now()})
+	}
+
+	der, err := ca.cert.CreateCRL(rand.Reader, ca.key, list, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(ca.dir, "ca.crl")
+	writePEM(t, filename, "X509 CRL", der)
+	return filename
+}
+
+func writePEM(t *testing.T, filename, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
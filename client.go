@@ -2,15 +2,10 @@ package alcatraz
 
 import (
 	"context"
-	"crypto/sha256"
 	"crypto/tls"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
@@ -19,28 +14,95 @@ import (
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"gopkg.in/yaml.v2"
 )
 
 type ClientConfig struct {
-	Host            string
-	MonitorFolder   string
-	MonitorInterval time.Duration
-	Certificates    CertFiles
-	ParallelUploads int
-	ChunkSize       int
-	LogLevel        string
+	Host string `yaml:"host"`
+	// MonitorFolder is a shortcut for a single folder without destination prefix
+	MonitorFolder   string        `yaml:"monitor_folder"`
+	Folders         []Folder      `yaml:"folders"`
+	MonitorInterval time.Duration `yaml:"interval"`
+	Certificates    CertFiles     `yaml:"certificates"`
+	// RenewBefore is how long before expiry the certificate is renewed by the server, default is
+	// a third of its validity, negative disables the renewal
+	RenewBefore time.Duration `yaml:"renew_before"`
+	// OCSPStaple is how the OCSP response stapled by the server is checked
+	OCSPStaple      StapleMode `yaml:"ocsp_staple"`
+	ParallelUploads int        `yaml:"parallel"`
+	ChunkSize       int        `yaml:"chunk"`
+	Filter          Filter     `yaml:"filter"`
+	Bandwidth       Bandwidth  `yaml:"bandwidth"`
+	Scheduling      Scheduling `yaml:"scheduling"`
+	// StatusInterval is how often the queue status is logged, 0 disables it
+	StatusInterval time.Duration `yaml:"status_interval"`
+	LogLevel       string        `yaml:"log"`
 }
 
+// LoadClientConfig fills config with the values from a YAML file.
+// Settings missing in the file are left unchanged.
+func LoadClientConfig(filename string, config *ClientConfig) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("failed to parse config file %q: %v", filename, err)
+	}
+
+	return nil
+}
+
+// DefaultChunkSize is used when ClientConfig.ChunkSize is not set.
+const DefaultChunkSize = 32000
+
 type Client struct {
 	ClientConfig
-	cli   pb.AlcatrazClient
-	creds credentials.TransportCredentials
+	conn    *grpc.ClientConn
+	cli     pb.AlcatrazClient
+	creds   credentials.TransportCredentials
+	folders []*watchedFolder
+	limiter *rateLimiter
+	queue   *uploadQueue
+
+	// certMu guards the certificate, which could be renewed
+	certMu      sync.RWMutex
+	certificate tls.Certificate
 }
 
+// uploadTask is a file found by the monitor, waiting to be uploaded.
+type uploadTask struct {
+	folder   *watchedFolder
+	filename string
+	size     int64
+	modTime  time.Time
+	queued   time.Time
+	priority int
+}
+
+// NewClient creates a client. Folders are required only for Run.
 func NewClient(config ClientConfig) (*Client, error) {
-	// check if folder exists
-	if _, err := os.Stat(config.MonitorFolder); os.IsNotExist(err) {
-		return nil, fmt.Errorf("folder %q does not exist", config.MonitorFolder)
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = DefaultChunkSize
+	}
+
+	folders := config.Folders
+	if config.MonitorFolder != "" {
+		folders = append([]Folder{{Path: config.MonitorFolder}}, folders...)
+	}
+
+	// check if folders exist and compile their settings
+	watched := []*watchedFolder{}
+	for _, folder := range folders {
+		w, err := newWatchedFolder(folder, config)
+		if err != nil {
+			return nil, err
+		}
+		watched = append(watched, w)
+	}
+	if err := checkOverlap(watched); err != nil {
+		return nil, err
 	}
 
 	// set logging level
@@ -50,6 +112,17 @@ func NewClient(config ClientConfig) (*Client, error) {
 	}
 	logrus.SetLevel(lvl)
 
+	// the limiter is shared by all uploading goroutines
+	limiter, err := newRateLimiter(config.Bandwidth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bandwidth: %v", err)
+	}
+
+	queue, err := newUploadQueue(config.Scheduling, config.ParallelUploads)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scheduling: %v", err)
+	}
+
 	// Load the client certificate
 	certificate, err := config.Certificates.getCertificate()
 	if err != nil {
@@ -62,40 +135,75 @@ func NewClient(config ClientConfig) (*Client, error) {
 		return nil, fmt.Errorf("could not get cert auth pool: %v", err)
 	}
 
-	// Create the TLS credentials
-	creds := credentials.NewTLS(&tls.Config{
-		Certificates: []tls.Certificate{certificate},
-		RootCAs:      certPool,
-	})
+	if !validStapleMode(config.OCSPStaple) {
+		return nil, fmt.Errorf("unknown ocsp staple mode %q, expected %q or %q", config.OCSPStaple, StapleVerify, StapleRequire)
+	}
 
-	return &Client{
+	c := &Client{
 		ClientConfig: config,
 		cli:          nil,
-		creds:        creds,
-	}, nil
+		folders:      watched,
+		limiter:      limiter,
+		queue:        queue,
+		certificate:  certificate,
+	}
+
+	// Create the TLS credentials, the certificate is taken on every handshake, so it could be renewed
+	c.creds = credentials.NewTLS(&tls.Config{
+		GetClientCertificate: c.clientCertificate,
+		RootCAs:              certPool,
+		VerifyConnection:     verifyStaple(config.OCSPStaple),
+	})
+
+	return c, nil
 }
 
-func (c *Client) Run(ctx context.Context) error {
-	// open connection to the server
-	conn, err := grpc.Dial(c.Host, grpc.WithTransportCredentials(c.creds))
+// Connect opens the connection to the server. It's required before Upload,
+// Run calls it by itself.
+func (c *Client) Connect() error {
+	conn, err := grpc.Dial(c.Host, grpc.WithTransportCredentials(c.creds), grpc.WithUnaryInterceptor(retryLimited))
 	if err != nil {
 		return fmt.Errorf("failed to dial server on host %q: %v", c.Host, err)
 	}
-	defer conn.Close()
 
+	c.conn = conn
 	c.cli = pb.NewAlcatrazClient(conn)
+
+	return nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Run monitors the folders and uploads the found files, until ctx is canceled.
+func (c *Client) Run(ctx context.Context) error {
+	if len(c.folders) == 0 {
+		return fmt.Errorf("no folders to monitor")
+	}
+
+	// open connection to the server
+	if err := c.Connect(); err != nil {
+		return err
+	}
+	defer c.Close()
 	log.Infof("Opened connection with %s", c.Host)
 
+	go c.renewCertificate(ctx)
+
 	// create channels for the monitoring and uploading goroutines
-	upload := make(chan string, 100)
-	uploaded := make(chan string, 100)
-	failed := make(chan string, 100)
+	uploaded := make(chan *uploadTask, 100)
+	failed := make(chan *uploadTask, 100)
 
 	// run 1 monitor goroutine
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		c.monitor(ctx, upload, uploaded, failed)
+		c.monitor(ctx, uploaded, failed)
 		wg.Done()
 	}()
 
@@ -103,7 +211,7 @@ func (c *Client) Run(ctx context.Context) error {
 	for i := 0; i < c.ParallelUploads; i++ {
 		wg.Add(1)
 		go func() {
-			c.upload(ctx, upload, uploaded, failed)
+			c.upload(ctx, uploaded, failed)
 			wg.Done()
 		}()
 	}
@@ -117,142 +225,136 @@ func (c *Client) Run(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) monitor(ctx context.Context, upload chan<- string, uploaded, failed <-chan string) {
+// Status returns a snapshot of the upload queue.
+func (c *Client) Status() QueueStatus {
+	return c.queue.status()
+}
+
+func (c *Client) monitor(ctx context.Context, uploaded, failed <-chan *uploadTask) {
 	uploadingFiles := map[string]struct{}{}
+	// modification times of the uploaded files which were kept, the files not found anymore
+	// are removed on every check
+	keptFiles := map[string]time.Time{}
+
+	interval := c.MonitorInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	// nil channel blocks forever, so disabled status is never logged
+	var status <-chan time.Time
+	if c.StatusInterval > 0 {
+		ticker := time.NewTicker(c.StatusInterval)
+		defer ticker.Stop()
+		status = ticker.C
+	}
 
 	timer := time.NewTimer(1)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case file := <-uploaded:
-			if err := os.Remove(file); err != nil {
-				log.Errorf("Failed to delete file %q. Error: %v", file, err)
+		case <-status:
+			log.Infof("Status: %v", c.queue.status())
+		case task := <-uploaded:
+			if err := task.folder.dispose(task.filename); err != nil {
+				log.Errorf("Failed to %s file %q. Error: %v", task.folder.Disposition, task.filename, err)
+			}
+			if task.folder.Disposition == DispositionKeep {
+				keptFiles[task.filename] = task.modTime
 			}
-			delete(uploadingFiles, file)
-		case file := <-failed:
-			delete(uploadingFiles, file)
+			delete(uploadingFiles, task.filename)
+		case task := <-failed:
+			delete(uploadingFiles, task.filename)
 		case <-timer.C:
-			err := filepath.Walk(c.MonitorFolder, func(filepath string, fileinfo os.FileInfo, err error) error {
-				if fileinfo.IsDir() {
-					if filepath != c.MonitorFolder {
-						c.removeIfEmpty(filepath)
+			seen, complete := map[string]bool{}, true
+			for _, folder := range c.folders {
+				folder := folder
+				// ignored files and folders are not passed at all, they stay untouched
+				err := folder.filter.walk(folder.Path, func(filepath string, fileinfo os.FileInfo) error {
+					if fileinfo.IsDir() {
+						if filepath != folder.Path {
+							c.removeIfEmpty(filepath)
+						}
+						return nil
+					}
+
+					if modTime, ok := keptFiles[filepath]; ok {
+						if modTime.Equal(fileinfo.ModTime()) {
+							seen[filepath] = true
+							return nil
+						}
+						// modified, it's uploaded again
+						delete(keptFiles, filepath)
+					}
+
+					if _, ok := uploadingFiles[filepath]; !ok {
+						c.queue.push(&uploadTask{
+							folder:   folder,
+							filename: filepath,
+							size:     fileinfo.Size(),
+							modTime:  fileinfo.ModTime(),
+						})
+						uploadingFiles[filepath] = struct{}{}
 					}
 					return nil
+				})
+				if err != nil {
+					complete = false
+					log.Errorf("Failed to monitor folder %q: %v", folder.Path, err)
 				}
-
-				if _, ok := uploadingFiles[filepath]; !ok {
-					// if upload channel is full, we don't want to block monitor goroutine
-					go func() { upload <- filepath }()
-					uploadingFiles[filepath] = struct{}{}
+			}
+			if complete {
+				for filename := range keptFiles {
+					if !seen[filename] {
+						delete(keptFiles, filename)
+					}
 				}
-				return err
-			})
-			if err != nil {
-				log.Errorf("Failed to monitor folder: %v", err)
 			}
 
-			timer.Reset(5 * time.Second)
+			timer.Reset(interval)
 		}
 	}
 }
 
-func (c *Client) upload(ctx context.Context, upload <-chan string, uploaded, failed chan<- string) {
+func (c *Client) upload(ctx context.Context, uploaded, failed chan<- *uploadTask) {
 	for {
-		select {
-		case <-ctx.Done():
+		task, err := c.queue.pop(ctx)
+		if err != nil {
 			return
-		case file := <-upload:
-			log.Debugf("Uploading %q...", file)
-			if err := c.uploadFile(ctx, file); err != nil {
-				failed <- file
-				log.Errorf("Failed to upload %q. Error: %v", file, err)
-			} else {
-				uploaded <- file
-				log.Debugf("File %q was uploaded.", file)
-			}
 		}
-	}
-}
 
-func (c *Client) uploadFile(ctx context.Context, filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open the file: %v", err)
-	}
-	defer file.Close()
-
-	// create stream for uploading the file
-	stream, err := c.cli.UploadFile(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create upload stream: %v", err)
-	}
-
-	// stream the file
-	if err := c.streamFile(file, stream); err != nil {
-		// even if the streaming is not successful, we want to close and recieve message from the server
-		// the server might have some useful error, that he sent for the client
-		if _, closeErr := stream.CloseAndRecv(); closeErr != nil {
-			return fmt.Errorf("failed stream the file, stream error: %v, close error: %v", err, closeErr)
+		log.Debugf("Uploading %q with priority %d...", task.filename, task.priority)
+		err = c.uploadFile(ctx, task)
+		c.queue.done(task)
+		if err != nil {
+			failed <- task
+			log.Errorf("Failed to upload %q. Error: %v", task.filename, err)
+		} else {
+			uploaded <- task
+			log.Debugf("File %q was uploaded.", task.filename)
 		}
-		return fmt.Errorf("failed to stream the file: %v", err)
 	}
-
-	// close the stream
-	if _, err := stream.CloseAndRecv(); err != nil {
-		return fmt.Errorf("failed to close the stream: %v", err)
-	}
-
-	return nil
 }
 
-func (c *Client) streamFile(file *os.File, stream pb.Alcatraz_UploadFileClient) error {
-	// always send the filename first
-	err := stream.Send(&pb.UploadRequest{
-		TestOneof: &pb.UploadRequest_Name{
-			Name: strings.TrimPrefix(file.Name(), c.MonitorFolder),
-		},
-	})
+func (c *Client) uploadFile(ctx context.Context, task *uploadTask) error {
+	name, err := task.folder.remoteName(task.filename)
 	if err != nil {
-		return fmt.Errorf("failed to send file's name: %v", err)
+		return err
 	}
 
-	// send the file data chunk by chunk
-	hash := sha256.New()
-	for {
-		chunk := make([]byte, c.ChunkSize)
-		if _, err := file.Read(chunk); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return fmt.Errorf("failed to read chunk from file: %v", err)
-		}
-
-		if _, err := hash.Write(chunk); err != nil {
-			return fmt.Errorf("failed to write to hash: %v", err)
-		}
-
-		err = stream.Send(&pb.UploadRequest{
-			TestOneof: &pb.UploadRequest_Chunk{
-				Chunk: chunk,
-			},
-		})
-		if err != nil {
-			return fmt.Errorf("failed to send chunck: %v", err)
-		}
-	}
-
-	// always send the hash last
-	err = stream.Send(&pb.UploadRequest{
-		TestOneof: &pb.UploadRequest_Hash{
-			Hash: hex.EncodeToString(hash.Sum(nil)),
-		},
-	})
+	file, err := os.Open(task.filename)
 	if err != nil {
-		return fmt.Errorf("failed to send file's hash: %v", err)
+		return fmt.Errorf("failed to open the file: %v", err)
 	}
+	defer file.Close()
 
-	return nil
+	opts := uploadOptions{
+		size:      task.size,
+		chunkSize: task.folder.ChunkSize,
+		limiters:  []*rateLimiter{c.limiter, task.folder.limiter},
+	}
+	return c.send(ctx, name, file, opts)
 }
 
 func (c *Client) removeIfEmpty(dirname string) error {
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/avalchev94/alcatraz/ca"
+)
+
+// stringList is a flag which could be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+const usage = `usage: alcatraz-ca <command> [flags]
+
+commands:
+  init             create a new certificate authority
+  issue <name>     issue a server or client certificate
+  token <name>     create a one-time enrollment token for a client
+  revoke <serial>  revoke a certificate by serial number, or all valid ones by name
+  crl              regenerate the certificate revocation list
+  list             list the issued certificates
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func([]string) error{
+		"init":   initCA,
+		"issue":  issue,
+		"token":  createToken,
+		"revoke": revoke,
+		"crl":    crl,
+		"list":   list,
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "alcatraz-ca %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet creates the command's flags with the CA folder flag.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("alcatraz-ca "+name, flag.ExitOnError)
+	dir := fs.String("dir", "ca", "the certificate authority folder")
+	return fs, dir
+}
+
+// parseArgs parses the flags, allowing them after the positional arguments, which are returned.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	positional := []string{}
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func initCA(args []string) error {
+	fs, dir := newFlagSet("init")
+	name := fs.String("name", "Alcatraz CA", "common name of the certificate authority")
+	validity := fs.Duration("validity", ca.DefaultCAValidity, "validity of the CA certificate")
+	parseArgs(fs, args)
+
+	authority, err := ca.Init(*dir, *name, *validity)
+	if err != nil {
+		return err
+	}
+	defer authority.Close()
+
+	fmt.Printf("Created %q in %s, valid until %s\n", *name, *dir, authority.Certificate().NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func issue(args []string) error {
+	fs, dir := newFlagSet("issue")
+	server := fs.Bool("server", false, "issue a server certificate, default is a client one")
+	validity := fs.Duration("validity", ca.DefaultCertValidity, "validity of the certificate")
+	out := fs.String("out", "", "folder for the certificate and key, default is the CA folder")
+	request := requestFlags(fs)
+	names := parseArgs(fs, args)
+
+	req, err := request(names)
+	if err != nil {
+		return err
+	}
+	req.Kind = ca.KindClient
+	req.Validity = *validity
+	if *server {
+		req.Kind = ca.KindServer
+	}
+
+	authority, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	defer authority.Close()
+
+	if *out == "" {
+		*out = *dir
+	}
+	certFile := filepath.Join(*out, req.Name+".crt")
+	keyFile := filepath.Join(*out, req.Name+".key")
+	if _, err := os.Stat(keyFile); err == nil {
+		return fmt.Errorf("%s already exists", keyFile)
+	}
+
+	issued, err := authority.Issue(req)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*out, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, issued.KeyPEM, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certFile, issued.CertPEM, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Issued %s certificate %s for %q, valid until %s\n  %s\n  %s\n", issued.Kind, issued.Serial,
+		issued.Name, issued.NotAfter.Format(time.RFC3339), certFile, keyFile)
+	return nil
+}
+
+// requestFlags defines the subject alternative name flags. The returned function
+// builds the request for the name, given as the only positional argument.
+func requestFlags(fs *flag.FlagSet) func(names []string) (ca.Request, error) {
+	dnsNames, ips, emails, uris := &stringList{}, &stringList{}, &stringList{}, &stringList{}
+	fs.Var(dnsNames, "dns", "DNS name, could be given multiple times")
+	fs.Var(ips, "ip", "IP address, could be given multiple times")
+	fs.Var(emails, "email", "email address, could be given multiple times")
+	fs.Var(uris, "uri", "URI, like SPIFFE ID spiffe://trust-domain/path, could be given multiple times")
+
+	return func(names []string) (ca.Request, error) {
+		if len(names) != 1 {
+			return ca.Request{}, fmt.Errorf("expected one name, got %d", len(names))
+		}
+
+		req := ca.Request{Name: names[0], DNSNames: *dnsNames, EmailAddresses: *emails}
+		for _, value := range *ips {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return ca.Request{}, fmt.Errorf("invalid IP address %q", value)
+			}
+			req.IPAddresses = append(req.IPAddresses, ip)
+		}
+		for _, value := range *uris {
+			uri, err := url.Parse(value)
+			if err != nil || uri.Scheme == "" {
+				return ca.Request{}, fmt.Errorf("invalid URI %q", value)
+			}
+			req.URIs = append(req.URIs, uri)
+		}
+		return req, nil
+	}
+}
+
+func createToken(args []string) error {
+	fs, dir := newFlagSet("token")
+	ttl := fs.Duration("ttl", ca.DefaultTokenValidity, "how long the token could be used")
+	request := requestFlags(fs)
+	names := parseArgs(fs, args)
+
+	req, err := request(names)
+	if err != nil {
+		return err
+	}
+
+	authority, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	defer authority.Close()
+
+	token, err := authority.CreateToken(req, *ttl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Token for %q, valid for %s:\n", req.Name, *ttl)
+	fmt.Println(token)
+	return nil
+}
+
+func revoke(args []string) error {
+	fs, dir := newFlagSet("revoke")
+	targets := parseArgs(fs, args)
+	if len(targets) == 0 {
+		return fmt.Errorf("expected serial number or name")
+	}
+
+	authority, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	defer authority.Close()
+
+	for _, target := range targets {
+		revoked, err := authority.Revoke(target)
+		if err != nil {
+			return err
+		}
+		for _, record := range revoked {
+			fmt.Printf("Revoked %s certificate %s for %q\n", record.Kind, record.Serial, record.Name)
+		}
+	}
+
+	fmt.Printf("CRL written to %s\n", filepath.Join(*dir, ca.CRLFile))
+	return nil
+}
+
+func crl(args []string) error {
+	fs, dir := newFlagSet("crl")
+	validity := fs.Duration("validity", ca.DefaultCRLValidity, "validity of the CRL, it must be regenerated before it expires")
+	parseArgs(fs, args)
+
+	authority, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	defer authority.Close()
+	authority.CRLValidity = *validity
+
+	if err := authority.WriteCRL(); err != nil {
+		return err
+	}
+
+	fmt.Printf("CRL written to %s, valid until %s\n", filepath.Join(*dir, ca.CRLFile),
+		time.Now().Add(*validity).Format(time.RFC3339))
+	return nil
+}
+
+func list(args []string) error {
+	fs, dir := newFlagSet("list")
+	all := fs.Bool("all", false, "list also the expired and revoked certificates")
+	parseArgs(fs, args)
+
+	authority, err := ca.Open(*dir)
+	if err != nil {
+		return err
+	}
+	defer authority.Close()
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tKIND\tNAME\tEXPIRES\tSTATUS")
+	for _, record := range authority.List() {
+		status := fmt.Sprintf("valid, %d days left", int(record.NotAfter.Sub(now).Hours()/24))
+		switch {
+		case record.Revoked():
+			status = "revoked at " + record.RevokedAt.Format(time.RFC3339)
+		case record.Expired(now):
+			status = "expired"
+		}
+		if !*all && (record.Revoked() || record.Expired(now)) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", record.Serial, record.Kind, record.Name, record.NotAfter.Format("2006-01-02"), status)
+	}
+	return w.Flush()
+}
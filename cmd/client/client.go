@@ -6,48 +6,175 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/avalchev94/alcatraz"
 )
 
+// stringList is a flag which could be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// commands run once and return the exit code, without command the client watches folders
+var commands = map[string]func([]string) int{
+	"put":    put,
+	"get":    get,
+	"ls":     list,
+	"stat":   stat,
+	"rm":     rm,
+	"mv":     mv,
+	"trash":  trash,
+	"spaces": spaces,
+	"quota":  quota,
+	"hold":   hold,
+	"enroll": enroll,
+}
+
+const usage = `Usage:
+	alcatraz path_to_folder [flags]
+	alcatraz -config=config.yaml [flags]
+	alcatraz put file... [-as=remote/name] [flags]
+	alcatraz get remote/name... [-o=file] [flags]
+	alcatraz ls [prefix] [flags]
+	alcatraz stat remote/name... [-version=id|time] [flags]
+	alcatraz rm remote/name... [flags]
+	alcatraz mv remote/name remote/new_name [flags]
+	alcatraz trash [prefix] [flags]
+	alcatraz trash -restore remote/name... [-id=id] [flags]
+	alcatraz spaces [flags]
+	alcatraz quota [@space] [flags]
+	alcatraz hold owner [name] [-reason=text|-release] [flags]
+	alcatraz enroll -token=TOKEN [flags]
+`
+
 func main() {
-	var (
-		host     = flag.String("host", "localhost:8080", "the address of the Alcatraz server")
-		cert     = flag.String("crt", "", "path to the client certificate")
-		key      = flag.String("key", "", "path to client private key")
-		ca       = flag.String("ca", "", "path to the Certificate Authority certificate")
-		parallel = flag.Int("parallel", 30, "maximum number of parallely processed files")
-		interval = flag.Duration("interval", 5*time.Second, "folder monitoring interval")
-		chunk    = flag.Int("chunk", 32000, "size(in bytes) of the chunk unit(files are divided to chunks when uploaded)")
-		log      = flag.String("log", "info", "log level: info or debug")
-	)
-
-	if len(os.Args) < 2 {
-		fmt.Printf("Usage:\n\talcatraz path_to_folder\n")
-		os.Exit(1)
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
 	}
+	watch(os.Args[1:])
+}
 
-	flag.CommandLine.Parse(os.Args[2:])
-
-	cfg := alcatraz.ClientConfig{
-		Host:            *host,
-		MonitorFolder:   os.Args[1],
-		MonitorInterval: *interval,
-		Certificates: alcatraz.CertFiles{
-			Certificate: *cert,
-			Key:         *key,
-			CertAuth:    *ca,
-		},
-		ParallelUploads: *parallel,
-		ChunkSize:       *chunk,
-		LogLevel:        *log,
+// commonFlags defines the flags shared by all commands. It returns the config file flag.
+func commonFlags(fs *flag.FlagSet, cfg *alcatraz.ClientConfig) *string {
+	config := fs.String("config", "", "path to YAML config file, flags take precedence over it")
+	fs.StringVar(&cfg.Host, "host", "localhost:8080", "the address of the Alcatraz server")
+	fs.StringVar(&cfg.Certificates.Certificate, "crt", "", "path to the client certificate")
+	fs.StringVar(&cfg.Certificates.Key, "key", "", "path to client private key")
+	fs.StringVar(&cfg.Certificates.CertAuth, "ca", "", "path to the Certificate Authority certificate")
+	fs.StringVar((*string)(&cfg.OCSPStaple), "ocsp-staple", "", "check the OCSP response stapled by the server: verify or require")
+	fs.IntVar(&cfg.ChunkSize, "chunk", alcatraz.DefaultChunkSize, "size(in bytes) of the chunk unit(files are divided to chunks when uploaded)")
+	fs.Int64Var(&cfg.Bandwidth.Limit, "bwlimit", 0, "upload bandwidth limit in bytes per second, 0 means unlimited")
+	fs.StringVar(&cfg.LogLevel, "log", "info", "log level: info or debug")
+	return config
+}
+
+// parseArgs parses the flags, allowing them to be mixed with the positional arguments,
+// which are returned. If config file is given, it's loaded and the flags are parsed again,
+// so that the given ones override the config file. reset is called before that.
+func parseArgs(fs *flag.FlagSet, args []string, cfg *alcatraz.ClientConfig, config *string, reset func()) []string {
+	parse := func() []string {
+		positional := []string{}
+		rest := args
+		for {
+			fs.Parse(rest)
+			rest = fs.Args()
+			if len(rest) == 0 {
+				return positional
+			}
+			positional = append(positional, rest[0])
+			rest = rest[1:]
+		}
 	}
 
+	positional := parse()
+	if *config != "" {
+		if err := alcatraz.LoadClientConfig(*config, cfg); err != nil {
+			fmt.Printf("Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+
+		reset()
+		parse()
+	}
+
+	return positional
+}
+
+// connect creates the client for the one-time commands, without folders, and connects it.
+func connect(cfg alcatraz.ClientConfig) (*alcatraz.Client, error) {
+	cfg.MonitorFolder, cfg.Folders = "", nil
+	client, err := alcatraz.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Alcatraz client: %v", err)
+	}
+
+	if err := client.Connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	return client, nil
+}
+
+// interruptible returns a context, canceled on interrupt.
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	go func() {
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(quit)
+	}()
+
+	return ctx, cancel
+}
+
+func watch(args []string) {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+
+	includes, excludes := stringList{}, stringList{}
+	fs.IntVar(&cfg.ParallelUploads, "parallel", 30, "maximum number of parallely processed files")
+	fs.DurationVar(&cfg.MonitorInterval, "interval", 5*time.Second, "folder monitoring interval")
+	fs.Var(&includes, "include", "upload only files matching the pattern(gitignore syntax), could be repeated")
+	fs.Var(&excludes, "exclude", "do not upload files matching the pattern(gitignore syntax), could be repeated")
+	fs.Int64Var(&cfg.Filter.MinSize, "min-size", 0, "minimum size(in bytes) of uploaded files")
+	fs.Int64Var(&cfg.Filter.MaxSize, "max-size", 0, "maximum size(in bytes) of uploaded files, 0 means no limit")
+	fs.DurationVar(&cfg.RenewBefore, "renew-before", 0, "renew the certificate this long before it expires, default is a third of its validity, negative disables it")
+	fs.DurationVar(&cfg.StatusInterval, "status", time.Minute, "how often the upload queue status is logged, 0 disables it")
+
+	// the folder is optional when the config file lists the folders
+	folders := parseArgs(fs, args, &cfg, config, func() { includes, excludes = nil, nil })
+	if len(folders) > 0 {
+		cfg.MonitorFolder = folders[0]
+	}
+	if cfg.MonitorFolder == "" && len(cfg.Folders) == 0 {
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+	cfg.Filter.Include = append(cfg.Filter.Include, includes...)
+	cfg.Filter.Exclude = append(cfg.Filter.Exclude, excludes...)
+
 	client, err := alcatraz.NewClient(cfg)
 	if err != nil {
 		fmt.Printf("Failed to create Alcatraz client: %v\n", err)
+		os.Exit(1)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/avalchev94/alcatraz"
+)
+
+// enroll gets the client certificate with a one-time token and returns the exit code.
+func enroll(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz enroll", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+	token := fs.String("token", "", "the one-time enrollment token")
+	timeout := fs.Duration("timeout", time.Minute, "how long to wait for the server")
+
+	parseArgs(fs, args, &cfg, config, func() {})
+	if *token == "" || cfg.Certificates.Certificate == "" || cfg.Certificates.Key == "" || cfg.Certificates.CertAuth == "" {
+		fmt.Printf("Usage:\n\talcatraz enroll -token=TOKEN -crt=client.crt -key=client.key -ca=ca.crt [flags]\n")
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if err := alcatraz.Enroll(ctx, cfg, *token); err != nil {
+		fmt.Printf("Failed to enroll: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Enrolled, certificate written to %s and key to %s\n", cfg.Certificates.Certificate, cfg.Certificates.Key)
+	return 0
+}
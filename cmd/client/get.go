@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/avalchev94/alcatraz"
+)
+
+// get downloads the given remote files and returns the exit code. Names starting with
+// "@space/" are in the shared space.
+func get(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz get", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+	out := fs.String("o", "", "output file, - for the standard output, if it ends with / it's a folder for all files")
+	version := fs.String("version", "", "old version to download, a version ID or a RFC3339 time")
+
+	names := parseArgs(fs, args, &cfg, config, func() {})
+	if len(names) == 0 {
+		fmt.Printf("Usage:\n\talcatraz get remote/name... [-o=file] [-version=id] [flags]\n\talcatraz get @space/name [-o=file] [flags]\n")
+		return 2
+	}
+
+	folder := *out == "" || os.IsPathSeparator((*out)[len(*out)-1])
+	if !folder && len(names) > 1 {
+		fmt.Println("-o with multiple files must be a folder ending with /")
+		return 2
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	code := 0
+	for _, name := range names {
+		filename := *out
+		if folder {
+			filename = filepath.Join(*out, path.Base(name))
+		}
+
+		options := []alcatraz.DownloadOption{}
+		if *version != "" {
+			options = append(options, alcatraz.WithVersion(*version))
+		}
+		if err := getFile(ctx, client, name, filename, options...); err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL  %s: %v\n", name, err)
+			code = 1
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "OK    %s -> %s\n", name, filename)
+	}
+
+	return code
+}
+
+// getFile downloads to a temporary file, which is renamed when the download is verified.
+func getFile(ctx context.Context, client *alcatraz.Client, name, filename string, options ...alcatraz.DownloadOption) error {
+	if filename == "-" {
+		_, err := client.Download(ctx, name, os.Stdout, options...)
+		return err
+	}
+
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	file, err := os.Create(filename + ".part")
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Download(ctx, name, file, options...)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// list prints the remote files under the prefix and returns the exit code.
+func list(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz ls", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+	versions := fs.Bool("versions", false, "list the old versions of the files too")
+
+	prefixes := parseArgs(fs, args, &cfg, config, func() {})
+	if len(prefixes) > 1 {
+		fmt.Printf("Usage:\n\talcatraz ls [prefix] [-versions] [flags]\n\talcatraz ls @space [flags]\n")
+		return 2
+	}
+	prefix := ""
+	if len(prefixes) == 1 {
+		prefix = prefixes[0]
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	options := []alcatraz.ListOption{}
+	if *versions {
+		options = append(options, alcatraz.WithVersions())
+	}
+	files, err := client.List(ctx, prefix, options...)
+	if err != nil {
+		fmt.Printf("Failed to list: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, file := range files {
+		if *versions {
+			current := ""
+			if file.Current {
+				current = " (current)"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s%s\n", file.Size, file.Modified.Local().Format(time.RFC3339), file.Version, file.Name, current)
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", file.Size, file.Modified.Local().Format(time.RFC3339), file.Name)
+	}
+	w.Flush()
+	return 0
+}
+
+// stat prints the indexed metadata of the remote files and returns the exit code.
+func stat(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz stat", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+	version := fs.String("version", "", "version ID, or RFC3339 time for the last version stored at or before it")
+
+	names := parseArgs(fs, args, &cfg, config, func() {})
+	if len(names) == 0 {
+		fmt.Printf("Usage:\n\talcatraz stat remote/name... [-version=id|time] [flags]\n")
+		return 2
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	code := 0
+	for _, name := range names {
+		info, err := client.Stat(ctx, name, alcatraz.WithVersion(*version))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL  %s: %v\n", name, err)
+			code = 1
+			continue
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "name:\t%s\n", info.Name)
+		fmt.Fprintf(w, "size:\t%d\n", info.Size)
+		fmt.Fprintf(w, "uploaded:\t%s\n", info.Modified.Local().Format(time.RFC3339))
+		fmt.Fprintf(w, "version:\t%s\n", info.Version)
+		fmt.Fprintf(w, "%s:\t%s\n", info.Algorithm, info.Hash)
+		fmt.Fprintf(w, "uploaded by:\t%s\n", info.UploadedBy)
+		fmt.Fprintf(w, "address:\t%s\n", info.Address)
+		for key, value := range info.Metadata {
+			fmt.Fprintf(w, "%s:\t%s\n", key, value)
+		}
+		w.Flush()
+	}
+	return code
+}
+
+// spaces prints the shared spaces of the client and returns the exit code.
+func spaces(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz spaces", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+	parseArgs(fs, args, &cfg, config, func() {})
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	spaces, err := client.Spaces(ctx)
+	if err != nil {
+		fmt.Printf("Failed to get spaces: %v\n", err)
+		return 1
+	}
+
+	for _, space := range spaces {
+		fmt.Printf("@%s\t%s\n", space.Name, space.Role)
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/avalchev94/alcatraz"
+)
+
+// hold places, releases or lists the legal holds on the files of a client or a space, and
+// returns the exit code. It requires the admin permission.
+func hold(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz hold", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+	reason := fs.String("reason", "", "why the files are held, required to place a hold")
+	release := fs.Bool("release", false, "release the hold instead of placing it")
+
+	positional := parseArgs(fs, args, &cfg, config, func() {})
+	if len(positional) == 0 || len(positional) > 2 || (*reason != "" && *release) {
+		fmt.Printf("Usage:\n\talcatraz hold owner [name] -reason=text [flags]\n\talcatraz hold owner [name] -release [flags]\n\talcatraz hold owner [flags]\n")
+		return 2
+	}
+	owner, name := positional[0], ""
+	if len(positional) == 2 {
+		name = positional[1]
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	var holds []alcatraz.Hold
+	switch {
+	case *release:
+		holds, err = client.ReleaseHold(ctx, owner, name)
+	case *reason != "":
+		holds, err = client.PlaceHold(ctx, owner, name, *reason)
+	default:
+		holds, err = client.Holds(ctx, owner)
+	}
+	if err != nil {
+		fmt.Printf("Failed to hold: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, h := range holds {
+		name := h.Name
+		if name == "" {
+			name = "(all files)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, h.Placed.Local().Format(time.RFC3339), h.By, h.Reason)
+	}
+	w.Flush()
+	return 0
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/avalchev94/alcatraz"
+)
+
+// put uploads the given files once and returns the exit code.
+// "-" stands for the standard input. Source files are not deleted.
+func put(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz put", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+	as := fs.String("as", "", "remote name of the uploaded file, if it ends with / it's a prefix for all files")
+	compress := fs.String("compress", "", "compression on the wire: gzip or none")
+	meta := stringList{}
+	fs.Var(&meta, "meta", "key=value metadata attached to the files, could be repeated")
+
+	files := parseArgs(fs, args, &cfg, config, func() { meta = nil })
+	if len(files) == 0 {
+		fmt.Printf("Usage:\n\talcatraz put file... [-as=remote/name] [flags]\n\tcommand | alcatraz put - -as=remote/name [flags]\n")
+		return 2
+	}
+
+	prefix := strings.HasSuffix(*as, "/")
+	if *as != "" && !prefix && len(files) > 1 {
+		fmt.Println("-as with multiple files must be a prefix ending with /")
+		return 2
+	}
+
+	options := []alcatraz.UploadOption{}
+	if *compress != "" && *compress != "none" {
+		options = append(options, alcatraz.WithCompression(alcatraz.Compression(*compress)))
+	}
+	if len(meta) > 0 {
+		metadata := map[string]string{}
+		for _, kv := range meta {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 {
+				fmt.Printf("-meta must be key=value, got %q\n", kv)
+				return 2
+			}
+			metadata[parts[0]] = parts[1]
+		}
+		options = append(options, alcatraz.WithMetadata(metadata))
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	code := 0
+	for _, file := range files {
+		name := *as
+		switch {
+		case file == "-" && (name == "" || prefix):
+			fmt.Printf("FAIL  %s: -as with remote name is required for standard input\n", file)
+			code = 1
+			continue
+		case name == "":
+			name = filepath.Base(file)
+		case prefix:
+			name = path.Join(name, filepath.Base(file))
+		}
+
+		if err := putFile(ctx, client, file, name, options); err != nil {
+			fmt.Printf("FAIL  %s: %v\n", file, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("OK    %s -> %s\n", file, name)
+	}
+
+	return code
+}
+
+func putFile(ctx context.Context, client *alcatraz.Client, filename, name string, options []alcatraz.UploadOption) error {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		r = file
+		options = append(options, alcatraz.WithSize(info.Size()))
+	}
+
+	return client.Upload(ctx, name, r, options...)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/avalchev94/alcatraz"
+)
+
+// quota prints the storage used by the client, or by a shared space, and returns the exit code.
+func quota(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz quota", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+
+	names := parseArgs(fs, args, &cfg, config, func() {})
+	if len(names) > 1 {
+		fmt.Printf("Usage:\n\talcatraz quota [@space] [flags]\n")
+		return 2
+	}
+	name := ""
+	if len(names) == 1 {
+		name = names[0]
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	usage, err := client.Quota(ctx, name)
+	if err != nil {
+		fmt.Printf("Failed to get quota: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("bytes  %s\nfiles  %s\n", usedOf(usage.UsedBytes, usage.Quota.MaxBytes), usedOf(usage.UsedFiles, usage.Quota.MaxFiles))
+	return 0
+}
+
+func usedOf(used, max int64) string {
+	if max == 0 {
+		return fmt.Sprintf("%d of unlimited", used)
+	}
+	return fmt.Sprintf("%d of %d (%d remaining)", used, max, max-used)
+}
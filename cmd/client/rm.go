@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/avalchev94/alcatraz"
+)
+
+// rm moves the given remote files to the trash and returns the exit code.
+func rm(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz rm", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+
+	names := parseArgs(fs, args, &cfg, config, func() {})
+	if len(names) == 0 {
+		fmt.Printf("Usage:\n\talcatraz rm remote/name... [flags]\n")
+		return 2
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	code := 0
+	for _, name := range names {
+		if err := client.Delete(ctx, name); err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL  %s: %v\n", name, err)
+			code = 1
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "OK    %s\n", name)
+	}
+	return code
+}
+
+// mv renames a remote file and returns the exit code.
+func mv(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz mv", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+
+	names := parseArgs(fs, args, &cfg, config, func() {})
+	if len(names) != 2 {
+		fmt.Printf("Usage:\n\talcatraz mv remote/name remote/new_name [flags]\n")
+		return 2
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	if err := client.Move(ctx, names[0], names[1]); err != nil {
+		fmt.Printf("Failed to move: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// trash lists the deleted files, or restores them with -restore, and returns the exit code.
+func trash(args []string) int {
+	cfg := alcatraz.ClientConfig{}
+	fs := flag.NewFlagSet("alcatraz trash", flag.ExitOnError)
+	config := commonFlags(fs, &cfg)
+	restore := fs.Bool("restore", false, "restore the given files to their old names")
+	id := fs.String("id", "", "ID of the deleted file to restore, default is the last deleted one")
+
+	names := parseArgs(fs, args, &cfg, config, func() {})
+	if (*restore && len(names) == 0) || (!*restore && (len(names) > 1 || *id != "")) {
+		fmt.Printf("Usage:\n\talcatraz trash [prefix] [flags]\n\talcatraz trash -restore remote/name... [-id=id] [flags]\n")
+		return 2
+	}
+
+	client, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer client.Close()
+
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	if *restore {
+		code := 0
+		for _, name := range names {
+			if _, err := client.Undelete(ctx, name, *id); err != nil {
+				fmt.Fprintf(os.Stderr, "FAIL  %s: %v\n", name, err)
+				code = 1
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "OK    %s\n", name)
+		}
+		return code
+	}
+
+	prefix := ""
+	if len(names) == 1 {
+		prefix = names[0]
+	}
+	entries, err := client.Trash(ctx, prefix)
+	if err != nil {
+		fmt.Printf("Failed to list trash: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\texpires %s\n", entry.Size, entry.Deleted.Local().Format(time.RFC3339), entry.ID,
+			entry.Name, entry.Expires.Local().Format(time.RFC3339))
+	}
+	w.Flush()
+	return 0
+}
@@ -7,35 +7,127 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/avalchev94/alcatraz"
+	log "github.com/sirupsen/logrus"
 )
 
+// commands run the maintenance tasks instead of the server, with the same config
+var commands = map[string]func(alcatraz.ServerConfig, options) error{
+	"reindex": func(cfg alcatraz.ServerConfig, _ options) error { return alcatraz.Reindex(cfg) },
+	"scrub":   func(cfg alcatraz.ServerConfig, opts options) error { return alcatraz.Scrub(cfg, opts.now) },
+	"disks": func(cfg alcatraz.ServerConfig, _ options) error {
+		_, err := alcatraz.CheckDisks(cfg)
+		return err
+	},
+	"check": func(cfg alcatraz.ServerConfig, opts options) error {
+		if opts.primary == "" {
+			opts.primary = fmt.Sprintf("localhost:%d", cfg.Port)
+		}
+		return alcatraz.CheckReplicas(cfg, opts.primary)
+	},
+}
+
+type options struct {
+	config  string
+	watch   time.Duration
+	now     bool
+	primary string
+}
+
+// loadConfig builds the config from the config file and the flags, which take precedence.
+// It's called again on every reload.
+func loadConfig(args []string) (alcatraz.ServerConfig, options, error) {
+	cfg := alcatraz.ServerConfig{}
+	opts := options{}
+
+	fs := flag.NewFlagSet("alcatrazd", flag.ExitOnError)
+	fs.StringVar(&opts.config, "config", "", "path to YAML config file with the clients, flags take precedence over it")
+	fs.DurationVar(&opts.watch, "watch", 0, "how often the config and certificate files are checked for changes, 0 disables it")
+	fs.IntVar(&cfg.Port, "port", 8080, "port on which Alcatraz server will listen")
+	fs.StringVar(&cfg.StoragePath, "storage", "storage", "path to the storage folder, several are separated like in PATH for mirroring")
+	fs.StringVar(&cfg.Certificates.Certificate, "crt", "", "path to the client certificate")
+	fs.StringVar(&cfg.Certificates.Key, "key", "", "path to client private key")
+	fs.StringVar(&cfg.Certificates.CertAuth, "ca", "", "path to the Certificate Authority certificate")
+	fs.StringVar((*string)(&cfg.Identity.Source), "identity", "cn", "client identity from the certificate: cn, dns, email, spiffe or fingerprint")
+	fs.StringVar(&cfg.CRL, "crl", "", "path to the certificate revocation list")
+	fs.StringVar((*string)(&cfg.OCSP.Mode), "ocsp", "", "check the client certificates with OCSP: soft or hard(reject when the responder is unavailable)")
+	fs.StringVar(&cfg.OCSP.Responder, "ocsp-responder", "", "OCSP responder URL, default is the one in the certificates")
+	fs.BoolVar(&cfg.OCSP.Staple, "ocsp-staple", false, "staple the OCSP status of the server certificate")
+	fs.StringVar(&cfg.Enrollment.CA, "enroll-ca", "", "certificate authority folder for enrolling clients with tokens, created with alcatraz-ca")
+	fs.StringVar(&cfg.AuditLog, "audit-log", "", "path to the audit log file, default is the standard log")
+	fs.StringVar(&cfg.Metrics, "metrics", "", "HTTP address of the metrics, like localhost:9090")
+	fs.StringVar(&opts.primary, "primary", "", "check: address of the running primary server, default is localhost with the port")
+	fs.BoolVar(&opts.now, "now", false, "scrub: verify all files now, not only the ones due by the scrub interval")
+	fs.StringVar(&cfg.LogLevel, "log", "info", "log level: info or debug")
+	fs.Parse(args)
+
+	if opts.config != "" {
+		if err := alcatraz.LoadServerConfig(opts.config, &cfg); err != nil {
+			return cfg, opts, err
+		}
+
+		// parse the flags again, so that the given ones override the config file
+		fs.Parse(args)
+	}
+
+	return cfg, opts, nil
+}
+
+// watchFiles sends to changed when the modification time of any of the files changes.
+func watchFiles(ctx context.Context, interval time.Duration, files []string, changed chan<- os.Signal) {
+	modTimes := func() map[string]time.Time {
+		times := map[string]time.Time{}
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				times[file] = info.ModTime()
+			}
+		}
+		return times
+	}
+
+	last := modTimes()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := modTimes()
+			for file, modTime := range current {
+				if !last[file].Equal(modTime) {
+					log.Infof("File %q changed, reloading...", file)
+					changed <- syscall.SIGHUP
+					break
+				}
+			}
+			last = current
+		}
+	}
+}
+
 func main() {
-	var (
-		port    = flag.Int("port", 8080, "port on which Alcatraz server will listen")
-		storage = flag.String("storage", "storage", "path to the storage folder")
-		cert    = flag.String("crt", "", "path to the client certificate")
-		key     = flag.String("key", "", "path to client private key")
-		ca      = flag.String("ca", "", "path to the Certificate Authority certificate")
-		log     = flag.String("log", "info", "log level: info or debug")
-	)
-	flag.Parse()
-
-	cfg := alcatraz.ServerConfig{
-		Port:        *port,
-		StoragePath: *storage,
-		Certificates: alcatraz.CertFiles{
-			Certificate: *cert,
-			Key:         *key,
-			CertAuth:    *ca,
-		},
-		AllowedClients: map[string]bool{
-			"Malcolm": true,
-			"Dewey":   true,
-			"Reese":   true,
-		},
-		LogLevel: *log,
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			cfg, opts, err := loadConfig(os.Args[2:])
+			if err == nil {
+				err = command(cfg, opts)
+			}
+			if err != nil {
+				fmt.Printf("Failed to %s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	cfg, opts, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Failed to load config: %v\n", err)
+		os.Exit(1)
 	}
 
 	server, err := alcatraz.NewServer(cfg)
@@ -58,7 +150,27 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 
-	<-quit
-	cancel()
-	wg.Wait()
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	if opts.watch > 0 {
+		files := []string{opts.config, cfg.Certificates.Certificate, cfg.Certificates.Key, cfg.Certificates.CertAuth, cfg.CRL}
+		go watchFiles(ctx, opts.watch, files, reload)
+	}
+
+	for {
+		select {
+		case <-reload:
+			cfg, _, err := loadConfig(os.Args[1:])
+			if err == nil {
+				err = server.Reload(cfg)
+			}
+			if err != nil {
+				log.Errorf("Reload failed, keeping the old config: %v", err)
+			}
+		case <-quit:
+			cancel()
+			wg.Wait()
+			return
+		}
+	}
 }
@@ -0,0 +1,143 @@
+package alcatraz
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// revocationList holds the serial numbers from a CRL file. The file is reloaded,
+// when it's modified, on every refresh.
+type revocationList struct {
+	filename string
+	caFile   string
+
+	mu         sync.RWMutex
+	revoked    map[string]time.Time
+	nextUpdate time.Time
+	modTime    time.Time
+}
+
+func newRevocationList(filename, caFile string) (*revocationList, error) {
+	crl := &revocationList{filename: filename, caFile: caFile}
+	if err := crl.refresh(); err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+// refresh reloads the CRL file if it was modified. On error the old list is kept.
+func (r *revocationList) refresh() error {
+	info, err := os.Stat(r.filename)
+	if err != nil {
+		return fmt.Errorf("failed to stat crl: %v", err)
+	}
+
+	r.mu.RLock()
+	modified := !info.ModTime().Equal(r.modTime)
+	r.mu.RUnlock()
+	if !modified {
+		return nil
+	}
+
+	list, err := loadCRL(r.filename, r.caFile)
+	if err != nil {
+		return err
+	}
+
+	revoked := map[string]time.Time{}
+	for _, cert := range list.TBSCertList.RevokedCertificates {
+		revoked[cert.SerialNumber.String()] = cert.RevocationTime
+	}
+
+	r.mu.Lock()
+	r.revoked = revoked
+	r.nextUpdate = list.TBSCertList.NextUpdate
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+
+	log.Infof("Loaded CRL %q with %d revoked certificates", r.filename, len(revoked))
+	if list.HasExpired(time.Now()) {
+		log.Warnf("CRL %q is stale, next update was at %s", r.filename, list.TBSCertList.NextUpdate)
+	}
+
+	return nil
+}
+
+// isRevoked reports if the certificate is revoked and when.
+func (r *revocationList) isRevoked(cert *x509.Certificate) (time.Time, bool) {
+	if r == nil {
+		return time.Time{}, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revokedAt, ok := r.revoked[cert.SerialNumber.String()]
+	return revokedAt, ok
+}
+
+// loadCRL parses the CRL(PEM or DER) and verifies it's signed by one of the certificate authorities.
+func loadCRL(filename, caFile string) (*pkix.CertificateList, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crl: %v", err)
+	}
+
+	list, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse crl %q: %v", filename, err)
+	}
+
+	cas, err := readCertificates(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ca := range cas {
+		if ca.CheckCRLSignature(list) == nil {
+			return list, nil
+		}
+	}
+
+	return nil, fmt.Errorf("crl %q is not signed by the certificate authority", filename)
+}
+
+// readCertificates reads all certificates from a PEM file.
+func readCertificates(filename string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ca certificate: %s", err)
+	}
+
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate in %q: %v", filename, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
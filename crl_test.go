@@ -0,0 +1,93 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb/mock"
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestRevocationList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	reese, _ := ca.issue(t, "Reese", nil)
+	dewey, _ := ca.issue(t, "Dewey", nil)
+
+	crl, err := newRevocationList(ca.writeCRL(t, reese), ca.certFile)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if _, revoked := crl.isRevoked(reese); !revoked {
+		t.Error("Reese should be revoked")
+	}
+	if _, revoked := crl.isRevoked(dewey); revoked {
+		t.Error("Dewey should not be revoked")
+	}
+
+	// refresh reloads the modified file
+	filename := ca.writeCRL(t, reese, dewey)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filename, future, future)
+	if err := crl.refresh(); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if _, revoked := crl.isRevoked(dewey); !revoked {
+		t.Error("Dewey should be revoked after refresh")
+	}
+
+	// CRL from another CA is rejected
+	otherDir := filepath.Join(dir, "other")
+	os.Mkdir(otherDir, 0700)
+	other := newTestCA(t, otherDir)
+	if _, err := newRevocationList(filename, other.certFile); err == nil {
+		t.Error("CRL is signed by another CA, error should be returned")
+	}
+}
+
+func TestAuthClientRevoked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	reese, _ := ca.issue(t, "Reese", nil)
+	crl, err := newRevocationList(ca.writeCRL(t, reese), ca.certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acl, _ := newAccessList(ServerConfig{Clients: []ClientACL{{Identity: "Reese", Permissions: allPermissions}}})
+	server := Server{acl: acl, crl: crl}
+
+	stream := mock.NewMockAlcatraz_UploadFileServer(ctrl)
+	stream.EXPECT().Context().AnyTimes().Return(peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{reese}}},
+	}))
+
+	info := &grpc.StreamServerInfo{FullMethod: "/pb.Alcatraz/UploadFile"}
+	err = server.authClient(nil, stream, info, func(interface{}, grpc.ServerStream) error { return nil })
+	if grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("revoked certificate, expected Unauthenticated, got %v", err)
+	}
+}
@@ -0,0 +1,354 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/empty"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// DownloadFile sends a file from the client's folder or from a shared space, the current
+// or the selected version.
+func (s *Server) DownloadFile(req *pb.DownloadRequest, stream pb.Alcatraz_DownloadFileServer) error {
+	client := s.identityFromCtx(stream.Context())
+
+	name := cleanName(req.GetName())
+	loc, err := s.locate(client, name, false)
+	if err != nil {
+		return err
+	}
+	if loc.name == "" {
+		return grpc.Errorf(codes.InvalidArgument, "expected filename")
+	}
+
+	selected, err := s.selectVersion(loc, req.GetVersion())
+	if err != nil {
+		return err
+	}
+	filename, metadataFile := loc.path(s.StoragePath), s.metadataPath(loc.owner, loc.name)
+	if selected.filename != "" {
+		filename, metadataFile = selected.filename, selected.filename+".json"
+	}
+
+	var file *os.File
+	if selected.filename != "" {
+		file, err = os.Open(filename)
+	} else {
+		file, err = s.openCopy(loc)
+	}
+	if os.IsNotExist(err) {
+		return grpc.Errorf(codes.NotFound, "file %q not found", name)
+	} else if err != nil {
+		log.Errorf("Client [%s]: failed to open %q: %v", client, name, err)
+		return grpc.Errorf(codes.Internal, "failed to open file")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "failed to stat file: %v", err)
+	}
+	if info.IsDir() {
+		return grpc.Errorf(codes.InvalidArgument, "%q is a folder", name)
+	}
+
+	metadata, err := readMetadataFile(metadataFile)
+	if err != nil {
+		log.Errorf("Client [%s]: failed to read metadata of %q: %v", client, name, err)
+		return grpc.Errorf(codes.Internal, "failed to read metadata")
+	}
+
+	log.Debugf("Client [%s]: started file %q download..", client, name)
+	err = stream.Send(&pb.DownloadResponse{
+		Content: &pb.DownloadResponse_Header{
+			Header: &pb.Header{Name: name, Size: info.Size(), Metadata: metadata, Version: selected.id},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the chunk is serialized by Send, so the buffer could be reused
+	hash := sha256.New()
+	chunk := make([]byte, DefaultChunkSize)
+	for {
+		n, err := file.Read(chunk)
+		if n > 0 {
+			hash.Write(chunk[:n])
+			if err := stream.Send(&pb.DownloadResponse{Content: &pb.DownloadResponse_Chunk{Chunk: chunk[:n]}}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Client [%s]: file download failed on read with error: %v", client, err)
+			return grpc.Errorf(codes.Internal, "failed to read file")
+		}
+	}
+
+	// always send the hash last
+	err = stream.Send(&pb.DownloadResponse{
+		Content: &pb.DownloadResponse_Hash{Hash: hex.EncodeToString(hash.Sum(nil))},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Client [%s]: file with name %q was downloaded", client, name)
+	return nil
+}
+
+// ListFiles returns the files in the client's folder or in a shared space, under the prefix folder.
+// The old versions are listed after the current file, when requested.
+func (s *Server) ListFiles(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	client := s.identityFromCtx(ctx)
+
+	loc, err := s.locateAs(client, req.GetOwner(), cleanName(req.GetPrefix()), false)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := s.index.list(loc.owner, loc.name, req.GetVersions())
+	if err != nil {
+		log.Errorf("Client [%s]: failed to list %q: %v", client, req.GetPrefix(), err)
+		return nil, grpc.Errorf(codes.Internal, "failed to list files")
+	}
+
+	resp := &pb.ListResponse{}
+	for _, rec := range records {
+		resp.Files = append(resp.Files, rec.fileInfo(loc.prefix))
+	}
+	return resp, nil
+}
+
+// StatFile returns the indexed metadata of the current or the selected version of the file.
+func (s *Server) StatFile(ctx context.Context, req *pb.DownloadRequest) (*pb.FileInfo, error) {
+	client := s.identityFromCtx(ctx)
+
+	name := cleanName(req.GetName())
+	loc, err := s.locate(client, name, false)
+	if err != nil {
+		return nil, err
+	}
+	if loc.name == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "expected filename")
+	}
+
+	selected, err := s.selectVersion(loc, req.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+	rec, ok, err := s.index.get(loc.owner, loc.name, selected.id)
+	if err != nil {
+		log.Errorf("Client [%s]: failed to read index of %q: %v", client, name, err)
+		return nil, grpc.Errorf(codes.Internal, "failed to read index")
+	}
+	if !ok {
+		// not indexed yet, only the stored file is known
+		rec = fileRecord{Name: loc.name, Version: selected.id, Size: selected.size, Uploaded: selected.modified}
+	}
+	rec.current = selected.filename == ""
+
+	metadataFile := s.metadataPath(loc.owner, loc.name)
+	if selected.filename != "" {
+		metadataFile = selected.filename + ".json"
+	}
+	metadata, err := readMetadataFile(metadataFile)
+	if err != nil {
+		log.Errorf("Client [%s]: failed to read metadata of %q: %v", client, name, err)
+		return nil, grpc.Errorf(codes.Internal, "failed to read metadata")
+	}
+
+	info := rec.fileInfo(loc.prefix)
+	info.UploadedBy, info.Address, info.Metadata = rec.Identity, rec.Address, metadata
+	return info, nil
+}
+
+// FileInfo describes a file stored on the server.
+type FileInfo struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+	Metadata map[string]string
+	// Version is the ID of the file's version, Current is set for the current one
+	Version string
+	Current bool
+	// Hash is the verified hash of the content, computed with the Algorithm
+	Hash      string
+	Algorithm string
+	// UploadedBy and Address are of the uploading client, they are set only by Stat
+	UploadedBy string
+	Address    string
+}
+
+// DownloadOption configures a single Download call.
+type DownloadOption func(*pb.DownloadRequest)
+
+// WithVersion downloads an old version of the file. The selector is a version ID, or a
+// RFC3339 time for the last version stored at or before it.
+func WithVersion(selector string) DownloadOption {
+	return func(req *pb.DownloadRequest) { req.Version = selector }
+}
+
+// ListOption configures a single List call.
+type ListOption func(*pb.ListRequest)
+
+// WithVersions lists the old versions of the files too, after the current one.
+func WithVersions() ListOption {
+	return func(req *pb.ListRequest) { req.Versions = true }
+}
+
+// SpaceInfo is a shared space and the client's role in it.
+type SpaceInfo struct {
+	Name string
+	Role SpaceRole
+}
+
+// Download writes the file stored under name to w. Names starting with "@space/" are
+// in the shared space. The size and the hash are verified after the last chunk.
+func (c *Client) Download(ctx context.Context, name string, w io.Writer, options ...DownloadOption) (FileInfo, error) {
+	if c.cli == nil {
+		return FileInfo{}, ErrNotConnected
+	}
+
+	req := &pb.DownloadRequest{Name: name}
+	for _, option := range options {
+		option(req)
+	}
+	stream, err := c.cli.DownloadFile(ctx, req)
+	if err != nil {
+		return FileInfo{}, &Error{Op: "download", Name: name, Err: err}
+	}
+
+	// the header is always the first message
+	msg, err := stream.Recv()
+	if err != nil {
+		return FileInfo{}, &Error{Op: "download", Name: name, Err: err}
+	}
+	header := msg.GetHeader()
+	if header == nil {
+		return FileInfo{}, fmt.Errorf("download %q: expected header", name)
+	}
+	info := FileInfo{Name: header.GetName(), Size: header.GetSize(), Metadata: header.GetMetadata(), Version: header.GetVersion()}
+
+	hash := sha256.New()
+	received, expected := int64(0), ""
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return info, &Error{Op: "download", Name: name, Err: err}
+		}
+
+		if chunk := msg.GetChunk(); chunk != nil {
+			hash.Write(chunk)
+			if _, err := w.Write(chunk); err != nil {
+				return info, fmt.Errorf("download %q: failed to write: %v", name, err)
+			}
+			received += int64(len(chunk))
+			continue
+		}
+		expected = msg.GetHash()
+	}
+
+	if received != info.Size {
+		return info, fmt.Errorf("download %q: recieved %d bytes, expected %d", name, received, info.Size)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != expected {
+		return info, fmt.Errorf("download %q: hashes are not equal", name)
+	}
+
+	return info, nil
+}
+
+// List returns the files under the prefix folder, "" is the client's own folder and
+// "@space" is the shared space.
+func (c *Client) List(ctx context.Context, prefix string, options ...ListOption) ([]FileInfo, error) {
+	if c.cli == nil {
+		return nil, ErrNotConnected
+	}
+
+	req := &pb.ListRequest{Prefix: prefix}
+	for _, option := range options {
+		option(req)
+	}
+	resp, err := c.cli.ListFiles(ctx, req)
+	if err != nil {
+		return nil, &Error{Op: "list", Name: prefix, Err: err}
+	}
+
+	files := []FileInfo{}
+	for _, file := range resp.GetFiles() {
+		modified, _ := ptypes.Timestamp(file.GetModified())
+		files = append(files, FileInfo{
+			Name:      file.GetName(),
+			Size:      file.GetSize(),
+			Modified:  modified,
+			Version:   file.GetVersion(),
+			Current:   file.GetCurrent(),
+			Hash:      file.GetHash(),
+			Algorithm: file.GetAlgorithm(),
+		})
+	}
+	return files, nil
+}
+
+// Stat returns the metadata of the file, including the uploading client, without downloading it.
+func (c *Client) Stat(ctx context.Context, name string, options ...DownloadOption) (FileInfo, error) {
+	if c.cli == nil {
+		return FileInfo{}, ErrNotConnected
+	}
+
+	req := &pb.DownloadRequest{Name: name}
+	for _, option := range options {
+		option(req)
+	}
+	resp, err := c.cli.StatFile(ctx, req)
+	if err != nil {
+		return FileInfo{}, &Error{Op: "stat", Name: name, Err: err}
+	}
+
+	modified, _ := ptypes.Timestamp(resp.GetModified())
+	return FileInfo{
+		Name:       resp.GetName(),
+		Size:       resp.GetSize(),
+		Modified:   modified,
+		Metadata:   resp.GetMetadata(),
+		Version:    resp.GetVersion(),
+		Current:    resp.GetCurrent(),
+		Hash:       resp.GetHash(),
+		Algorithm:  resp.GetAlgorithm(),
+		UploadedBy: resp.GetUploadedBy(),
+		Address:    resp.GetAddress(),
+	}, nil
+}
+
+// Spaces returns the shared spaces the client is a member of.
+func (c *Client) Spaces(ctx context.Context) ([]SpaceInfo, error) {
+	if c.cli == nil {
+		return nil, ErrNotConnected
+	}
+
+	resp, err := c.cli.Spaces(ctx, &empty.Empty{})
+	if err != nil {
+		return nil, &Error{Op: "spaces", Name: c.Host, Err: err}
+	}
+
+	spaces := []SpaceInfo{}
+	for _, space := range resp.GetSpaces() {
+		spaces = append(spaces, SpaceInfo{Name: space.GetName(), Role: SpaceRole(space.GetRole())})
+	}
+	return spaces, nil
+}
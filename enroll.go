@@ -0,0 +1,354 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/avalchev94/alcatraz/ca"
+	"github.com/avalchev94/alcatraz/pb"
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// enrollMethod is the only RPC allowed without client certificate.
+const enrollMethod = "/pb.Alcatraz/Enroll"
+
+// Enrollment configures signing the certificates of the clients.
+type Enrollment struct {
+	// CA is the certificate authority folder, created with alcatraz-ca. Enrollment is disabled without it.
+	CA string `yaml:"ca"`
+	// Validity of the signed certificates, default is 90 days
+	Validity time.Duration `yaml:"validity"`
+	// RequestsPerMinute limits the enrollments by address, default is 10, ten times that are
+	// allowed from all addresses
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+}
+
+const defaultEnrollValidity = 90 * 24 * time.Hour
+
+func (e Enrollment) enabled() bool {
+	return e.CA != ""
+}
+
+func (e Enrollment) requestsPerMinute() int {
+	if e.RequestsPerMinute == 0 {
+		return 10
+	}
+	return e.RequestsPerMinute
+}
+
+// openCA opens the certificate authority for every request, so the tokens and the certificates
+// created with alcatraz-ca are seen by the server. The CA is locked until it's closed.
+func (s *Server) openCA() (*ca.CA, error) {
+	authority, err := ca.Open(s.Enrollment.CA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open certificate authority: %v", err)
+	}
+	return authority, nil
+}
+
+// Enroll signs the certificate request of a new client with a one-time token.
+func (s *Server) Enroll(ctx context.Context, req *pb.EnrollRequest) (*pb.Certificate, error) {
+	if !s.Enrollment.enabled() {
+		return nil, grpc.Errorf(codes.Unimplemented, "enrollment is disabled")
+	}
+
+	csr, err := parseCSR(req.GetCsr())
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	authority, err := s.openCA()
+	if err != nil {
+		log.Error(err)
+		return nil, grpc.Errorf(codes.Internal, "enrollment is not available")
+	}
+	defer authority.Close()
+
+	request, err := authority.RedeemToken(req.GetToken())
+	if err == ca.ErrInvalidToken {
+		s.audit("enrollment rejected", "", logrus.Fields{"error": err.Error()})
+		return nil, grpc.Errorf(codes.Unauthenticated, "%v", err)
+	} else if err != nil {
+		log.Errorf("Failed to redeem enrollment token: %v", err)
+		return nil, grpc.Errorf(codes.Internal, "failed to redeem token")
+	}
+
+	// the subject comes from the token, only the key is taken from the request
+	return s.sign(authority, request, csr, "client enrolled")
+}
+
+// Renew signs a new certificate request with the identity of the client's current certificate,
+// which is revoked. The fingerprint identity changes with the certificate, it's not renewed.
+func (s *Server) Renew(ctx context.Context, req *pb.RenewRequest) (*pb.Certificate, error) {
+	if !s.Enrollment.enabled() {
+		return nil, grpc.Errorf(codes.Unimplemented, "enrollment is disabled")
+	}
+	if s.identitySource().Source == IdentityFingerprint {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "the renewed certificate would have another fingerprint, which is the client's identity")
+	}
+
+	current, err := getPeerCertificate(ctx)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "failed to retrieve client certificate: %v", err)
+	}
+	csr, err := parseCSR(req.GetCsr())
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	authority, err := s.openCA()
+	if err != nil {
+		log.Error(err)
+		return nil, grpc.Errorf(codes.Internal, "enrollment is not available")
+	}
+	defer authority.Close()
+	if current.CheckSignatureFrom(authority.Certificate()) != nil {
+		return nil, grpc.Errorf(codes.PermissionDenied, "certificate is not issued by the enrollment CA")
+	}
+
+	request := ca.Request{
+		Name:           current.Subject.CommonName,
+		Kind:           ca.KindClient,
+		DNSNames:       current.DNSNames,
+		IPAddresses:    current.IPAddresses,
+		EmailAddresses: current.EmailAddresses,
+		URIs:           current.URIs,
+	}
+	cert, err := s.sign(authority, request, csr, "certificate renewed")
+	if err != nil {
+		return nil, err
+	}
+
+	// the replaced certificate is not used anymore
+	if _, err := authority.Revoke(current.SerialNumber.String()); err != nil {
+		log.Warnf("Failed to revoke the renewed certificate %s of %q: %v", current.SerialNumber, request.Name, err)
+	}
+	return cert, nil
+}
+
+func (s *Server) sign(authority *ca.CA, request ca.Request, csr *x509.CertificateRequest, event string) (*pb.Certificate, error) {
+	request.Validity = s.Enrollment.Validity
+	if request.Validity <= 0 {
+		request.Validity = defaultEnrollValidity
+	}
+
+	issued, err := authority.Sign(request, csr.PublicKey)
+	if err != nil {
+		log.Errorf("Failed to sign certificate for %q: %v", request.Name, err)
+		return nil, grpc.Errorf(codes.Internal, "failed to sign certificate")
+	}
+
+	identity, _ := s.identitySource().identity(issued.Certificate)
+	s.audit(event, identity, logrus.Fields{"serial": issued.Serial, "expires": issued.NotAfter})
+
+	return &pb.Certificate{
+		Certificate: issued.CertPEM,
+		Ca:          pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: authority.Certificate().Raw}),
+	}, nil
+}
+
+func parseCSR(der []byte) (*x509.CertificateRequest, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate request: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %v", err)
+	}
+	return csr, nil
+}
+
+// Enroll gets the first certificate of the client with a one-time token. The new key and
+// certificate are written to the files in config.Certificates, the certificate authority
+// is used to verify the server.
+func Enroll(ctx context.Context, config ClientConfig, token string) error {
+	files := config.Certificates
+	if _, err := os.Stat(files.Key); err == nil {
+		return fmt.Errorf("key %q already exists", files.Key)
+	}
+
+	certPool, err := files.getCertAuthPool()
+	if err != nil {
+		return fmt.Errorf("could not get cert auth pool: %v", err)
+	}
+
+	// the client has no certificate yet, only the server is verified
+	creds := credentials.NewTLS(&tls.Config{RootCAs: certPool, VerifyConnection: verifyStaple(config.OCSPStaple)})
+	conn, err := grpc.DialContext(ctx, config.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("failed to dial server on host %q: %v", config.Host, err)
+	}
+	defer conn.Close()
+
+	csr, keyPEM, err := newCertificateRequest()
+	if err != nil {
+		return err
+	}
+
+	resp, err := pb.NewAlcatrazClient(conn).Enroll(ctx, &pb.EnrollRequest{Token: token, Csr: csr})
+	if err != nil {
+		return &Error{Op: "enroll", Name: config.Host, Err: err}
+	}
+
+	_, err = storeCertificate(files, resp.GetCertificate(), keyPEM)
+	return err
+}
+
+// Renew gets a new certificate from the server, with the same identity and a new key. It's
+// written to the certificate files and used for the new connections.
+func (c *Client) Renew(ctx context.Context) error {
+	if c.cli == nil {
+		return ErrNotConnected
+	}
+
+	csr, keyPEM, err := newCertificateRequest()
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.cli.Renew(ctx, &pb.RenewRequest{Csr: csr})
+	if err != nil {
+		return &Error{Op: "renew", Name: c.Host, Err: err}
+	}
+
+	certificate, err := storeCertificate(c.Certificates, resp.GetCertificate(), keyPEM)
+	if err != nil {
+		return err
+	}
+
+	c.certMu.Lock()
+	c.certificate = certificate
+	c.certMu.Unlock()
+
+	return nil
+}
+
+func (c *Client) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.certMu.RLock()
+	certificate := c.certificate
+	c.certMu.RUnlock()
+	return &certificate, nil
+}
+
+// renewAt returns when the current certificate should be renewed.
+func (c *Client) renewAt() (time.Time, error) {
+	c.certMu.RLock()
+	defer c.certMu.RUnlock()
+
+	leaf, err := x509.ParseCertificate(c.certificate.Certificate[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse certificate: %v", err)
+	}
+
+	before := c.RenewBefore
+	if before == 0 {
+		before = leaf.NotAfter.Sub(leaf.NotBefore) / 3
+	}
+	return leaf.NotAfter.Add(-before), nil
+}
+
+// renewCertificate renews the certificate before it expires, until ctx is done.
+func (c *Client) renewCertificate(ctx context.Context) {
+	if c.RenewBefore < 0 {
+		return
+	}
+
+	for {
+		at, err := c.renewAt()
+		if err != nil {
+			log.Errorf("Certificate renewal is disabled: %v", err)
+			return
+		}
+
+		// wake up at least hourly, the clock could jump after suspend
+		wait := time.Until(at)
+		if wait > time.Hour {
+			wait = time.Hour
+		}
+		if wait > 0 {
+			if sleep(ctx, wait) != nil {
+				return
+			}
+			continue
+		}
+
+		err = c.Renew(ctx)
+		if status.Code(err) == codes.Unimplemented {
+			log.Warn("Server doesn't renew certificates, renewal is disabled")
+			return
+		}
+		if err != nil {
+			log.Errorf("Failed to renew certificate, retrying in 5 minutes: %v", err)
+			if sleep(ctx, 5*time.Minute) != nil {
+				return
+			}
+			continue
+		}
+		log.Info("Certificate renewed")
+	}
+}
+
+// newCertificateRequest generates a key and a certificate request for it. The subject is
+// set by the server.
+func newCertificateRequest() (csr, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	csr, err = x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate request: %v", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return csr, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// storeCertificate verifies the certificate matches the key and writes both of them.
+// Temporary files are renamed, so the old pair is replaced only when both are written.
+func storeCertificate(files CertFiles, certPEM, keyPEM []byte) (tls.Certificate, error) {
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("invalid certificate from server: %v", err)
+	}
+
+	if err := ioutil.WriteFile(files.Key+".tmp", keyPEM, 0600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to write key: %v", err)
+	}
+	if err := ioutil.WriteFile(files.Certificate+".tmp", certPEM, 0644); err != nil {
+		os.Remove(files.Key + ".tmp")
+		return tls.Certificate{}, fmt.Errorf("failed to write certificate: %v", err)
+	}
+	if err := os.Rename(files.Key+".tmp", files.Key); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.Rename(files.Certificate+".tmp", files.Certificate); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return certificate, nil
+}
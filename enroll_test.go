@@ -0,0 +1,167 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avalchev94/alcatraz/ca"
+	"github.com/avalchev94/alcatraz/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+)
+
+// serveTLS runs the server with TLS and authorization on a random port and returns its address.
+func serveTLS(t *testing.T, server *Server) (string, func()) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.newGRPCServer()
+	go srv.Serve(lis)
+	// the test certificates are issued for localhost
+	return net.JoinHostPort("localhost", strconv.Itoa(lis.Addr().(*net.TCPAddr).Port)), srv.Stop
+}
+
+func TestEnroll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caDir := filepath.Join(dir, "ca")
+	authority, err := ca.Init(caDir, "Test CA", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := authority.Issue(ca.Request{Name: "localhost", Kind: ca.KindServer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverFiles := CertFiles{
+		Certificate: filepath.Join(dir, "localhost.crt"),
+		Key:         filepath.Join(dir, "localhost.key"),
+		CertAuth:    filepath.Join(caDir, ca.CertFile),
+	}
+	ioutil.WriteFile(serverFiles.Certificate, issued.CertPEM, 0644)
+	ioutil.WriteFile(serverFiles.Key, issued.KeyPEM, 0600)
+
+	token, err := authority.CreateToken(ca.Request{Name: "Reese"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority.Close()
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients:      []ClientACL{{Identity: "Reese", Permissions: []Permission{PermissionUpload}}},
+		Enrollment:   Enrollment{CA: caDir, Validity: time.Hour},
+		LogLevel:     "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	config := ClientConfig{
+		Host: addr,
+		Certificates: CertFiles{
+			Certificate: filepath.Join(dir, "Reese.crt"),
+			Key:         filepath.Join(dir, "Reese.key"),
+			CertAuth:    serverFiles.CertAuth,
+		},
+		LogLevel: "info",
+	}
+	ctx := context.Background()
+
+	err = Enroll(ctx, config, "wrong")
+	if uploadErr := (&Error{}); !errors.As(err, &uploadErr) || uploadErr.Code() != codes.Unauthenticated {
+		t.Errorf("wrong token, expected Unauthenticated, got %v", err)
+	}
+	if err := Enroll(ctx, config, token); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+
+	// the token is used only once
+	other := config
+	other.Certificates.Key = filepath.Join(dir, "other.key")
+	if err := Enroll(ctx, other, token); err == nil || !strings.Contains(err.Error(), "invalid or expired token") {
+		t.Errorf("used token, expected error, got %v", err)
+	}
+
+	// the enrolled client can upload and renew its certificate
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Upload(ctx, "a.txt", strings.NewReader("alcatraz")); err != nil {
+		t.Errorf("upload should be successful, but %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "storage", "Reese", "a.txt")); err != nil {
+		t.Errorf("file should be in Reese's folder: %v", err)
+	}
+
+	old, _ := x509.ParseCertificate(client.certificate.Certificate[0])
+	if err := client.Renew(ctx); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	renewed, err := config.Certificates.getCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(renewed.Certificate[0])
+	if leaf.SerialNumber.Cmp(old.SerialNumber) == 0 || leaf.Subject.CommonName != "Reese" {
+		t.Errorf("expected new certificate for Reese, got %s %s", leaf.Subject.CommonName, leaf.SerialNumber)
+	}
+	authority, err = ca.Open(caDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := false
+	for _, record := range authority.List() {
+		revoked = revoked || (record.Serial == old.SerialNumber.String() && record.Revoked())
+	}
+	if !revoked {
+		t.Errorf("expected the renewed certificate %s to be revoked", old.SerialNumber)
+	}
+	authority.Close()
+
+	// the fingerprint identity changes with the certificate
+	server.mu.Lock()
+	server.identity = Identity{Source: IdentityFingerprint}
+	server.mu.Unlock()
+	if _, err := server.Renew(ctx, &pb.RenewRequest{}); grpc.Code(err) != codes.FailedPrecondition {
+		t.Errorf("fingerprint identity, expected FailedPrecondition, got %v", err)
+	}
+
+	// without certificate, only enrollment is allowed
+	pool, _ := serverFiles.getCertAuthPool()
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = pb.NewAlcatrazClient(conn).Renew(ctx, &pb.RenewRequest{})
+	if grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("no certificate, expected Unauthenticated, got %v", err)
+	}
+}
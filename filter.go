@@ -0,0 +1,244 @@
+package alcatraz
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the name of the files, inside the monitored tree, which
+// contain additional exclude rules for their directory.
+const IgnoreFileName = ".alcatrazignore"
+
+// Filter describes which files from the monitored folder are uploaded.
+// Patterns follow the gitignore syntax: "*.swp", "/tmp/", "**/cache", "!keep.log".
+type Filter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	MinSize int64    `yaml:"min_size"`
+	MaxSize int64    `yaml:"max_size"`
+}
+
+type rule struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+func parseRule(pattern string) (rule, bool, error) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return rule{}, false, nil
+	}
+
+	r := rule{}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	// patterns without a slash match at any depth, the rest are anchored
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return rule{}, false, fmt.Errorf("empty pattern")
+	}
+
+	r.segments = strings.Split(pattern, "/")
+	for _, s := range r.segments {
+		if _, err := path.Match(s, ""); err != nil {
+			return rule{}, false, fmt.Errorf("bad pattern %q: %v", pattern, err)
+		}
+	}
+
+	return r, true, nil
+}
+
+func parseRules(patterns []string) ([]rule, error) {
+	rules := []rule{}
+	for _, p := range patterns {
+		r, ok, err := parseRule(p)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// match reports if the slash separated name(relative to the rule's base) matches.
+func (r rule) match(name string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(r.segments, strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
+
+// excluded applies the rules in order, the last matching rule wins.
+func excluded(rules []rule, name string, isDir bool, current bool) bool {
+	for _, r := range rules {
+		if r.match(name, isDir) {
+			current = !r.negate
+		}
+	}
+	return current
+}
+
+type fileFilter struct {
+	include []rule
+	exclude []rule
+	minSize int64
+	maxSize int64
+}
+
+func newFileFilter(f Filter) (*fileFilter, error) {
+	include, err := parseRules(f.Include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include rule: %v", err)
+	}
+
+	exclude, err := parseRules(f.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude rule: %v", err)
+	}
+
+	if f.MaxSize > 0 && f.MinSize > f.MaxSize {
+		return nil, fmt.Errorf("min size %d is bigger than max size %d", f.MinSize, f.MaxSize)
+	}
+
+	return &fileFilter{
+		include: include,
+		exclude: exclude,
+		minSize: f.MinSize,
+		maxSize: f.MaxSize,
+	}, nil
+}
+
+// walk calls fn for every directory and file under root which is not filtered.
+// Ignored directories are not entered at all.
+func (f *fileFilter) walk(root string, fn func(filename string, info os.FileInfo) error) error {
+	// rules from the ignore files, by the directory they were found in
+	ignores := map[string][]rule{}
+
+	return filepath.Walk(root, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if filename != root && f.skip(root, filename, info, ignores) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			rules, err := readIgnoreFile(filepath.Join(filename, IgnoreFileName))
+			if err != nil {
+				return err
+			}
+			ignores[filename] = rules
+		}
+
+		return fn(filename, info)
+	})
+}
+
+func (f *fileFilter) skip(root, filename string, info os.FileInfo, ignores map[string][]rule) bool {
+	isDir := info.IsDir()
+	if !isDir && info.Name() == IgnoreFileName {
+		return true
+	}
+
+	rel, err := filepath.Rel(root, filename)
+	if err != nil {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+
+	ignored := excluded(f.exclude, rel, isDir, false)
+
+	// ignore files are applied from the root to the deepest directory
+	dir := root
+	for _, part := range strings.Split(rel, "/") {
+		if relToDir, err := filepath.Rel(dir, filename); err == nil {
+			ignored = excluded(ignores[dir], filepath.ToSlash(relToDir), isDir, ignored)
+		}
+		dir = filepath.Join(dir, part)
+	}
+
+	if ignored || isDir {
+		return ignored
+	}
+
+	if len(f.include) > 0 && !excluded(f.include, rel, false, false) {
+		return true
+	}
+	if info.Size() < f.minSize {
+		return true
+	}
+	if f.maxSize > 0 && info.Size() > f.maxSize {
+		return true
+	}
+
+	return false
+}
+
+func readIgnoreFile(filename string) ([]rule, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open ignore file: %v", err)
+	}
+	defer file.Close()
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ignore file %q: %v", filename, err)
+	}
+
+	rules, err := parseRules(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule in %q: %v", filename, err)
+	}
+	return rules, nil
+}
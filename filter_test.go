@@ -0,0 +1,122 @@
+package alcatraz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		isDir   bool
+		match   bool
+	}{
+		{"*.swp", "a.swp", false, true},
+		{"*.swp", "dir/sub/a.swp", false, true},
+		{"*.swp", "a.txt", false, false},
+		{".DS_Store", "photos/.DS_Store", false, true},
+		{"/tmp", "tmp", true, true},
+		{"/tmp", "dir/tmp", true, false},
+		{"cache/", "cache", false, false},
+		{"cache/", "a/cache", true, true},
+		{"logs/**/*.log", "logs/a/b/c.log", false, true},
+		{"logs/**/*.log", "logs/c.log", false, true},
+		{"logs/**/*.log", "other/c.log", false, false},
+	}
+
+	for _, test := range tests {
+		r, ok, err := parseRule(test.pattern)
+		if err != nil || !ok {
+			t.Fatalf("failed to parse %q: %v", test.pattern, err)
+		}
+		if r.match(test.name, test.isDir) != test.match {
+			t.Errorf("pattern %q on %q: expected %v", test.pattern, test.name, test.match)
+		}
+	}
+
+	if _, ok, _ := parseRule("# comment"); ok {
+		t.Error("comments should be skipped")
+	}
+	if _, _, err := parseRule("[a-"); err == nil {
+		t.Error("bad pattern, error should be returned")
+	}
+}
+
+func TestFilterWalk(t *testing.T) {
+	root, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"a.txt":                     "hello",
+		"a.txt.swp":                 "swap",
+		"big.txt":                   strings.Repeat("x", 100),
+		"empty.txt":                 "",
+		"keep/b.txt":                "hello",
+		"keep/b.log":                "hello",
+		"keep/" + IgnoreFileName:    "*.log\n",
+		"skip/c.txt":                "hello",
+		"skip/d/e.txt":              "hello",
+		"partial/f.part":            "hello",
+		"partial/" + IgnoreFileName: "# in-progress downloads\n*.part\n!important.part\n",
+		"partial/important.part":    "hello",
+	}
+	for name, content := range files {
+		fullname := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(fullname), os.ModePerm)
+		if err := ioutil.WriteFile(fullname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter, err := newFileFilter(Filter{
+		Exclude: []string{"*.swp", "/skip/"},
+		MinSize: 1,
+		MaxSize: 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walked := []string{}
+	err = filter.walk(root, func(filename string, info os.FileInfo) error {
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(root, filename)
+			walked = append(walked, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(walked)
+	expected := []string{"a.txt", "keep/b.txt", "partial/important.part"}
+	if strings.Join(walked, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, walked)
+	}
+
+	// include rules
+	filter, _ = newFileFilter(Filter{Include: []string{"*.log"}})
+	walked = []string{}
+	filter.walk(root, func(filename string, info os.FileInfo) error {
+		if !info.IsDir() {
+			walked = append(walked, filepath.Base(filename))
+		}
+		return nil
+	})
+	if len(walked) != 0 {
+		t.Errorf("the only .log file is ignored by %s, got %v", IgnoreFileName, walked)
+	}
+
+	if _, err := newFileFilter(Filter{MinSize: 10, MaxSize: 1}); err == nil {
+		t.Error("min size is bigger than max size, error should be returned")
+	}
+}
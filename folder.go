@@ -0,0 +1,188 @@
+package alcatraz
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Disposition tells what happens with a local file after it is uploaded.
+type Disposition string
+
+const (
+	// DispositionDelete removes the file, that's the default.
+	DispositionDelete Disposition = "delete"
+	// DispositionKeep leaves the file, it's uploaded again only if modified.
+	DispositionKeep Disposition = "keep"
+	// DispositionMove moves the file to Folder.MoveTo.
+	DispositionMove Disposition = "move"
+)
+
+// Folder is a monitored folder together with the settings for its files.
+type Folder struct {
+	Path string `yaml:"path"`
+	// Destination is the prefix of the uploaded names on the server, e.g. "logs/app/"
+	Destination string      `yaml:"destination"`
+	ChunkSize   int         `yaml:"chunk"`
+	Filter      Filter      `yaml:"filter"`
+	Disposition Disposition `yaml:"disposition"`
+	MoveTo      string      `yaml:"move_to"`
+	Priority    int         `yaml:"priority"`
+	// Bandwidth is applied on top of the client's limit
+	Bandwidth Bandwidth `yaml:"bandwidth"`
+}
+
+type watchedFolder struct {
+	Folder
+	filter  *fileFilter
+	limiter *rateLimiter
+}
+
+// newWatchedFolder validates the folder and fills the missing settings from the client's config.
+func newWatchedFolder(folder Folder, config ClientConfig) (*watchedFolder, error) {
+	if _, err := os.Stat(folder.Path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("folder %q does not exist", folder.Path)
+	}
+
+	destination := path.Clean("/" + filepath.ToSlash(folder.Destination))
+	folder.Destination = strings.TrimPrefix(destination, "/")
+
+	if folder.ChunkSize <= 0 {
+		folder.ChunkSize = config.ChunkSize
+	}
+
+	switch folder.Disposition {
+	case "":
+		folder.Disposition = DispositionDelete
+	case DispositionDelete, DispositionKeep:
+	case DispositionMove:
+		if folder.MoveTo == "" {
+			return nil, fmt.Errorf("folder %q: move_to is required for disposition %q", folder.Path, folder.Disposition)
+		}
+		// the moved files would be found and uploaded again
+		abs, err := filepath.Abs(folder.Path)
+		if err != nil {
+			return nil, fmt.Errorf("folder %q: %v", folder.Path, err)
+		}
+		moveTo, err := filepath.Abs(folder.MoveTo)
+		if err != nil {
+			return nil, fmt.Errorf("folder %q: invalid move_to: %v", folder.Path, err)
+		}
+		if within(moveTo, abs) {
+			return nil, fmt.Errorf("folder %q: move_to %q is inside the folder", folder.Path, folder.MoveTo)
+		}
+	default:
+		return nil, fmt.Errorf("folder %q: unknown disposition %q", folder.Path, folder.Disposition)
+	}
+
+	// the client's rules apply to every folder, the folder's own are added after them
+	filter, err := newFileFilter(Filter{
+		Include: append(append([]string{}, config.Filter.Include...), folder.Filter.Include...),
+		Exclude: append(append([]string{}, config.Filter.Exclude...), folder.Filter.Exclude...),
+		MinSize: maxInt64(config.Filter.MinSize, folder.Filter.MinSize),
+		MaxSize: minPositiveInt64(config.Filter.MaxSize, folder.Filter.MaxSize),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("folder %q: invalid filter: %v", folder.Path, err)
+	}
+
+	limiter, err := newRateLimiter(folder.Bandwidth)
+	if err != nil {
+		return nil, fmt.Errorf("folder %q: invalid bandwidth: %v", folder.Path, err)
+	}
+
+	return &watchedFolder{
+		Folder:  folder,
+		filter:  filter,
+		limiter: limiter,
+	}, nil
+}
+
+// checkOverlap rejects the folders which are the same or nested in each other, their files
+// would be uploaded twice, and the folders moving their files into another watched folder.
+func checkOverlap(folders []*watchedFolder) error {
+	paths := make([]string, len(folders))
+	for i, folder := range folders {
+		abs, err := filepath.Abs(folder.Path)
+		if err != nil {
+			return fmt.Errorf("folder %q: %v", folder.Path, err)
+		}
+		paths[i] = abs
+	}
+
+	for i := range paths {
+		for j := i + 1; j < len(paths); j++ {
+			if within(paths[i], paths[j]) || within(paths[j], paths[i]) {
+				return fmt.Errorf("folders %q and %q overlap", folders[i].Path, folders[j].Path)
+			}
+		}
+	}
+
+	for _, folder := range folders {
+		if folder.Disposition != DispositionMove {
+			continue
+		}
+		moveTo, err := filepath.Abs(folder.MoveTo)
+		if err != nil {
+			return fmt.Errorf("folder %q: invalid move_to: %v", folder.Path, err)
+		}
+		for i, dir := range paths {
+			if within(moveTo, dir) {
+				return fmt.Errorf("folder %q: move_to %q is inside the watched folder %q", folder.Path, folder.MoveTo, folders[i].Path)
+			}
+		}
+	}
+	return nil
+}
+
+// within returns whether path is dir or inside it, both are absolute and clean.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// remoteName returns the name under which the local file is stored on the server.
+func (f *watchedFolder) remoteName(filename string) (string, error) {
+	rel, err := filepath.Rel(f.Path, filename)
+	if err != nil {
+		return "", fmt.Errorf("file is not in folder %q: %v", f.Path, err)
+	}
+	return path.Join(f.Destination, filepath.ToSlash(rel)), nil
+}
+
+// dispose handles the local file after successful upload.
+func (f *watchedFolder) dispose(filename string) error {
+	switch f.Disposition {
+	case DispositionKeep:
+		return nil
+	case DispositionMove:
+		rel, err := filepath.Rel(f.Path, filename)
+		if err != nil {
+			return fmt.Errorf("file is not in folder %q: %v", f.Path, err)
+		}
+
+		target := filepath.Join(f.MoveTo, rel)
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create directories: %v", err)
+		}
+		return os.Rename(filename, target)
+	default:
+		return os.Remove(filename)
+	}
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minPositiveInt64(a, b int64) int64 {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,105 @@
+package alcatraz
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchedFolder(t *testing.T) {
+	root, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	config := ClientConfig{ChunkSize: 100}
+
+	if _, err := newWatchedFolder(Folder{Path: filepath.Join(root, "missing")}, config); err == nil {
+		t.Error("folder does not exist, error should be returned")
+	}
+	if _, err := newWatchedFolder(Folder{Path: root, Disposition: "burn"}, config); err == nil {
+		t.Error("unknown disposition, error should be returned")
+	}
+	if _, err := newWatchedFolder(Folder{Path: root, Disposition: DispositionMove}, config); err == nil {
+		t.Error("move without target, error should be returned")
+	}
+
+	folder, err := newWatchedFolder(Folder{Path: root, Destination: "/logs/../logs/app/"}, config)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if folder.ChunkSize != 100 || folder.Disposition != DispositionDelete {
+		t.Errorf("defaults were not applied: %+v", folder.Folder)
+	}
+
+	name, err := folder.remoteName(filepath.Join(root, "sub", "a.log"))
+	if err != nil || name != "logs/app/sub/a.log" {
+		t.Errorf("expected logs/app/sub/a.log, got %q(%v)", name, err)
+	}
+
+	// destination can't escape the client's area
+	folder, _ = newWatchedFolder(Folder{Path: root, Destination: "../../etc"}, config)
+	if name, _ := folder.remoteName(filepath.Join(root, "a.log")); name != "etc/a.log" {
+		t.Errorf("expected etc/a.log, got %q", name)
+	}
+
+	// the moved files can't be watched again
+	if _, err := newWatchedFolder(Folder{Path: root, Disposition: DispositionMove, MoveTo: filepath.Join(root, "done")}, config); err == nil {
+		t.Error("move_to inside the folder, error should be returned")
+	}
+
+	// move disposition keeps the sub-directories
+	moveTo := root + "-done"
+	defer os.RemoveAll(moveTo)
+	folder, _ = newWatchedFolder(Folder{Path: root, Disposition: DispositionMove, MoveTo: moveTo}, config)
+	os.MkdirAll(filepath.Join(root, "sub"), os.ModePerm)
+	filename := filepath.Join(root, "sub", "a.log")
+	ioutil.WriteFile(filename, []byte("hello"), 0644)
+	if err := folder.dispose(filename); err != nil {
+		t.Fatalf("failed to move: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(moveTo, "sub", "a.log")); err != nil {
+		t.Errorf("file was not moved: %v", err)
+	}
+}
+
+func TestCheckOverlap(t *testing.T) {
+	root, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{"logs", "logs/app", "logs-old"} {
+		os.MkdirAll(filepath.Join(root, dir), os.ModePerm)
+	}
+	folders := func(paths ...string) []*watchedFolder {
+		watched := []*watchedFolder{}
+		for _, path := range paths {
+			watched = append(watched, &watchedFolder{Folder: Folder{Path: filepath.Join(root, path)}})
+		}
+		return watched
+	}
+
+	if err := checkOverlap(folders("logs", "logs-old")); err != nil {
+		t.Errorf("expected siblings to be allowed, got %v", err)
+	}
+	if err := checkOverlap(folders("logs", "logs-old", "logs/app")); err == nil {
+		t.Error("nested folders, error should be returned")
+	}
+	if err := checkOverlap(folders("logs", "logs/../logs")); err == nil {
+		t.Error("folder listed twice, error should be returned")
+	}
+
+	moving := folders("logs", "logs-old")
+	moving[1].Disposition, moving[1].MoveTo = DispositionMove, filepath.Join(root, "logs", "done")
+	if err := checkOverlap(moving); err == nil {
+		t.Error("move_to inside another watched folder, error should be returned")
+	}
+	moving[1].MoveTo = filepath.Join(root, "done")
+	if err := checkOverlap(moving); err != nil {
+		t.Errorf("expected move_to outside the folders to be allowed, got %v", err)
+	}
+}
@@ -1,10 +1,21 @@
 module github.com/avalchev94/alcatraz
 
-go 1.14
+go 1.18
 
 require (
 	github.com/golang/mock v1.4.3
 	github.com/golang/protobuf v1.3.5
 	github.com/sirupsen/logrus v1.4.2
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/crypto v0.21.0
 	google.golang.org/grpc v1.28.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55 // indirect
 )
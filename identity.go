@@ -0,0 +1,137 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// IdentitySource is the part of the client certificate used as the client's identity.
+type IdentitySource string
+
+const (
+	// IdentityCommonName is the subject common name, the default
+	IdentityCommonName IdentitySource = "cn"
+	// IdentityDNS is the first DNS name in the subject alternative names
+	IdentityDNS IdentitySource = "dns"
+	// IdentityEmail is the first email address in the subject alternative names
+	IdentityEmail IdentitySource = "email"
+	// IdentitySPIFFE is the SPIFFE ID(spiffe://trust-domain/path) in the URI subject alternative names
+	IdentitySPIFFE IdentitySource = "spiffe"
+	// IdentityFingerprint is the hex encoded SHA-256 of the certificate
+	IdentityFingerprint IdentitySource = "fingerprint"
+)
+
+// Identity configures how the clients are identified by their certificates.
+type Identity struct {
+	Source IdentitySource `yaml:"source"`
+	// TrustDomain is the only accepted SPIFFE trust domain, default is any
+	TrustDomain string `yaml:"trust_domain"`
+}
+
+const spiffeScheme = "spiffe"
+
+func validateIdentity(config Identity) error {
+	switch config.Source {
+	case "", IdentityCommonName, IdentityDNS, IdentityEmail, IdentitySPIFFE, IdentityFingerprint:
+	default:
+		return fmt.Errorf("unknown identity source %q, expected one of %v", config.Source,
+			[]IdentitySource{IdentityCommonName, IdentityDNS, IdentityEmail, IdentitySPIFFE, IdentityFingerprint})
+	}
+	if config.TrustDomain != "" && config.Source != IdentitySPIFFE {
+		return errors.New("trust domain is used only with spiffe identity")
+	}
+	return nil
+}
+
+// identity extracts the client's identity from its certificate.
+func (i Identity) identity(cert *x509.Certificate) (string, error) {
+	switch i.Source {
+	case "", IdentityCommonName:
+		if cert.Subject.CommonName == "" {
+			return "", errors.New("certificate has no common name")
+		}
+		return cert.Subject.CommonName, nil
+
+	case IdentityDNS:
+		if len(cert.DNSNames) == 0 {
+			return "", errors.New("certificate has no DNS name")
+		}
+		return cert.DNSNames[0], nil
+
+	case IdentityEmail:
+		if len(cert.EmailAddresses) == 0 {
+			return "", errors.New("certificate has no email address")
+		}
+		return cert.EmailAddresses[0], nil
+
+	case IdentitySPIFFE:
+		// the SPIFFE standard allows exactly one SPIFFE ID in the certificate
+		ids := []string{}
+		for _, uri := range cert.URIs {
+			if uri.Scheme != spiffeScheme {
+				continue
+			}
+			if uri.Host == "" || uri.User != nil || uri.RawQuery != "" || uri.Fragment != "" {
+				return "", fmt.Errorf("invalid SPIFFE ID %q", uri)
+			}
+			if i.TrustDomain != "" && uri.Host != i.TrustDomain {
+				return "", fmt.Errorf("SPIFFE ID %q is not in trust domain %q", uri, i.TrustDomain)
+			}
+			ids = append(ids, uri.String())
+		}
+		if len(ids) != 1 {
+			return "", fmt.Errorf("certificate must have one SPIFFE ID, found %d", len(ids))
+		}
+		return ids[0], nil
+
+	case IdentityFingerprint:
+		sum := sha256.Sum256(cert.Raw)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	return "", fmt.Errorf("unknown identity source %q", i.Source)
+}
+
+// defaultStorage is the client's folder, when it's not configured. SPIFFE IDs
+// are stored by trust domain and path.
+func defaultStorage(identity string) string {
+	return strings.TrimPrefix(identity, spiffeScheme+"://")
+}
+
+type identityKey struct{}
+
+// identityStream passes the authenticated client's identity to the handler.
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+func withIdentity(ss grpc.ServerStream, identity string) grpc.ServerStream {
+	return &identityStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), identityKey{}, identity)}
+}
+
+// identityFromCtx returns the identity set by authClient. Without it, the identity is
+// taken from the peer certificate, and it's empty without certificate.
+func (s *Server) identityFromCtx(ctx context.Context) string {
+	if identity, ok := ctx.Value(identityKey{}).(string); ok {
+		return identity
+	}
+
+	cert, err := getPeerCertificate(ctx)
+	if err != nil {
+		return ""
+	}
+	identity, _ := s.identitySource().identity(cert)
+	return identity
+}
@@ -0,0 +1,121 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avalchev94/alcatraz/pb/mock"
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/backup/reese")
+	other, _ := url.Parse("spiffe://other.org/reese")
+	web, _ := url.Parse("https://example.org/reese")
+
+	cert := &x509.Certificate{
+		Raw:            []byte("certificate"),
+		Subject:        pkix.Name{CommonName: "Reese"},
+		DNSNames:       []string{"reese.example.org", "www.example.org"},
+		EmailAddresses: []string{"reese@example.org"},
+		URIs:           []*url.URL{web, spiffe},
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	tests := []struct {
+		config   Identity
+		cert     *x509.Certificate
+		identity string
+		err      string
+	}{
+		{Identity{}, cert, "Reese", ""},
+		{Identity{Source: IdentityDNS}, cert, "reese.example.org", ""},
+		{Identity{Source: IdentityEmail}, cert, "reese@example.org", ""},
+		{Identity{Source: IdentitySPIFFE}, cert, "spiffe://example.org/backup/reese", ""},
+		{Identity{Source: IdentitySPIFFE, TrustDomain: "example.org"}, cert, "spiffe://example.org/backup/reese", ""},
+		{Identity{Source: IdentitySPIFFE, TrustDomain: "other.org"}, cert, "", "not in trust domain"},
+		{Identity{Source: IdentitySPIFFE}, &x509.Certificate{URIs: []*url.URL{spiffe, other}}, "", "one SPIFFE ID, found 2"},
+		{Identity{Source: IdentityFingerprint}, cert, hex.EncodeToString(fingerprint[:]), ""},
+		{Identity{}, &x509.Certificate{}, "", "no common name"},
+		{Identity{Source: IdentityEmail}, &x509.Certificate{}, "", "no email"},
+	}
+
+	for _, test := range tests {
+		identity, err := test.config.identity(test.cert)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%+v: expected error with %q, got %v", test.config, test.err, err)
+			}
+			continue
+		}
+		if err != nil || identity != test.identity {
+			t.Errorf("%+v: expected %q, got %q, %v", test.config, test.identity, identity, err)
+		}
+	}
+
+	if err := validateIdentity(Identity{Source: "serial"}); err == nil {
+		t.Error("unknown source, error should be returned")
+	}
+	if err := validateIdentity(Identity{Source: IdentityDNS, TrustDomain: "example.org"}); err == nil {
+		t.Error("trust domain without spiffe, error should be returned")
+	}
+}
+
+func TestAuthClientSPIFFE(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	config := ServerConfig{
+		StoragePath: "storage",
+		Identity:    Identity{Source: IdentitySPIFFE},
+		Clients:     []ClientACL{{Identity: "spiffe://example.org/backup", Permissions: allPermissions}},
+	}
+	acl, err := newAccessList(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := Server{ServerConfig: config, identity: config.Identity, acl: acl}
+
+	streamOf := func(cert *x509.Certificate) grpc.ServerStream {
+		stream := mock.NewMockAlcatraz_UploadFileServer(ctrl)
+		stream.EXPECT().Context().AnyTimes().Return(peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		}))
+		return stream
+	}
+	info := &grpc.StreamServerInfo{FullMethod: "/pb.Alcatraz/UploadFile"}
+
+	backup, _ := url.Parse("spiffe://example.org/backup")
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "Malcolm"}, URIs: []*url.URL{backup}}
+
+	identity := ""
+	err = server.authClient(nil, streamOf(cert), info, func(srv interface{}, ss grpc.ServerStream) error {
+		identity = server.identityFromCtx(ss.Context())
+		return nil
+	})
+	if err != nil || identity != "spiffe://example.org/backup" {
+		t.Errorf("expected the SPIFFE ID, got %q, %v", identity, err)
+	}
+	if loc, _ := server.locate(identity, "a.txt", true); loc.path(server.StoragePath) != filepath.Join("storage", "example.org", "backup", "a.txt") {
+		t.Errorf("unexpected client path %q", loc.path(server.StoragePath))
+	}
+
+	// the common name is not used
+	cert = &x509.Certificate{Subject: pkix.Name{CommonName: "spiffe://example.org/backup"}}
+	err = server.authClient(nil, streamOf(cert), info, func(interface{}, grpc.ServerStream) error { return nil })
+	if grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("no SPIFFE ID, expected Unauthenticated, got %v", err)
+	}
+}
@@ -0,0 +1,659 @@
+package alcatraz
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// indexFile is the metadata index in the internal folder.
+const indexFile = "index.db"
+
+// hashAlgorithm is the algorithm of the verified hashes, the same as the upload's.
+const hashAlgorithm = "sha256"
+
+var (
+	filesBucket    = []byte("files")
+	versionsBucket = []byte("versions")
+	trashBucket    = []byte("trash")
+)
+
+// fileRecord is the metadata of a committed upload.
+type fileRecord struct {
+	// Owner is the client's identity or "@space"
+	Owner     string    `json:"owner"`
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	Size      int64     `json:"size"`
+	Hash      string    `json:"hash"`
+	Algorithm string    `json:"algorithm"`
+	Uploaded  time.Time `json:"uploaded"`
+	// Verified is when the scrubber last found the stored file matching the hash
+	Verified time.Time `json:"verified"`
+	// Identity and Address are of the uploading client, empty for the reindexed files
+	Identity string `json:"identity"`
+	Address  string `json:"address"`
+	// current is set by list for the current files
+	current bool
+}
+
+// fileInfo converts the record to the listed file, the name is prefixed with the space.
+func (rec fileRecord) fileInfo(prefix string) *pb.FileInfo {
+	modified, _ := ptypes.TimestampProto(rec.Uploaded)
+	return &pb.FileInfo{
+		Name:      prefix + rec.Name,
+		Size:      rec.Size,
+		Modified:  modified,
+		Version:   rec.Version,
+		Current:   rec.current,
+		Hash:      rec.Hash,
+		Algorithm: rec.Algorithm,
+	}
+}
+
+// metaIndex keeps the records of the current files, their versions and the deleted files,
+// by owner and name. All changes of a file are done in one transaction.
+type metaIndex struct {
+	db *bolt.DB
+}
+
+// openIndex opens the index in the storage, it's created empty when missing.
+func openIndex(storagePath string) (*metaIndex, bool, error) {
+	filename := filepath.Join(storagePath, internalDir, indexFile)
+	_, err := os.Stat(filename)
+	created := os.IsNotExist(err)
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return nil, false, fmt.Errorf("failed to create directories: %v", err)
+	}
+
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, false, fmt.Errorf("metadata index %q is used by another process", filename)
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to open metadata index: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{filesBucket, versionsBucket, trashBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, false, fmt.Errorf("failed to create metadata index: %v", err)
+	}
+	return &metaIndex{db: db}, created, nil
+}
+
+func (idx *metaIndex) Close() error {
+	if idx == nil {
+		return nil
+	}
+	return idx.db.Close()
+}
+
+// indexKey joins the parts with zero bytes, which sort before any name character.
+func indexKey(parts ...string) []byte {
+	return []byte(strings.Join(parts, "\x00"))
+}
+
+func putRecord(b *bolt.Bucket, key []byte, rec fileRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// commit stores the record of the uploaded file. The record of the replaced file is moved to
+// the versions, when it was kept as version keptAs.
+func (idx *metaIndex) commit(rec fileRecord, keptAs string) error {
+	if idx == nil {
+		return nil
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		files, key := tx.Bucket(filesBucket), indexKey(rec.Owner, rec.Name)
+		if old := files.Get(key); old != nil && keptAs != "" {
+			var oldRec fileRecord
+			if err := json.Unmarshal(old, &oldRec); err != nil {
+				return err
+			}
+			oldRec.Version = keptAs
+			if err := putRecord(tx.Bucket(versionsBucket), indexKey(rec.Owner, rec.Name, keptAs), oldRec); err != nil {
+				return err
+			}
+		}
+		return putRecord(files, key, rec)
+	})
+}
+
+// removeVersion removes the record of a pruned version.
+func (idx *metaIndex) removeVersion(owner, name, version string) error {
+	if idx == nil {
+		return nil
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(versionsBucket).Delete(indexKey(owner, name, version))
+	})
+}
+
+// trash moves the record of the deleted file to the trash, by the trash ID.
+func (idx *metaIndex) trash(owner, name, id string) error {
+	if idx == nil {
+		return nil
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		files, key := tx.Bucket(filesBucket), indexKey(owner, name)
+		data := files.Get(key)
+		if data == nil {
+			return nil
+		}
+		if err := tx.Bucket(trashBucket).Put(indexKey(owner, id), data); err != nil {
+			return err
+		}
+		return files.Delete(key)
+	})
+}
+
+// restore moves the record of the deleted file back from the trash.
+func (idx *metaIndex) restore(owner, id string) error {
+	if idx == nil {
+		return nil
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		trash, key := tx.Bucket(trashBucket), indexKey(owner, id)
+		data := trash.Get(key)
+		if data == nil {
+			return nil
+		}
+		var rec fileRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return err
+		}
+		if err := tx.Bucket(filesBucket).Put(indexKey(owner, rec.Name), data); err != nil {
+			return err
+		}
+		return trash.Delete(key)
+	})
+}
+
+// removeTrash removes the record of the file removed from the trash.
+func (idx *metaIndex) removeTrash(owner, id string) error {
+	if idx == nil {
+		return nil
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(trashBucket).Delete(indexKey(owner, id))
+	})
+}
+
+// move renames the records of the file and its versions.
+func (idx *metaIndex) move(owner, from, to string) error {
+	if idx == nil {
+		return nil
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		files := tx.Bucket(filesBucket)
+		if data := files.Get(indexKey(owner, from)); data != nil {
+			var rec fileRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+			rec.Name = to
+			if err := files.Delete(indexKey(owner, from)); err != nil {
+				return err
+			}
+			if err := putRecord(files, indexKey(owner, to), rec); err != nil {
+				return err
+			}
+		}
+
+		versions := tx.Bucket(versionsBucket)
+		prefix := append(indexKey(owner, from), 0)
+		moved := []fileRecord{}
+		c := versions.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var rec fileRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			moved = append(moved, rec)
+		}
+		for _, rec := range moved {
+			if err := versions.Delete(indexKey(owner, from, rec.Version)); err != nil {
+				return err
+			}
+			rec.Name = to
+			if err := putRecord(versions, indexKey(owner, to, rec.Version), rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// get returns the record of the current file, or of its version when version is not empty.
+func (idx *metaIndex) get(owner, name, version string) (fileRecord, bool, error) {
+	var rec fileRecord
+	if idx == nil {
+		return rec, false, nil
+	}
+
+	found := false
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(filesBucket).Get(indexKey(owner, name))
+		if data != nil {
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+		}
+		if version != "" && (data == nil || rec.Version != version) {
+			data = tx.Bucket(versionsBucket).Get(indexKey(owner, name, version))
+			if data != nil {
+				rec = fileRecord{}
+				if err := json.Unmarshal(data, &rec); err != nil {
+					return err
+				}
+			}
+		}
+		found = data != nil
+		return nil
+	})
+	return rec, found, err
+}
+
+// list returns the records of the owner's files under the prefix folder, sorted by name.
+// The versions of every file follow it, the newest first, when versions is set.
+func (idx *metaIndex) list(owner, prefix string, versions bool) ([]fileRecord, error) {
+	records := []fileRecord{}
+	if idx == nil {
+		return records, nil
+	}
+
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		scan := func(bucket []byte, fn func(fileRecord)) error {
+			start := indexKey(owner, prefix)
+			c := tx.Bucket(bucket).Cursor()
+			for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, start); k, v = c.Next() {
+				var rec fileRecord
+				if err := json.Unmarshal(v, &rec); err != nil {
+					return err
+				}
+				if prefix == "" || rec.Name == prefix || strings.HasPrefix(rec.Name, prefix+"/") {
+					fn(rec)
+				}
+			}
+			return nil
+		}
+
+		err := scan(filesBucket, func(rec fileRecord) {
+			rec.current = true
+			records = append(records, rec)
+		})
+		if err != nil {
+			return err
+		}
+		if !versions {
+			return nil
+		}
+
+		// the files are merged with their versions, the deleted files have only versions
+		old := map[string][]fileRecord{}
+		names := []string{}
+		err = scan(versionsBucket, func(rec fileRecord) {
+			if old[rec.Name] == nil {
+				names = append(names, rec.Name)
+			}
+			old[rec.Name] = append([]fileRecord{rec}, old[rec.Name]...)
+		})
+		if err != nil {
+			return err
+		}
+
+		merged := []fileRecord{}
+		for len(records) > 0 || len(names) > 0 {
+			if len(names) == 0 || (len(records) > 0 && records[0].Name <= names[0]) {
+				rec := records[0]
+				records = records[1:]
+				merged = append(merged, rec)
+				if len(names) > 0 && names[0] == rec.Name {
+					merged = append(merged, old[rec.Name]...)
+					names = names[1:]
+				}
+				continue
+			}
+			merged = append(merged, old[names[0]]...)
+			names = names[1:]
+		}
+		records = merged
+		return nil
+	})
+	return records, err
+}
+
+// all returns the records of all current files and versions, the current ones are marked.
+func (idx *metaIndex) all() ([]fileRecord, error) {
+	records := []fileRecord{}
+	if idx == nil {
+		return records, nil
+	}
+
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{filesBucket, versionsBucket} {
+			err := tx.Bucket(bucket).ForEach(func(_, v []byte) error {
+				rec := fileRecord{current: bytes.Equal(bucket, filesBucket)}
+				if err := json.Unmarshal(v, &rec); err != nil {
+					return err
+				}
+				records = append(records, rec)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// recordKey returns the bucket and the key of the current file or the version.
+func recordKey(rec fileRecord) ([]byte, []byte) {
+	if rec.current {
+		return filesBucket, indexKey(rec.Owner, rec.Name)
+	}
+	return versionsBucket, indexKey(rec.Owner, rec.Name, rec.Version)
+}
+
+// verify sets the verification time of the record, if it's still the same file.
+func (idx *metaIndex) verify(rec fileRecord, at time.Time) error {
+	if idx == nil {
+		return nil
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		bucket, key := recordKey(rec)
+		data := tx.Bucket(bucket).Get(key)
+		if data == nil {
+			return nil
+		}
+		var stored fileRecord
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		if stored.Version != rec.Version || stored.Hash != rec.Hash {
+			return nil
+		}
+		stored.Verified = at
+		return putRecord(tx.Bucket(bucket), key, stored)
+	})
+}
+
+// remove removes the record of the current file or the version.
+func (idx *metaIndex) remove(rec fileRecord) error {
+	if idx == nil {
+		return nil
+	}
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		bucket, key := recordKey(rec)
+		return tx.Bucket(bucket).Delete(key)
+	})
+}
+
+// usage returns the bytes of the owner's current files and versions, and the number of the
+// current files.
+func (idx *metaIndex) usage(owner string) (int64, int64, error) {
+	records, err := idx.list(owner, "", true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bytes, files := int64(0), int64(0)
+	for _, rec := range records {
+		bytes += rec.Size
+		if rec.current {
+			files++
+		}
+	}
+	return bytes, files, nil
+}
+
+// hashFile returns the hex encoded SHA-256 of the file, read at the speed of the limiter.
+func hashFile(ctx context.Context, filename string, limiter *rateLimiter) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	chunk := make([]byte, DefaultChunkSize)
+	for {
+		n, err := file.Read(chunk)
+		if n > 0 {
+			if err := limiter.wait(ctx, n); err != nil {
+				return "", err
+			}
+			hash.Write(chunk[:n])
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// reindex rebuilds the index from the files of the owners, their versions and their trash.
+// The uploading client of the files is not known anymore, and the hashes are computed again.
+func (s *Server) reindex() error {
+	owners := s.owners()
+
+	type entry struct {
+		bucket, key []byte
+		rec         fileRecord
+	}
+	entries := []entry{}
+	indexedFiles, indexedVersions := 0, 0
+	record := func(owner, name, filename string, info os.FileInfo) (fileRecord, error) {
+		hash, err := hashFile(context.Background(), filename, nil)
+		if err != nil {
+			return fileRecord{}, err
+		}
+		return fileRecord{
+			Owner:     owner,
+			Name:      name,
+			Version:   versionID(info.ModTime()),
+			Size:      info.Size(),
+			Hash:      hash,
+			Algorithm: hashAlgorithm,
+			Uploaded:  info.ModTime(),
+		}, nil
+	}
+
+	for _, loc := range owners {
+		root := loc.path(s.StoragePath)
+		err := filepath.Walk(root, func(fullname string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && fullname == root {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, fullname)
+			if err != nil {
+				return err
+			}
+			rec, err := record(loc.owner, filepath.ToSlash(rel), fullname, info)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{filesBucket, indexKey(rec.Owner, rec.Name), rec})
+			indexedFiles++
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to index %q: %v", loc.owner, err)
+		}
+
+		names, err := versionedFiles(s.versionsDir(loc.owner, ""))
+		if err != nil {
+			return fmt.Errorf("failed to index versions of %q: %v", loc.owner, err)
+		}
+		for _, name := range names {
+			name = filepath.ToSlash(name)
+			versions, err := s.versions(loc.owner, name)
+			if err != nil {
+				return err
+			}
+			for _, v := range versions {
+				info, err := os.Stat(v.filename)
+				if err != nil {
+					return err
+				}
+				rec, err := record(loc.owner, name, v.filename, info)
+				if err != nil {
+					return err
+				}
+				rec.Version = v.id
+				entries = append(entries, entry{versionsBucket, indexKey(rec.Owner, rec.Name, rec.Version), rec})
+				indexedVersions++
+			}
+		}
+
+		trash, err := s.trashEntries(loc.owner, "")
+		if err != nil {
+			return fmt.Errorf("failed to index trash of %q: %v", loc.owner, err)
+		}
+		for _, deleted := range trash {
+			filename := filepath.Join(s.trashDir(loc.owner), deleted.ID, trashData)
+			info, err := os.Stat(filename)
+			if err != nil {
+				continue
+			}
+			rec, err := record(loc.owner, deleted.Name, filename, info)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{trashBucket, indexKey(rec.Owner, deleted.ID), rec})
+		}
+	}
+
+	err := s.index.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{filesBucket, versionsBucket, trashBucket} {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		for _, e := range entries {
+			if err := putRecord(tx.Bucket(e.bucket), e.key, e.rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write metadata index: %v", err)
+	}
+
+	// the usage is counted again from the index
+	if s.usage != nil {
+		s.usage.mu.Lock()
+		s.usage.usage = map[string]*usage{}
+		err = s.usage.save()
+		s.usage.mu.Unlock()
+	} else {
+		err = os.Remove(filepath.Join(s.StoragePath, internalDir, usageFile))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reset usage index: %v", err)
+	}
+
+	log.Infof("Indexed %d files and %d versions", indexedFiles, indexedVersions)
+	return nil
+}
+
+// openStorage opens the storage of the stopped server, for the maintenance commands.
+func openStorage(config ServerConfig) (*Server, error) {
+	if config.LogLevel != "" {
+		lvl, err := logrus.ParseLevel(config.LogLevel)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse log level: %v", err)
+		}
+		logrus.SetLevel(lvl)
+	}
+
+	acl, err := newAccessList(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clients: %v", err)
+	}
+	spaces, err := newSpaces(config, acl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spaces: %v", err)
+	}
+	auditLog, err := newAuditLogger(config.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+	mirrorDirs, err := splitStorage(&config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage: %v", err)
+	}
+	if err := restoreMainDir(config.StoragePath, mirrorDirs); err != nil {
+		return nil, err
+	}
+	usage, err := loadUsageIndex(config.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	index, _, err := openIndex(config.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	usage.index = index
+
+	return &Server{ServerConfig: config, auditLog: auditLog, usage: usage, index: index, mirrorDirs: mirrorDirs, acl: acl, spaces: spaces}, nil
+}
+
+// Reindex rebuilds the metadata index of the storage from the stored files. It fails while
+// the server is running, which keeps the index open.
+func Reindex(config ServerConfig) error {
+	s, err := openStorage(config)
+	if err != nil {
+		return err
+	}
+	defer s.index.Close()
+
+	return s.reindex()
+}
@@ -0,0 +1,239 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndexList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	idx, created, err := openIndex(dir)
+	if err != nil || !created {
+		t.Fatalf("expected a new index, got %v, %v", created, err)
+	}
+	defer idx.Close()
+
+	commits := []struct {
+		rec    fileRecord
+		keptAs string
+	}{
+		{fileRecord{Owner: "Malcolm", Name: "a.txt", Version: "1", Size: 1}, ""},
+		{fileRecord{Owner: "Malcolm", Name: "a.txt", Version: "2", Size: 2}, "1"},
+		{fileRecord{Owner: "Malcolm", Name: "docs/b.txt", Version: "3", Size: 3}, ""},
+		{fileRecord{Owner: "Malcolm", Name: "docsX.txt", Version: "4", Size: 4}, ""},
+		{fileRecord{Owner: "Malcolm2", Name: "a.txt", Version: "5", Size: 5}, ""},
+	}
+	for _, c := range commits {
+		if err := idx.commit(c.rec, c.keptAs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(records []fileRecord) string {
+		list := []string{}
+		for _, rec := range records {
+			list = append(list, rec.Name+"@"+rec.Version)
+		}
+		return strings.Join(list, ",")
+	}
+	tests := []struct {
+		prefix   string
+		versions bool
+		expected string
+	}{
+		{"", false, "a.txt@2,docs/b.txt@3,docsX.txt@4"},
+		{"", true, "a.txt@2,a.txt@1,docs/b.txt@3,docsX.txt@4"},
+		{"docs", false, "docs/b.txt@3"},
+		{"a.txt", true, "a.txt@2,a.txt@1"},
+	}
+	for _, test := range tests {
+		records, err := idx.list("Malcolm", test.prefix, test.versions)
+		if err != nil || names(records) != test.expected {
+			t.Errorf("%q, versions %v: expected %s, got %s, %v", test.prefix, test.versions, test.expected, names(records), err)
+		}
+	}
+
+	// the versions of the deleted files are still listed
+	if err := idx.trash("Malcolm", "a.txt", "T1"); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := idx.list("Malcolm", "a.txt", true); names(records) != "a.txt@1" {
+		t.Errorf("expected only the version, got %s", names(records))
+	}
+	if err := idx.restore("Malcolm", "T1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.move("Malcolm", "a.txt", "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := idx.list("Malcolm", "docs", true); names(records) != "docs/a.txt@2,docs/a.txt@1,docs/b.txt@3" {
+		t.Errorf("expected the moved file with its version, got %s", names(records))
+	}
+
+	// the versions count in the bytes, not in the files
+	if bytes, files, err := idx.usage("Malcolm"); bytes != 10 || files != 3 || err != nil {
+		t.Errorf("expected 10 bytes in 3 files, got %d, %d, %v", bytes, files, err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Malcolm", nil)
+
+	config := ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients: []ClientACL{{
+			Identity:    "Malcolm",
+			Permissions: []Permission{PermissionUpload, PermissionList},
+		}},
+		Versioning: Versioning{Enabled: true},
+		LogLevel:   "info",
+	}
+
+	// the files stored before the index are indexed when it's created
+	if err := os.MkdirAll(filepath.Join(dir, "storage", "Malcolm"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "storage", "Malcolm", "old.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	for _, content := range []string{"first", "second"} {
+		if err := client.Upload(ctx, "a.txt", strings.NewReader(content), WithMetadata(map[string]string{"content": content})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum := sha256.Sum256([]byte("second"))
+	info, err := client.Stat(ctx, "a.txt")
+	if err != nil || info.Hash != hex.EncodeToString(sum[:]) || info.Algorithm != "sha256" || info.UploadedBy != "Malcolm" ||
+		info.Address == "" || info.Metadata["content"] != "second" || !info.Current {
+		t.Errorf("expected the indexed upload, got %+v, %v", info, err)
+	}
+	if files, err := client.List(ctx, "", WithVersions()); err != nil || len(files) != 3 || files[2].Name != "old.txt" {
+		t.Errorf("expected two versions of a.txt and old.txt, got %+v, %v", files, err)
+	}
+
+	// the index is used by the running server
+	if err := Reindex(config); err == nil || !strings.Contains(err.Error(), "another process") {
+		t.Errorf("expected the index to be locked, got %v", err)
+	}
+
+	// the reindexed files lose the uploading client, but keep the hash
+	if err := server.reindex(); err != nil {
+		t.Fatal(err)
+	}
+	info, err = client.Stat(ctx, "a.txt")
+	if err != nil || info.Hash != hex.EncodeToString(sum[:]) || info.UploadedBy != "" {
+		t.Errorf("expected the reindexed file, got %+v, %v", info, err)
+	}
+	if files, err := client.List(ctx, "", WithVersions()); err != nil || len(files) != 3 {
+		t.Errorf("expected the same files after reindex, got %+v, %v", files, err)
+	}
+	if usage, _ := client.Quota(ctx, ""); usage.UsedFiles != 2 || usage.UsedBytes != 14 {
+		t.Errorf("expected the usage to be counted from the index, got %+v", usage)
+	}
+}
+
+func TestCommitUnindexed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+
+	for _, versioning := range []bool{false, true} {
+		server, err := NewServer(ServerConfig{
+			StoragePath:  filepath.Join(dir, fmt.Sprintf("storage-%v", versioning)),
+			Certificates: serverFiles,
+			Clients:      []ClientACL{{Identity: "Malcolm", Permissions: []Permission{PermissionUpload}}},
+			Versioning:   Versioning{Enabled: versioning},
+			LogLevel:     "info",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		loc, err := server.locate("Malcolm", "a.txt", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(loc.path(server.StoragePath)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		commit := func(content string) (string, error) {
+			temp, err := server.tempFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			temp.WriteString(content)
+			temp.Close()
+			return temp.Name(), server.commitFile(loc, temp.Name(), map[string]string{"content": content}, fileRecord{})
+		}
+		if _, err := commit("first"); err != nil {
+			t.Fatal(err)
+		}
+
+		// the file isn't replaced without its record
+		server.index.Close()
+		temp, err := commit("second")
+		if err == nil {
+			t.Errorf("versioning %v: expected the commit to fail without the index", versioning)
+		}
+		if data, err := ioutil.ReadFile(loc.path(server.StoragePath)); err != nil || string(data) != "first" {
+			t.Errorf("versioning %v: expected the replaced file back, got %q, %v", versioning, data, err)
+		}
+		if metadata, err := server.readMetadata(loc.owner, loc.name); err != nil || metadata["content"] != "first" {
+			t.Errorf("versioning %v: expected the replaced metadata back, got %v, %v", versioning, metadata, err)
+		}
+		if versions, err := server.versions(loc.owner, loc.name); err != nil || len(versions) != 0 {
+			t.Errorf("versioning %v: expected no kept versions, got %+v, %v", versioning, versions, err)
+		}
+		if data, err := ioutil.ReadFile(temp); err != nil || string(data) != "second" {
+			t.Errorf("versioning %v: expected the upload left in the temp file, got %q, %v", versioning, data, err)
+		}
+		if files, _ := ioutil.ReadDir(filepath.Dir(temp)); len(files) != 1 {
+			t.Errorf("versioning %v: expected only the temp file, got %d files", versioning, len(files))
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package alcatraz
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Limits restrict the load a client could put on the server, zero means unlimited.
+type Limits struct {
+	// MaxStreams is the number of concurrent uploads and downloads
+	MaxStreams int `yaml:"max_streams"`
+	// RequestsPerMinute counts all requests
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	// BytesPerSecond is the upload speed, faster uploads are slowed down
+	BytesPerSecond int64 `yaml:"bytes_per_second"`
+	// MaxUploadSize is the largest stored file, after decompression. Uploads with unknown size
+	// are limited to DefaultMaxUploadSize, when it's not set
+	MaxUploadSize int64 `yaml:"max_upload_size"`
+}
+
+// DefaultMaxUploadSize limits the uploads with unknown size, so a small compressed upload
+// can't fill the disk.
+const DefaultMaxUploadSize = 16 << 30
+
+func (l Limits) validate() error {
+	if l.MaxStreams < 0 || l.RequestsPerMinute < 0 || l.BytesPerSecond < 0 || l.MaxUploadSize < 0 {
+		return fmt.Errorf("limits can't be negative")
+	}
+	return nil
+}
+
+// merge returns the limits, with the unset ones taken from defaults.
+func (l Limits) merge(defaults Limits) Limits {
+	if l.MaxStreams == 0 {
+		l.MaxStreams = defaults.MaxStreams
+	}
+	if l.RequestsPerMinute == 0 {
+		l.RequestsPerMinute = defaults.RequestsPerMinute
+	}
+	if l.BytesPerSecond == 0 {
+		l.BytesPerSecond = defaults.BytesPerSecond
+	}
+	if l.MaxUploadSize == 0 {
+		l.MaxUploadSize = defaults.MaxUploadSize
+	}
+	return l
+}
+
+// sizeLimit returns the most bytes the upload could write, the declared size or the maximum.
+func (l Limits) sizeLimit(declared int64) (int64, error) {
+	if l.MaxUploadSize > 0 && declared > l.MaxUploadSize {
+		return 0, grpc.Errorf(codes.ResourceExhausted, "file of %d bytes is larger than the allowed %d", declared, l.MaxUploadSize)
+	}
+	switch {
+	case declared >= 0:
+		return declared, nil
+	case l.MaxUploadSize > 0:
+		return l.MaxUploadSize, nil
+	}
+	return DefaultMaxUploadSize, nil
+}
+
+// maxRetries is how many times the client retries a request rejected by the server's limits.
+const maxRetries = 5
+
+// retryAfterKey is the trailer with the seconds, after which the rejected request could be retried.
+const retryAfterKey = "retry-after"
+
+// streamRetryAfter is suggested when the client has too many streams, there is no way to know
+// when one of them will finish.
+const streamRetryAfter = time.Second
+
+// clientLimiter is the state of the client's limits.
+type clientLimiter struct {
+	streams int
+	// requests is a token bucket, holding at most a minute worth of requests
+	requests float64
+	last     time.Time
+	ingest   *rateLimiter
+}
+
+// limiterSet keeps the limiters of the clients, they survive the reloads.
+type limiterSet struct {
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+	now     func() time.Time
+	swept   time.Time
+}
+
+func newLimiterSet() *limiterSet {
+	return &limiterSet{clients: map[string]*clientLimiter{}, now: time.Now}
+}
+
+// acquire counts the request, and the stream when stream is set. It returns how long to wait
+// before retrying, when the request is over the limits.
+func (l *limiterSet) acquire(identity string, limits Limits, stream bool) (*clientLimiter, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[identity]
+	if !ok {
+		c = &clientLimiter{}
+		l.clients[identity] = c
+	}
+
+	if stream && limits.MaxStreams > 0 && c.streams >= limits.MaxStreams {
+		return nil, streamRetryAfter, grpc.Errorf(codes.ResourceExhausted, "too many concurrent streams, the limit is %d", limits.MaxStreams)
+	}
+
+	if limits.RequestsPerMinute > 0 {
+		now, capacity := l.now(), float64(limits.RequestsPerMinute)
+		if c.last.IsZero() {
+			c.requests = capacity
+		} else {
+			c.requests += now.Sub(c.last).Minutes() * capacity
+		}
+		if c.requests > capacity {
+			c.requests = capacity
+		}
+		c.last = now
+
+		if c.requests < 1 {
+			wait := time.Duration((1 - c.requests) / capacity * float64(time.Minute))
+			return nil, wait, grpc.Errorf(codes.ResourceExhausted, "too many requests, the limit is %d per minute", limits.RequestsPerMinute)
+		}
+		c.requests--
+	}
+
+	if limits.BytesPerSecond == 0 {
+		c.ingest = nil
+	} else if c.ingest == nil || c.ingest.limit != limits.BytesPerSecond {
+		c.ingest = &rateLimiter{limit: limits.BytesPerSecond, now: l.now}
+	}
+
+	if stream {
+		c.streams++
+	}
+	return c, 0, nil
+}
+
+// forgetIdle removes the limiters with the prefix, which weren't used for idle, so the addresses
+// of the anonymous requests don't pile up. It runs at most once per idle.
+func (l *limiterSet) forgetIdle(prefix string, idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.swept) < idle {
+		return
+	}
+	l.swept = now
+	for key, c := range l.clients {
+		if strings.HasPrefix(key, prefix) && c.streams == 0 && now.Sub(c.last) >= idle {
+			delete(l.clients, key)
+		}
+	}
+}
+
+func (l *limiterSet) release(identity string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clients[identity].streams--
+}
+
+// anonymousKey counts the requests without a known identity from all addresses, the keys of
+// the addresses start with it too. The identities are never empty, they can't collide.
+const anonymousKey = "\x00anonymous"
+
+// limitAnonymous applies the enrollment's rate limit to the requests without a known identity,
+// by the peer's address and from all of them. Only the enrollment passes the authentication
+// without one.
+func (s *Server) limitAnonymous(ctx context.Context, method string) (time.Duration, error) {
+	perAddress := s.Enrollment.requestsPerMinute()
+	address := "unknown"
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		address = p.Addr.String()
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = host
+		}
+	}
+
+	s.limiters.forgetIdle(anonymousKey+" ", time.Minute)
+	if _, retryAfter, err := s.limiters.acquire(anonymousKey+" "+address, Limits{RequestsPerMinute: perAddress}, false); err != nil {
+		s.rejectLimited(address, method, err)
+		return retryAfter, err
+	}
+	if _, retryAfter, err := s.limiters.acquire(anonymousKey, Limits{RequestsPerMinute: 10 * perAddress}, false); err != nil {
+		s.rejectLimited(address, method, err)
+		return retryAfter, err
+	}
+	return 0, nil
+}
+
+// limitStream applies the client's limits to the streams, it runs after authClient.
+func (s *Server) limitStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	identity := s.identityFromCtx(ss.Context())
+	client, ok := s.accessList().lookup(identity)
+	if identity == "" || !ok {
+		if retryAfter, err := s.limitAnonymous(ss.Context(), info.FullMethod); err != nil {
+			ss.SetTrailer(retryAfterTrailer(retryAfter))
+			return err
+		}
+		return handler(srv, ss)
+	}
+
+	limiter, retryAfter, err := s.limiters.acquire(identity, client.Limits, true)
+	if err != nil {
+		s.rejectLimited(identity, info.FullMethod, err)
+		ss.SetTrailer(retryAfterTrailer(retryAfter))
+		return err
+	}
+	defer s.limiters.release(identity)
+
+	if limiter.ingest != nil {
+		ss = &ingestStream{ServerStream: ss, limiter: limiter.ingest}
+	}
+	return handler(srv, ss)
+}
+
+// limitUnary applies the client's request rate limit, it runs after authClientUnary.
+func (s *Server) limitUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	identity := s.identityFromCtx(ctx)
+	client, ok := s.accessList().lookup(identity)
+	if identity == "" || !ok {
+		if retryAfter, err := s.limitAnonymous(ctx, info.FullMethod); err != nil {
+			grpc.SetTrailer(ctx, retryAfterTrailer(retryAfter))
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+
+	if _, retryAfter, err := s.limiters.acquire(identity, client.Limits, false); err != nil {
+		s.rejectLimited(identity, info.FullMethod, err)
+		grpc.SetTrailer(ctx, retryAfterTrailer(retryAfter))
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) rejectLimited(identity, method string, err error) {
+	log.WithFields(logrus.Fields{"identity": identity, "method": method}).Warnf("Request rejected: %s", status.Convert(err).Message())
+}
+
+func retryAfterTrailer(d time.Duration) metadata.MD {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return metadata.Pairs(retryAfterKey, strconv.FormatInt(seconds, 10))
+}
+
+// retryAfter returns the wait advised by the server in the trailer, zero if there is none.
+func retryAfter(trailer metadata.MD) time.Duration {
+	values := trailer.Get(retryAfterKey)
+	if len(values) == 0 {
+		return 0
+	}
+	seconds, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ingestStream slows down the client's uploads to its bytes per second limit.
+type ingestStream struct {
+	grpc.ServerStream
+	limiter *rateLimiter
+}
+
+func (s *ingestStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if req, ok := m.(*pb.UploadRequest); ok && len(req.GetChunk()) > 0 {
+		return s.limiter.wait(s.Context(), len(req.GetChunk()))
+	}
+	return nil
+}
+
+// retryLimited retries the unary requests rejected by the server's limits, after the advised wait.
+func retryLimited(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	for attempt := 0; ; attempt++ {
+		var trailer metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+
+		wait := retryAfter(trailer)
+		if status.Code(err) != codes.ResourceExhausted || wait == 0 || attempt == maxRetries {
+			return err
+		}
+		log.Warnf("Server is busy, retrying %s in %s: %v", method, wait, status.Convert(err).Message())
+		if sleep(ctx, wait) != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package alcatraz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimitAnonymous(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	server := &Server{ServerConfig: ServerConfig{Enrollment: Enrollment{RequestsPerMinute: 2}}, limiters: newLimiterSet()}
+	server.limiters.now = func() time.Time { return now }
+
+	info := &grpc.UnaryServerInfo{FullMethod: enrollMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	enroll := func(host string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(host), Port: 40000}})
+		_, err := server.limitUnary(ctx, nil, info, handler)
+		return err
+	}
+
+	// the enrollments are limited by address
+	for i := 0; i < 2; i++ {
+		if err := enroll("10.0.0.1"); err != nil {
+			t.Fatalf("expected success, got %v", err)
+		}
+	}
+	if err := enroll("10.0.0.1"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted, got %v", err)
+	}
+
+	// and from all addresses
+	for i := 2; i <= 19; i++ {
+		if err := enroll(fmt.Sprintf("10.0.0.%d", i)); err != nil {
+			t.Fatalf("expected success, got %v", err)
+		}
+	}
+	if err := enroll("10.0.1.1"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted over the total limit, got %v", err)
+	}
+
+	// the idle addresses are forgotten
+	now = now.Add(2 * time.Minute)
+	if err := enroll("10.0.0.1"); err != nil {
+		t.Errorf("expected success after the wait, got %v", err)
+	}
+	if n := len(server.limiters.clients); n != 2 {
+		t.Errorf("expected the limiters of one address and the total, got %d", n)
+	}
+}
+
+func TestLimiterSet(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	limiters := newLimiterSet()
+	limiters.now = func() time.Time { return now }
+	limits := Limits{MaxStreams: 1, RequestsPerMinute: 2, BytesPerSecond: 1000}
+
+	limiter, _, err := limiters.acquire("Reese", limits, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limiter.ingest == nil || limiter.ingest.limit != 1000 {
+		t.Errorf("expected ingest limit 1000, got %+v", limiter.ingest)
+	}
+
+	// the streams are counted until released
+	_, wait, err := limiters.acquire("Reese", limits, true)
+	if status.Code(err) != codes.ResourceExhausted || wait != streamRetryAfter {
+		t.Errorf("expected ResourceExhausted with retry after %s, got %v, %s", streamRetryAfter, err, wait)
+	}
+	limiters.release("Reese")
+
+	// the rejected stream is not counted as request
+	if _, _, err := limiters.acquire("Reese", limits, false); err != nil {
+		t.Errorf("expected second request to pass, got %v", err)
+	}
+	_, wait, err = limiters.acquire("Reese", limits, false)
+	if status.Code(err) != codes.ResourceExhausted || wait != 30*time.Second {
+		t.Errorf("expected ResourceExhausted with retry after 30s, got %v, %s", err, wait)
+	}
+
+	// other clients have their own limits
+	if _, _, err := limiters.acquire("Dewey", limits, false); err != nil {
+		t.Errorf("expected other client to pass, got %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, _, err := limiters.acquire("Reese", limits, false); err != nil {
+		t.Errorf("expected request after the wait to pass, got %v", err)
+	}
+
+	if limits := (Limits{RequestsPerMinute: 10}).merge(Limits{MaxStreams: 5, RequestsPerMinute: 100}); limits != (Limits{MaxStreams: 5, RequestsPerMinute: 10}) {
+		t.Errorf("unexpected merged limits %+v", limits)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		trailer metadata.MD
+		wait    time.Duration
+	}{
+		{nil, 0},
+		{metadata.Pairs(retryAfterKey, "3"), 3 * time.Second},
+		{metadata.Pairs(retryAfterKey, "soon"), 0},
+		{retryAfterTrailer(1500 * time.Millisecond), 2 * time.Second},
+		{retryAfterTrailer(0), time.Second},
+	}
+
+	for _, test := range tests {
+		if wait := retryAfter(test.trailer); wait != test.wait {
+			t.Errorf("%v: expected %s, got %s", test.trailer, test.wait, wait)
+		}
+	}
+}
+
+func TestLimitStreams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Reese", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients:      []ClientACL{{Identity: "Reese", Permissions: []Permission{PermissionUpload}}},
+		Limits:       Limits{MaxStreams: 1},
+		LogLevel:     "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	// the first upload holds the only stream, until the pipe is closed
+	pr, pw := io.Pipe()
+	first := make(chan error, 1)
+	go func() { first <- client.Upload(ctx, "first.txt", pr) }()
+	pw.Write([]byte("first"))
+	for streams := 0; streams == 0; time.Sleep(10 * time.Millisecond) {
+		server.limiters.mu.Lock()
+		if limiter, ok := server.limiters.clients["Reese"]; ok {
+			streams = limiter.streams
+		}
+		server.limiters.mu.Unlock()
+	}
+
+	// not seekable sources can't be retried
+	err = client.Upload(ctx, "second.txt", struct{ io.Reader }{strings.NewReader("second")})
+	var uploadErr *Error
+	if !errors.As(err, &uploadErr) || uploadErr.Code() != codes.ResourceExhausted || uploadErr.RetryAfter != streamRetryAfter {
+		t.Errorf("expected ResourceExhausted with retry after, got %v", err)
+	}
+
+	// the retry succeeds after the first upload is done
+	time.AfterFunc(500*time.Millisecond, func() { pw.Close() })
+	if err := client.Upload(ctx, "second.txt", strings.NewReader("second")); err != nil {
+		t.Errorf("expected the retried upload to succeed, got %v", err)
+	}
+	if err := <-first; err != nil {
+		t.Errorf("expected the first upload to succeed, got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "storage", "Reese", "second.txt"))
+	if err != nil || string(data) != "second" {
+		t.Errorf("expected the whole file after the retry, got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,29 @@
+package alcatraz
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// serveMetrics serves the published metrics as JSON on /debug/vars, until ctx is done.
+func (s *Server) serveMetrics(ctx context.Context) {
+	if s.Metrics == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	server := &http.Server{Addr: s.Metrics, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Infof("Metrics served on http://%s/debug/vars", s.Metrics)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Errorf("Failed to serve metrics: %v", err)
+	}
+}
@@ -0,0 +1,502 @@
+package alcatraz
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mirroring keeps copies of the current files and their metadata in several storage directories,
+// for the servers without RAID. The directories are listed in the storage path, separated like in
+// PATH. The first one keeps the index, the versions and the trash, and a copy of every current
+// file, the others keep the copies of the files placed on them. When the first directory lost its
+// index, the server restores the missing files from the mirrors and rebuilds the index, the
+// versions and the trash aren't mirrored. CheckDisks reports the lost directory.
+type Mirroring struct {
+	// Copies is the number of directories with a copy of every file, default is all of them
+	Copies int `yaml:"copies"`
+}
+
+// diskMetrics count the missing, corrupt and repaired copies by directory, since the server started.
+var diskMetrics = expvar.NewMap("disks")
+
+// DiskHealth is the state of the copies in a storage directory.
+type DiskHealth struct {
+	Dir string
+	// Lost is set for the main directory, when its index or all of its files are missing
+	Lost bool
+	// Files are the checked copies, Missing and Corrupt of them are broken and Repaired are fixed
+	Files    int
+	Missing  int
+	Corrupt  int
+	Repaired int
+}
+
+// storageDirs splits the storage path into its directories.
+func storageDirs(storagePath string) []string {
+	dirs := []string{}
+	for _, dir := range filepath.SplitList(storagePath) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// splitStorage sets the storage path of config to the first directory, and returns the others.
+func splitStorage(config *ServerConfig) ([]string, error) {
+	dirs := storageDirs(config.StoragePath)
+	if len(dirs) <= 1 {
+		if config.Mirroring.Copies > 1 {
+			return nil, fmt.Errorf("%d copies need as many storage directories", config.Mirroring.Copies)
+		}
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		clean := filepath.Clean(dir)
+		if seen[clean] {
+			return nil, fmt.Errorf("storage directory %q is listed more than once", dir)
+		}
+		seen[clean] = true
+	}
+	if config.Mirroring.Copies < 0 || config.Mirroring.Copies > len(dirs) {
+		return nil, fmt.Errorf("copies must be between 1 and the %d storage directories", len(dirs))
+	}
+
+	config.StoragePath = dirs[0]
+	return dirs[1:], nil
+}
+
+// mirrorMarker is written to the internal folder of the mirrors, so the main directory which
+// lost its index is told apart from a new storage.
+const mirrorMarker = "mirror"
+
+// checkMainDir returns an error, when the main directory has no index but a mirror was used.
+// The main directory is lost then, a new index would miss the files kept only on the mirrors.
+func checkMainDir(main string, mirrorDirs []string) error {
+	if _, err := os.Stat(filepath.Join(main, internalDir, indexFile)); !os.IsNotExist(err) {
+		return nil
+	}
+	for _, dir := range mirrorDirs {
+		if _, err := os.Stat(filepath.Join(dir, internalDir, mirrorMarker)); err == nil {
+			return fmt.Errorf("main storage directory %q is lost, it has no index but %q is its mirror", main, dir)
+		}
+	}
+	return nil
+}
+
+// restoreMainDir copies the files and the metadata missing in the lost main directory from the
+// mirrors, the newest copy of each, so the index is rebuilt from them.
+func restoreMainDir(main string, mirrorDirs []string) error {
+	err := checkMainDir(main, mirrorDirs)
+	if err == nil {
+		return nil
+	}
+	log.Warnf("%v: the missing files are restored from the mirrors, the versions and the trash aren't mirrored", err)
+
+	metadataDir := filepath.Join(internalDir, "metadata") + string(filepath.Separator)
+	newest := map[string]string{}
+	modTimes := map[string]time.Time{}
+	for _, dir := range mirrorDirs {
+		err := filepath.Walk(dir, func(fullname string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && fullname == dir {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, fullname)
+			if err != nil {
+				return err
+			}
+			// the internal folder of a mirror has only the metadata to restore
+			if strings.HasPrefix(rel, internalDir+string(filepath.Separator)) && !strings.HasPrefix(rel, metadataDir) {
+				return nil
+			}
+			if _, ok := newest[rel]; !ok || info.ModTime().After(modTimes[rel]) {
+				newest[rel], modTimes[rel] = fullname, info.ModTime()
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read mirror %q: %v", dir, err)
+		}
+	}
+
+	restored := 0
+	for rel, source := range newest {
+		target := filepath.Join(main, rel)
+		if _, err := os.Stat(target); !os.IsNotExist(err) {
+			continue
+		}
+		if err := copyFile(source, main, target, modTimes[rel]); err != nil {
+			return fmt.Errorf("failed to restore %q from %q: %v", rel, source, err)
+		}
+		if !strings.HasPrefix(rel, metadataDir) {
+			restored++
+		}
+	}
+	log.Infof("Restored %d files of %q from the mirrors", restored, main)
+	return nil
+}
+
+// markMirrors writes the marker to the mirrors, which don't have it.
+func markMirrors(mirrorDirs []string) error {
+	for _, dir := range mirrorDirs {
+		filename := filepath.Join(dir, internalDir, mirrorMarker)
+		if _, err := os.Stat(filename); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create directories: %v", err)
+		}
+		if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storageDirs returns all directories of the storage, the first one is the main.
+func (s *Server) storageDirs() []string {
+	return append([]string{s.StoragePath}, s.mirrorDirs...)
+}
+
+// mirrorsOf returns the other directories, which keep a copy of the file. The directories are
+// ranked by the hash of their path and the file, so the copies are spread evenly.
+func (s *Server) mirrorsOf(owner, name string) []string {
+	copies := s.Mirroring.Copies
+	if copies == 0 {
+		copies = len(s.mirrorDirs) + 1
+	}
+
+	score := func(dir string) uint64 {
+		h := fnv.New64a()
+		h.Write([]byte(dir + "\x00" + owner + "\x00" + name))
+		return h.Sum64()
+	}
+	dirs := append([]string{}, s.mirrorDirs...)
+	sort.Slice(dirs, func(i, j int) bool { return score(dirs[i]) > score(dirs[j]) })
+	return dirs[:copies-1]
+}
+
+// syncMirrors makes the copies match the stored file, they are written to the file's
+// directories and removed from the others. The failures are only logged, the scrubber repairs
+// the copies.
+func (s *Server) syncMirrors(owner, name string) {
+	if len(s.mirrorDirs) == 0 {
+		return
+	}
+	loc, ok := s.ownerLocation(owner)
+	if !ok {
+		return
+	}
+	loc.name = name
+
+	source := loc.path(s.StoragePath)
+	placed := map[string]bool{}
+	info, err := os.Stat(source)
+	if err == nil && !info.IsDir() {
+		for _, dir := range s.mirrorsOf(owner, name) {
+			placed[dir] = true
+		}
+	}
+
+	for _, dir := range s.mirrorDirs {
+		target := loc.path(dir)
+		if !placed[dir] {
+			for _, filename := range []string{target, metadataFile(dir, owner, name)} {
+				if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+					log.Errorf("Failed to remove the copy of %q from %q: %v", loc.prefix+name, dir, err)
+				}
+			}
+			continue
+		}
+		if err := s.syncMetadata(owner, name, dir); err != nil {
+			log.Errorf("Failed to copy the metadata of %q to %q, the scrubber repairs it: %v", loc.prefix+name, dir, err)
+			diskMetrics.Add(dir+".failed", 1)
+		}
+		if copied, err := os.Stat(target); err == nil && copied.Size() == info.Size() && copied.ModTime().Equal(info.ModTime()) {
+			continue
+		}
+		if err := copyFile(source, dir, target, info.ModTime()); err != nil {
+			log.Errorf("Failed to copy %q to %q, the scrubber repairs it: %v", loc.prefix+name, dir, err)
+			diskMetrics.Add(dir+".failed", 1)
+		}
+	}
+}
+
+// metadataFile is the metadata of the owner's file in the storage directory root.
+func metadataFile(root, owner, name string) string {
+	return filepath.Join(root, internalDir, "metadata", owner, name+".json")
+}
+
+// syncMetadata makes the copy of the file's metadata in the mirror dir match the stored one.
+func (s *Server) syncMetadata(owner, name, dir string) error {
+	source, target := s.metadataPath(owner, name), metadataFile(dir, owner, name)
+	info, err := os.Stat(source)
+	if os.IsNotExist(err) {
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	if copied, err := ioutil.ReadFile(target); err == nil && bytes.Equal(copied, data) {
+		return nil
+	}
+	return copyFile(source, dir, target, info.ModTime())
+}
+
+// copyFile copies src to dst through a temp file in the internal folder of root, with the
+// modification time of the stored file, which is its version.
+func copyFile(src, root, dst string, modTime time.Time) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dir := filepath.Join(root, internalDir, "tmp")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directories: %v", err)
+	}
+	out, err := ioutil.TempFile(dir, "copy-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(out.Name(), modTime, modTime); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directories: %v", err)
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// statCopy returns the info of the stored file, or of a copy when it's missing.
+func (s *Server) statCopy(loc location) (os.FileInfo, error) {
+	info, err := os.Stat(loc.path(s.StoragePath))
+	if !os.IsNotExist(err) {
+		return info, err
+	}
+	for _, dir := range s.mirrorsOf(loc.owner, loc.name) {
+		if info, err := os.Stat(loc.path(dir)); err == nil {
+			return info, nil
+		}
+	}
+	return info, err
+}
+
+// openCopy opens a healthy copy of the current file, the stored one or a mirror. The copies
+// which don't match the indexed size and version are skipped, the content is verified by the
+// scrubber. Without a healthy copy, the stored file is opened, and without the index record
+// the first existing copy.
+func (s *Server) openCopy(loc location) (*os.File, error) {
+	filename := loc.path(s.StoragePath)
+	if len(s.mirrorDirs) == 0 {
+		return os.Open(filename)
+	}
+	rec, indexed, err := s.index.get(loc.owner, loc.name, "")
+	if err != nil || !indexed {
+		file, err := os.Open(filename)
+		for _, dir := range s.mirrorsOf(loc.owner, loc.name) {
+			if !os.IsNotExist(err) {
+				break
+			}
+			file, err = os.Open(loc.path(dir))
+		}
+		return file, err
+	}
+
+	for _, dir := range append([]string{s.StoragePath}, s.mirrorsOf(loc.owner, loc.name)...) {
+		file, err := os.Open(loc.path(dir))
+		if err != nil {
+			continue
+		}
+		if info, err := file.Stat(); err == nil && info.Size() == rec.Size && versionID(info.ModTime()) == rec.Version {
+			if dir != s.StoragePath {
+				log.Warnf("The stored %q is missing or damaged, it's read from %q", loc.prefix+loc.name, dir)
+			}
+			return file, nil
+		}
+		file.Close()
+	}
+	return os.Open(filename)
+}
+
+// checkCopies checks the copies of the current file by size, or by hash when hashed is set,
+// and repairs the missing and corrupt ones from a healthy copy. The state of the copies is
+// added to disks. It returns false, if there is no healthy copy.
+func (s *Server) checkCopies(ctx context.Context, loc location, rec fileRecord, limiter *rateLimiter, hashed bool, disks map[string]*DiskHealth) (bool, error) {
+	// the file replaced since it was indexed is checked on the next run
+	if info, err := os.Stat(loc.path(s.StoragePath)); err == nil && versionID(info.ModTime()) != rec.Version {
+		return true, nil
+	}
+
+	healthy, broken := "", []string{}
+	for _, dir := range append([]string{s.StoragePath}, s.mirrorsOf(loc.owner, loc.name)...) {
+		if disks[dir] == nil {
+			disks[dir] = &DiskHealth{Dir: dir}
+		}
+		health := disks[dir]
+		health.Files++
+
+		filename := loc.path(dir)
+		info, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			health.Missing++
+			diskMetrics.Add(dir+".missing", 1)
+			broken = append(broken, dir)
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		ok := info.Size() == rec.Size && versionID(info.ModTime()) == rec.Version
+		if ok && hashed {
+			hash, err := hashFile(ctx, filename, limiter)
+			if err != nil {
+				return false, err
+			}
+			ok = hash == rec.Hash
+		}
+		if !ok {
+			health.Corrupt++
+			diskMetrics.Add(dir+".corrupt", 1)
+			broken = append(broken, dir)
+			continue
+		}
+		if healthy == "" {
+			healthy = dir
+		}
+	}
+	if healthy == "" {
+		return false, nil
+	}
+	for _, dir := range s.mirrorsOf(loc.owner, loc.name) {
+		if err := s.syncMetadata(loc.owner, loc.name, dir); err != nil {
+			log.Errorf("Failed to repair the metadata of %q in %q: %v", loc.prefix+loc.name, dir, err)
+		}
+	}
+
+	for _, dir := range broken {
+		if err := copyFile(loc.path(healthy), dir, loc.path(dir), rec.Uploaded); err != nil {
+			log.Errorf("Failed to repair the copy of %q in %q: %v", loc.prefix+loc.name, dir, err)
+			continue
+		}
+		log.Warnf("Repaired the copy of %q in %q from %q", loc.prefix+loc.name, dir, healthy)
+		disks[dir].Repaired++
+		diskMetrics.Add(dir+".repaired", 1)
+	}
+	return true, nil
+}
+
+// sortedDisks returns the health of the directories, in the order of the storage path.
+func (s *Server) sortedDisks(disks map[string]*DiskHealth) []DiskHealth {
+	list := []DiskHealth{}
+	for _, dir := range s.storageDirs() {
+		if health, ok := disks[dir]; ok {
+			list = append(list, *health)
+		} else {
+			list = append(list, DiskHealth{Dir: dir})
+		}
+	}
+	return list
+}
+
+// CheckDisks checks the copies of the current files of the stopped server by their size,
+// repairs the missing and damaged ones and reports the health of every storage directory.
+// The content of the copies is verified by Scrub. It returns an error, if a file has no
+// healthy copy.
+func CheckDisks(config ServerConfig) ([]DiskHealth, error) {
+	dirs := storageDirs(config.StoragePath)
+	if len(dirs) > 1 {
+		if err := checkMainDir(dirs[0], dirs[1:]); err != nil {
+			report := []DiskHealth{{Dir: dirs[0], Lost: true}}
+			for _, dir := range dirs[1:] {
+				report = append(report, DiskHealth{Dir: dir})
+			}
+			log.Error(err)
+			return report, err
+		}
+	}
+
+	s, err := openStorage(config)
+	if err != nil {
+		return nil, err
+	}
+	defer s.index.Close()
+
+	records, err := s.index.all()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %v", err)
+	}
+	disks, lost := map[string]*DiskHealth{}, 0
+	for _, rec := range records {
+		loc, ok := s.ownerLocation(rec.Owner)
+		if !rec.current || !ok {
+			continue
+		}
+		loc.name = rec.Name
+		healthy, err := s.checkCopies(context.Background(), loc, rec, nil, false, disks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %q of %q: %v", rec.Name, rec.Owner, err)
+		}
+		if !healthy {
+			log.Warnf("%q of %q has no healthy copy", rec.Name, rec.Owner)
+			lost++
+		}
+	}
+
+	report := s.sortedDisks(disks)
+	for _, health := range report {
+		log.Infof("%s: %d copies, %d missing, %d corrupt, %d repaired", health.Dir, health.Files, health.Missing, health.Corrupt, health.Repaired)
+	}
+	// the main directory without any of its files is lost, not only damaged
+	if main := &report[0]; main.Files > 0 && main.Missing == main.Files {
+		main.Lost = true
+		err := fmt.Errorf("main storage directory %q is lost, all of its %d files were missing and %d are repaired from the mirrors", main.Dir, main.Files, main.Repaired)
+		log.Error(err)
+		return report, err
+	}
+	if lost > 0 {
+		return report, fmt.Errorf("%d files have no healthy copy", lost)
+	}
+	return report, nil
+}
@@ -0,0 +1,183 @@
+package alcatraz
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMirroring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Malcolm", nil)
+
+	disks := []string{filepath.Join(dir, "disk1"), filepath.Join(dir, "disk2"), filepath.Join(dir, "disk3")}
+	config := ServerConfig{
+		StoragePath:  strings.Join(disks, string(os.PathListSeparator)),
+		Certificates: serverFiles,
+		Clients: []ClientACL{{
+			Identity:    "Malcolm",
+			Permissions: []Permission{PermissionUpload, PermissionDownload, PermissionList, PermissionDelete},
+		}},
+		Mirroring: Mirroring{Copies: 2},
+		LogLevel:  "info",
+	}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	copies := func(name string) []string {
+		found := []string{}
+		for _, disk := range disks {
+			if _, err := os.Stat(filepath.Join(disk, "Malcolm", name)); err == nil {
+				found = append(found, disk)
+			}
+		}
+		return found
+	}
+
+	names := []string{"a.txt", "b.txt", "c.txt", "d.txt"}
+	for _, name := range names {
+		if err := client.Upload(ctx, name, strings.NewReader("content of "+name), WithMetadata(map[string]string{"name": name})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range names {
+		if found := copies(name); len(found) != 2 || found[0] != disks[0] {
+			t.Errorf("expected %s on the first disk and a mirror, got %v", name, found)
+		}
+	}
+
+	if err := client.Delete(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if found := copies("a.txt"); len(found) != 0 {
+		t.Errorf("expected the deleted file to be removed from all disks, got %v", found)
+	}
+	if err := client.Move(ctx, "b.txt", "e.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if found := copies("b.txt"); len(found) != 0 || len(copies("e.txt")) != 2 {
+		t.Errorf("expected the moved file to be mirrored by its new name, got %v and %v", found, copies("e.txt"))
+	}
+	if metadata, err := readMetadataFile(metadataFile(copies("e.txt")[1], "Malcolm", "e.txt")); err != nil || metadata["name"] != "b.txt" {
+		t.Errorf("expected the metadata to be mirrored with the file, got %v, %v", metadata, err)
+	}
+
+	// the missing file is read from the mirror
+	mirror := copies("c.txt")[1]
+	if err := os.Remove(filepath.Join(disks[0], "Malcolm", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if _, err := client.Download(ctx, "c.txt", buf); err != nil || buf.String() != "content of c.txt" {
+		t.Errorf("expected the file from the mirror, got %q, %v", buf.String(), err)
+	}
+
+	// the damaged mirror keeps the size and the modification time
+	damaged := filepath.Join(copies("d.txt")[1], "Malcolm", "d.txt")
+	info, err := os.Stat(damaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(damaged, []byte("content of d.txU"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(damaged, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.scrub(ctx, nil, true)
+	if err != nil || result.Verified != 3 || result.Corrupt != 0 || result.Missing != 0 {
+		t.Errorf("expected the copies to be repaired, got %+v, %v", result, err)
+	}
+	repaired := 0
+	for _, health := range result.Disks {
+		repaired += health.Repaired
+		if health.Dir == disks[0] && (health.Files != 3 || health.Missing != 1) {
+			t.Errorf("expected the missing file on the first disk, got %+v", health)
+		}
+	}
+	if repaired != 2 {
+		t.Errorf("expected 2 repaired copies, got %+v", result.Disks)
+	}
+	for _, filename := range []string{filepath.Join(disks[0], "Malcolm", "c.txt"), damaged} {
+		if data, _ := ioutil.ReadFile(filename); !strings.HasPrefix(string(data), "content of") || strings.HasSuffix(string(data), "U") {
+			t.Errorf("expected %s to be repaired, got %q", filename, data)
+		}
+	}
+
+	// the stopped server's copies are checked by size
+	if err := os.Remove(filepath.Join(mirror, "Malcolm", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	server.index.Close()
+	report, err := CheckDisks(config)
+	if err != nil || len(report) != 3 {
+		t.Fatalf("expected a report of 3 disks, got %+v, %v", report, err)
+	}
+	for _, health := range report {
+		if health.Dir == mirror && (health.Missing != 1 || health.Repaired != 1) {
+			t.Errorf("expected the missing copy to be repaired, got %+v", health)
+		}
+	}
+	if len(copies("c.txt")) != 2 {
+		t.Errorf("expected c.txt to have 2 copies, got %v", copies("c.txt"))
+	}
+
+	// the main directory without its files is lost, they are repaired from the mirrors
+	if err := os.RemoveAll(filepath.Join(disks[0], "Malcolm")); err != nil {
+		t.Fatal(err)
+	}
+	report, err = CheckDisks(config)
+	if err == nil || len(report) != 3 || !report[0].Lost || report[0].Repaired != 3 {
+		t.Errorf("expected the main directory to be lost, got %+v, %v", report, err)
+	}
+
+	// the main directory without its index is lost, the server restores it from the mirrors
+	if err := os.RemoveAll(disks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := CheckDisks(config); err == nil || len(report) != 3 || !report[0].Lost {
+		t.Errorf("expected the main directory to be lost, got %+v, %v", report, err)
+	}
+	restored, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.index.Close()
+	for _, name := range []string{"c.txt", "d.txt", "e.txt"} {
+		if len(copies(name)) != 2 {
+			t.Errorf("expected %s to be restored, got %v", name, copies(name))
+		}
+	}
+	if metadata, err := restored.readMetadata("Malcolm", "e.txt"); err != nil || metadata["name"] != "b.txt" {
+		t.Errorf("expected the metadata to be restored, got %v, %v", metadata, err)
+	}
+	if files, err := restored.index.list("Malcolm", "", false); err != nil || len(files) != 3 {
+		t.Errorf("expected the restored files to be indexed, got %+v, %v", files, err)
+	}
+}
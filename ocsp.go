@@ -0,0 +1,320 @@
+package alcatraz
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// OCSPMode is what the server does with a client, whose status can't be checked.
+type OCSPMode string
+
+const (
+	// OCSPOff disables the checks of the client certificates
+	OCSPOff OCSPMode = ""
+	// OCSPSoftFail allows the client, if the responder is not available
+	OCSPSoftFail OCSPMode = "soft"
+	// OCSPHardFail rejects the client, if the responder is not available
+	OCSPHardFail OCSPMode = "hard"
+)
+
+// OCSP configures the certificate status checks with an OCSP responder.
+type OCSP struct {
+	Mode OCSPMode `yaml:"mode"`
+	// Responder is the responder's URL, default is the one in the certificate
+	Responder string `yaml:"responder"`
+	// Cache is how long a response without next update is used, default is 5 minutes
+	Cache   time.Duration `yaml:"cache"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Staple sends the status of the server certificate to the clients
+	Staple bool `yaml:"staple"`
+}
+
+// StapleMode is how the client checks the OCSP response stapled by the server.
+type StapleMode string
+
+const (
+	// StapleIgnore doesn't check the stapled response
+	StapleIgnore StapleMode = ""
+	// StapleVerify checks the stapled response, if there is one
+	StapleVerify StapleMode = "verify"
+	// StapleRequire rejects servers without stapled response
+	StapleRequire StapleMode = "require"
+)
+
+// ocspClient queries the responder and caches the responses, by serial number.
+type ocspClient struct {
+	OCSP
+	issuers []*x509.Certificate
+	http    *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*ocspResponse
+}
+
+type ocspResponse struct {
+	*ocsp.Response
+	raw     []byte
+	expires time.Time
+}
+
+// newOCSPClient returns nil, if OCSP is not used.
+func newOCSPClient(config OCSP, caFile string) (*ocspClient, error) {
+	switch config.Mode {
+	case OCSPOff, OCSPSoftFail, OCSPHardFail:
+	default:
+		return nil, fmt.Errorf("unknown ocsp mode %q, expected %q or %q", config.Mode, OCSPSoftFail, OCSPHardFail)
+	}
+	if config.Mode == OCSPOff && !config.Staple {
+		return nil, nil
+	}
+
+	if config.Cache <= 0 {
+		config.Cache = 5 * time.Minute
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	issuers, err := readCertificates(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ocspClient{
+		OCSP:    config,
+		issuers: issuers,
+		http:    &http.Client{Timeout: config.Timeout},
+		cache:   map[string]*ocspResponse{},
+	}, nil
+}
+
+// status returns the cached response for the certificate, or asks the responder.
+func (o *ocspClient) status(cert *x509.Certificate) (*ocspResponse, error) {
+	serial := cert.SerialNumber.String()
+
+	o.mu.Lock()
+	cached, ok := o.cache[serial]
+	o.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached, nil
+	}
+
+	resp, err := o.query(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	o.cache[serial] = resp
+	o.mu.Unlock()
+
+	return resp, nil
+}
+
+func (o *ocspClient) query(cert *x509.Certificate) (*ocspResponse, error) {
+	issuer, err := o.issuer(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	url := o.Responder
+	if url == "" {
+		if len(cert.OCSPServer) == 0 {
+			return nil, errors.New("no ocsp responder for the certificate")
+		}
+		url = cert.OCSPServer[0]
+	}
+
+	request, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ocsp request: %v", err)
+	}
+
+	httpResp, err := o.http.Post(url, "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("ocsp responder failed: %v", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ocsp responder returned %s", httpResp.Status)
+	}
+	raw, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ocsp response: %v", err)
+	}
+
+	resp, err := ocsp.ParseResponseForCert(raw, cert, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ocsp response: %v", err)
+	}
+
+	expires := time.Now().Add(o.Cache)
+	if !resp.NextUpdate.IsZero() {
+		expires = resp.NextUpdate
+	}
+
+	return &ocspResponse{Response: resp, raw: raw, expires: expires}, nil
+}
+
+// issuer finds the certificate authority, which signed cert.
+func (o *ocspClient) issuer(cert *x509.Certificate) (*x509.Certificate, error) {
+	for _, ca := range o.issuers {
+		if cert.CheckSignatureFrom(ca) == nil {
+			return ca, nil
+		}
+	}
+	return nil, errors.New("issuer of the certificate is not found")
+}
+
+func (s *Server) ocspClient() *ocspClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ocsp
+}
+
+// checkOCSP returns an error, if the client certificate is revoked, or its status is not known in hard-fail mode.
+func (s *Server) checkOCSP(cert *x509.Certificate, name, method string) error {
+	client := s.ocspClient()
+	if client == nil || client.Mode == OCSPOff {
+		return nil
+	}
+
+	resp, err := client.status(cert)
+	if err == nil && resp.Status == ocsp.Unknown {
+		err = errors.New("certificate is unknown to the ocsp responder")
+	}
+	if err != nil {
+		if client.Mode == OCSPHardFail {
+			s.audit("certificate status unknown", name, logrus.Fields{"method": method, "error": err.Error()})
+			return grpc.Errorf(codes.Unavailable, "certificate status could not be checked")
+		}
+		log.Warnf("Client [%s]: allowed without OCSP check: %v", name, err)
+		return nil
+	}
+
+	if resp.Status == ocsp.Revoked {
+		s.audit("revoked certificate rejected", name, logrus.Fields{
+			"serial":     cert.SerialNumber.String(),
+			"revoked_at": resp.RevokedAt,
+			"method":     method,
+			"source":     "ocsp",
+		})
+		return grpc.Errorf(codes.Unauthenticated, "certificate is revoked")
+	}
+
+	return nil
+}
+
+// updateStaple fetches the status of the server certificate and returns when it should be updated again.
+func (s *Server) updateStaple() (time.Duration, error) {
+	s.mu.RLock()
+	current, client := s.tls, s.ocsp
+	s.mu.RUnlock()
+	if client == nil || !client.Staple {
+		return 0, nil
+	}
+
+	resp, err := client.query(current.leaf)
+	if err != nil {
+		return time.Minute, err
+	}
+	if resp.Status != ocsp.Good {
+		return time.Minute, fmt.Errorf("server certificate status is %s", ocspStatus(resp.Status))
+	}
+
+	s.mu.Lock()
+	current.staple = resp.raw
+	s.mu.Unlock()
+
+	// update halfway to the expiry, so the clients never see an old response
+	next := time.Until(resp.expires) / 2
+	if next < time.Minute {
+		next = time.Minute
+	}
+	log.Debugf("OCSP staple updated, next update in %s", next)
+
+	return next, nil
+}
+
+// refreshStaple keeps the stapled response up to date, until ctx is done.
+func (s *Server) refreshStaple(ctx context.Context) {
+	for {
+		next, err := s.updateStaple()
+		if err != nil {
+			log.Errorf("Failed to update OCSP staple: %v", err)
+		}
+		if next <= 0 {
+			// stapling is disabled, check again in case it's enabled by reload
+			next = time.Minute
+		}
+
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// verifyStaple checks the OCSP response stapled by the server.
+func verifyStaple(mode StapleMode) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.OCSPResponse) == 0 {
+			if mode == StapleRequire {
+				return errors.New("server didn't staple ocsp response")
+			}
+			return nil
+		}
+
+		if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) < 2 {
+			return errors.New("issuer of the server certificate is unknown")
+		}
+		chain := state.VerifiedChains[0]
+
+		resp, err := ocsp.ParseResponseForCert(state.OCSPResponse, chain[0], chain[1])
+		if err != nil {
+			return fmt.Errorf("invalid stapled ocsp response: %v", err)
+		}
+		if resp.Status != ocsp.Good {
+			return fmt.Errorf("server certificate status is %s", ocspStatus(resp.Status))
+		}
+		if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+			return fmt.Errorf("stapled ocsp response expired at %s", resp.NextUpdate)
+		}
+
+		return nil
+	}
+}
+
+func validStapleMode(mode StapleMode) bool {
+	return mode == StapleIgnore || mode == StapleVerify || mode == StapleRequire
+}
+
+func ocspStatus(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,212 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb/mock"
+	"github.com/golang/mock/gomock"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// testResponder is an OCSP responder, signing with the test CA.
+type testResponder struct {
+	*httptest.Server
+	ca *testCA
+
+	mu       sync.Mutex
+	revoked  map[string]bool
+	requests int
+}
+
+func newTestResponder(t *testing.T, ca *testCA) *testResponder {
+	r := &testResponder{ca: ca, revoked: map[string]bool{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		request, err := ocsp.ParseRequest(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.mu.Lock()
+		r.requests++
+		template := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: request.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if r.revoked[request.SerialNumber.String()] {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = time.Now().Add(-time.Minute)
+		}
+		r.mu.Unlock()
+
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, template, ca.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(resp)
+	}))
+	return r
+}
+
+func (r *testResponder) revoke(cert *x509.Certificate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoked[cert.SerialNumber.String()] = true
+}
+
+func TestCheckOCSP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	responder := newTestResponder(t, ca)
+	defer responder.Close()
+
+	malcolm, _ := ca.issue(t, "Malcolm", func(c *x509.Certificate) { c.OCSPServer = []string{responder.URL} })
+	reese, _ := ca.issue(t, "Reese", func(c *x509.Certificate) { c.OCSPServer = []string{responder.URL} })
+	responder.revoke(reese)
+
+	acl, _ := newAccessList(ServerConfig{Clients: []ClientACL{
+		{Identity: "Malcolm", Permissions: allPermissions},
+		{Identity: "Reese", Permissions: allPermissions},
+	}})
+	client, err := newOCSPClient(OCSP{Mode: OCSPSoftFail}, ca.certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := Server{acl: acl, ocsp: client}
+
+	info := &grpc.StreamServerInfo{FullMethod: "/pb.Alcatraz/UploadFile"}
+	handler := func(interface{}, grpc.ServerStream) error { return nil }
+	authorize := func(cert *x509.Certificate) error {
+		stream := mock.NewMockAlcatraz_UploadFileServer(ctrl)
+		stream.EXPECT().Context().AnyTimes().Return(peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		}))
+		return server.authClient(nil, stream, info, handler)
+	}
+
+	if err := authorize(malcolm); err != nil {
+		t.Errorf("Malcolm is good, got %v", err)
+	}
+	if err := authorize(reese); grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("Reese is revoked, expected Unauthenticated, got %v", err)
+	}
+
+	// the responses are cached until the next update
+	authorize(malcolm)
+	if responder.requests != 2 {
+		t.Errorf("expected 2 requests to the responder, got %d", responder.requests)
+	}
+
+	// the responder is down, soft fail allows the client and hard fail rejects it
+	responder.Close()
+	renewed, _ := ca.issue(t, "Malcolm", func(c *x509.Certificate) { c.OCSPServer = []string{responder.URL} })
+	if err := authorize(renewed); err != nil {
+		t.Errorf("soft fail, expected success, got %v", err)
+	}
+	server.ocsp.Mode = OCSPHardFail
+	if err := authorize(renewed); grpc.Code(err) != codes.Unavailable {
+		t.Errorf("hard fail, expected Unavailable, got %v", err)
+	}
+
+	if _, err := newOCSPClient(OCSP{Mode: "strict"}, ca.certFile); err == nil {
+		t.Error("unknown mode, error should be returned")
+	}
+}
+
+func TestOCSPStaple(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	responder := newTestResponder(t, ca)
+	defer responder.Close()
+
+	serverCert, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Malcolm", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  dir,
+		Certificates: serverFiles,
+		OCSP:         OCSP{Responder: responder.URL, Staple: true},
+		LogLevel:     "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handshake := func(mode StapleMode) error {
+		certificate, _ := clientFiles.getCertificate()
+		pool, _ := clientFiles.getCertAuthPool()
+
+		serverConn, clientConn := net.Pipe()
+		defer serverConn.Close()
+		defer clientConn.Close()
+		go tls.Server(serverConn, &tls.Config{GetConfigForClient: server.tlsConfigForClient}).Handshake()
+
+		return tls.Client(clientConn, &tls.Config{
+			Certificates:     []tls.Certificate{certificate},
+			RootCAs:          pool,
+			ServerName:       "localhost",
+			VerifyConnection: verifyStaple(mode),
+		}).Handshake()
+	}
+
+	if err := handshake(StapleRequire); err == nil {
+		t.Error("nothing is stapled yet, handshake should fail")
+	}
+	if err := handshake(StapleVerify); err != nil {
+		t.Errorf("missing staple is allowed, got %v", err)
+	}
+
+	if _, err := server.updateStaple(); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if err := handshake(StapleRequire); err != nil {
+		t.Errorf("stapled good status, got %v", err)
+	}
+
+	// the revoked status is not stapled by the server, and rejected by the client
+	responder.revoke(serverCert)
+	if _, err := server.updateStaple(); err == nil {
+		t.Error("server certificate is revoked, error should be returned")
+	}
+	response, _ := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+		Status:       ocsp.Revoked,
+		SerialNumber: serverCert.SerialNumber,
+		ThisUpdate:   time.Now(),
+		RevokedAt:    time.Now(),
+	}, ca.key)
+	server.tls.staple = response
+	if err := handshake(StapleVerify); err == nil {
+		t.Error("stapled revoked status, handshake should fail")
+	}
+}
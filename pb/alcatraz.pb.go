@@ -8,6 +8,7 @@ import (
 	fmt "fmt"
 	proto "github.com/golang/protobuf/proto"
 	empty "github.com/golang/protobuf/ptypes/empty"
+	timestamp "github.com/golang/protobuf/ptypes/timestamp"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
@@ -25,11 +26,37 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+type Compression int32
+
+const (
+	Compression_NONE Compression = 0
+	Compression_GZIP Compression = 1
+)
+
+var Compression_name = map[int32]string{
+	0: "NONE",
+	1: "GZIP",
+}
+
+var Compression_value = map[string]int32{
+	"NONE": 0,
+	"GZIP": 1,
+}
+
+func (x Compression) String() string {
+	return proto.EnumName(Compression_name, int32(x))
+}
+
+func (Compression) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{0}
+}
+
 type UploadRequest struct {
 	// Types that are valid to be assigned to TestOneof:
 	//	*UploadRequest_Chunk
 	//	*UploadRequest_Name
 	//	*UploadRequest_Hash
+	//	*UploadRequest_Header
 	TestOneof            isUploadRequest_TestOneof `protobuf_oneof:"test_oneof"`
 	XXX_NoUnkeyedLiteral struct{}                  `json:"-"`
 	XXX_unrecognized     []byte                    `json:"-"`
@@ -72,56 +99,1328 @@ type UploadRequest_Chunk struct {
 type UploadRequest_Name struct {
 	Name string `protobuf:"bytes,2,opt,name=name,proto3,oneof"`
 }
-
-type UploadRequest_Hash struct {
-	Hash string `protobuf:"bytes,3,opt,name=hash,proto3,oneof"`
+
+type UploadRequest_Hash struct {
+	Hash string `protobuf:"bytes,3,opt,name=hash,proto3,oneof"`
+}
+
+type UploadRequest_Header struct {
+	Header *Header `protobuf:"bytes,4,opt,name=header,proto3,oneof"`
+}
+
+func (*UploadRequest_Chunk) isUploadRequest_TestOneof() {}
+
+func (*UploadRequest_Name) isUploadRequest_TestOneof() {}
+
+func (*UploadRequest_Hash) isUploadRequest_TestOneof() {}
+
+func (*UploadRequest_Header) isUploadRequest_TestOneof() {}
+
+func (m *UploadRequest) GetTestOneof() isUploadRequest_TestOneof {
+	if m != nil {
+		return m.TestOneof
+	}
+	return nil
+}
+
+func (m *UploadRequest) GetChunk() []byte {
+	if x, ok := m.GetTestOneof().(*UploadRequest_Chunk); ok {
+		return x.Chunk
+	}
+	return nil
+}
+
+func (m *UploadRequest) GetName() string {
+	if x, ok := m.GetTestOneof().(*UploadRequest_Name); ok {
+		return x.Name
+	}
+	return ""
+}
+
+func (m *UploadRequest) GetHash() string {
+	if x, ok := m.GetTestOneof().(*UploadRequest_Hash); ok {
+		return x.Hash
+	}
+	return ""
+}
+
+func (m *UploadRequest) GetHeader() *Header {
+	if x, ok := m.GetTestOneof().(*UploadRequest_Header); ok {
+		return x.Header
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*UploadRequest) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*UploadRequest_Chunk)(nil),
+		(*UploadRequest_Name)(nil),
+		(*UploadRequest_Hash)(nil),
+		(*UploadRequest_Header)(nil),
+	}
+}
+
+// Header is always the first message of the upload.
+type Header struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// size of the uncompressed data, -1 when unknown
+	Size     int64             `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	Metadata map[string]string `protobuf:"bytes,3,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// compression of the chunks, the hash is always of the uncompressed data
+	Compression Compression `protobuf:"varint,4,opt,name=compression,proto3,enum=pb.Compression" json:"compression,omitempty"`
+	// version of the downloaded file, it's ignored in uploads
+	Version string `protobuf:"bytes,5,opt,name=version,proto3" json:"version,omitempty"`
+	// owner and uploaded_by are sent by a primary server, replicating the file of another
+	// client or space. They require the replicate permission.
+	Owner                string   `protobuf:"bytes,6,opt,name=owner,proto3" json:"owner,omitempty"`
+	UploadedBy           string   `protobuf:"bytes,7,opt,name=uploaded_by,json=uploadedBy,proto3" json:"uploaded_by,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Header) Reset()         { *m = Header{} }
+func (m *Header) String() string { return proto.CompactTextString(m) }
+func (*Header) ProtoMessage()    {}
+func (*Header) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{1}
+}
+
+func (m *Header) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Header.Unmarshal(m, b)
+}
+func (m *Header) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Header.Marshal(b, m, deterministic)
+}
+func (m *Header) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Header.Merge(m, src)
+}
+func (m *Header) XXX_Size() int {
+	return xxx_messageInfo_Header.Size(m)
+}
+func (m *Header) XXX_DiscardUnknown() {
+	xxx_messageInfo_Header.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Header proto.InternalMessageInfo
+
+func (m *Header) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Header) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *Header) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *Header) GetCompression() Compression {
+	if m != nil {
+		return m.Compression
+	}
+	return Compression_NONE
+}
+
+func (m *Header) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *Header) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *Header) GetUploadedBy() string {
+	if m != nil {
+		return m.UploadedBy
+	}
+	return ""
+}
+
+type EnrollRequest struct {
+	Token string `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	// csr is the DER encoded certificate request
+	Csr                  []byte   `protobuf:"bytes,2,opt,name=csr,proto3" json:"csr,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EnrollRequest) Reset()         { *m = EnrollRequest{} }
+func (m *EnrollRequest) String() string { return proto.CompactTextString(m) }
+func (*EnrollRequest) ProtoMessage()    {}
+func (*EnrollRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{2}
+}
+
+func (m *EnrollRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EnrollRequest.Unmarshal(m, b)
+}
+func (m *EnrollRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EnrollRequest.Marshal(b, m, deterministic)
+}
+func (m *EnrollRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EnrollRequest.Merge(m, src)
+}
+func (m *EnrollRequest) XXX_Size() int {
+	return xxx_messageInfo_EnrollRequest.Size(m)
+}
+func (m *EnrollRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_EnrollRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EnrollRequest proto.InternalMessageInfo
+
+func (m *EnrollRequest) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+func (m *EnrollRequest) GetCsr() []byte {
+	if m != nil {
+		return m.Csr
+	}
+	return nil
+}
+
+type RenewRequest struct {
+	// csr is the DER encoded certificate request
+	Csr                  []byte   `protobuf:"bytes,1,opt,name=csr,proto3" json:"csr,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RenewRequest) Reset()         { *m = RenewRequest{} }
+func (m *RenewRequest) String() string { return proto.CompactTextString(m) }
+func (*RenewRequest) ProtoMessage()    {}
+func (*RenewRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{3}
+}
+
+func (m *RenewRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RenewRequest.Unmarshal(m, b)
+}
+func (m *RenewRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RenewRequest.Marshal(b, m, deterministic)
+}
+func (m *RenewRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RenewRequest.Merge(m, src)
+}
+func (m *RenewRequest) XXX_Size() int {
+	return xxx_messageInfo_RenewRequest.Size(m)
+}
+func (m *RenewRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RenewRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RenewRequest proto.InternalMessageInfo
+
+func (m *RenewRequest) GetCsr() []byte {
+	if m != nil {
+		return m.Csr
+	}
+	return nil
+}
+
+type Certificate struct {
+	// certificate is the PEM encoded signed certificate
+	Certificate []byte `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	// ca is the PEM encoded certificate authority
+	Ca                   []byte   `protobuf:"bytes,2,opt,name=ca,proto3" json:"ca,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Certificate) Reset()         { *m = Certificate{} }
+func (m *Certificate) String() string { return proto.CompactTextString(m) }
+func (*Certificate) ProtoMessage()    {}
+func (*Certificate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{4}
+}
+
+func (m *Certificate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Certificate.Unmarshal(m, b)
+}
+func (m *Certificate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Certificate.Marshal(b, m, deterministic)
+}
+func (m *Certificate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Certificate.Merge(m, src)
+}
+func (m *Certificate) XXX_Size() int {
+	return xxx_messageInfo_Certificate.Size(m)
+}
+func (m *Certificate) XXX_DiscardUnknown() {
+	xxx_messageInfo_Certificate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Certificate proto.InternalMessageInfo
+
+func (m *Certificate) GetCertificate() []byte {
+	if m != nil {
+		return m.Certificate
+	}
+	return nil
+}
+
+func (m *Certificate) GetCa() []byte {
+	if m != nil {
+		return m.Ca
+	}
+	return nil
+}
+
+type DownloadRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// version is empty for the current file, a RFC3339 time for the last version
+	// stored at or before it, or a version ID
+	Version              string   `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DownloadRequest) Reset()         { *m = DownloadRequest{} }
+func (m *DownloadRequest) String() string { return proto.CompactTextString(m) }
+func (*DownloadRequest) ProtoMessage()    {}
+func (*DownloadRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{5}
+}
+
+func (m *DownloadRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DownloadRequest.Unmarshal(m, b)
+}
+func (m *DownloadRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DownloadRequest.Marshal(b, m, deterministic)
+}
+func (m *DownloadRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DownloadRequest.Merge(m, src)
+}
+func (m *DownloadRequest) XXX_Size() int {
+	return xxx_messageInfo_DownloadRequest.Size(m)
+}
+func (m *DownloadRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DownloadRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DownloadRequest proto.InternalMessageInfo
+
+func (m *DownloadRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *DownloadRequest) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+type DownloadResponse struct {
+	// Types that are valid to be assigned to Content:
+	//	*DownloadResponse_Header
+	//	*DownloadResponse_Chunk
+	//	*DownloadResponse_Hash
+	Content              isDownloadResponse_Content `protobuf_oneof:"content"`
+	XXX_NoUnkeyedLiteral struct{}                   `json:"-"`
+	XXX_unrecognized     []byte                     `json:"-"`
+	XXX_sizecache        int32                      `json:"-"`
+}
+
+func (m *DownloadResponse) Reset()         { *m = DownloadResponse{} }
+func (m *DownloadResponse) String() string { return proto.CompactTextString(m) }
+func (*DownloadResponse) ProtoMessage()    {}
+func (*DownloadResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{6}
+}
+
+func (m *DownloadResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DownloadResponse.Unmarshal(m, b)
+}
+func (m *DownloadResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DownloadResponse.Marshal(b, m, deterministic)
+}
+func (m *DownloadResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DownloadResponse.Merge(m, src)
+}
+func (m *DownloadResponse) XXX_Size() int {
+	return xxx_messageInfo_DownloadResponse.Size(m)
+}
+func (m *DownloadResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DownloadResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DownloadResponse proto.InternalMessageInfo
+
+type isDownloadResponse_Content interface {
+	isDownloadResponse_Content()
+}
+
+type DownloadResponse_Header struct {
+	Header *Header `protobuf:"bytes,1,opt,name=header,proto3,oneof"`
+}
+
+type DownloadResponse_Chunk struct {
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+type DownloadResponse_Hash struct {
+	Hash string `protobuf:"bytes,3,opt,name=hash,proto3,oneof"`
+}
+
+func (*DownloadResponse_Header) isDownloadResponse_Content() {}
+
+func (*DownloadResponse_Chunk) isDownloadResponse_Content() {}
+
+func (*DownloadResponse_Hash) isDownloadResponse_Content() {}
+
+func (m *DownloadResponse) GetContent() isDownloadResponse_Content {
+	if m != nil {
+		return m.Content
+	}
+	return nil
+}
+
+func (m *DownloadResponse) GetHeader() *Header {
+	if x, ok := m.GetContent().(*DownloadResponse_Header); ok {
+		return x.Header
+	}
+	return nil
+}
+
+func (m *DownloadResponse) GetChunk() []byte {
+	if x, ok := m.GetContent().(*DownloadResponse_Chunk); ok {
+		return x.Chunk
+	}
+	return nil
+}
+
+func (m *DownloadResponse) GetHash() string {
+	if x, ok := m.GetContent().(*DownloadResponse_Hash); ok {
+		return x.Hash
+	}
+	return ""
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*DownloadResponse) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*DownloadResponse_Header)(nil),
+		(*DownloadResponse_Chunk)(nil),
+		(*DownloadResponse_Hash)(nil),
+	}
+}
+
+type ListRequest struct {
+	// prefix is the listed folder, empty is the client's own folder
+	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// versions lists the old versions of the files too
+	Versions bool `protobuf:"varint,2,opt,name=versions,proto3" json:"versions,omitempty"`
+	// owner lists the files of another client or space, it requires the replicate permission
+	Owner                string   `protobuf:"bytes,3,opt,name=owner,proto3" json:"owner,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListRequest) Reset()         { *m = ListRequest{} }
+func (m *ListRequest) String() string { return proto.CompactTextString(m) }
+func (*ListRequest) ProtoMessage()    {}
+func (*ListRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{7}
+}
+
+func (m *ListRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListRequest.Unmarshal(m, b)
+}
+func (m *ListRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListRequest.Marshal(b, m, deterministic)
+}
+func (m *ListRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListRequest.Merge(m, src)
+}
+func (m *ListRequest) XXX_Size() int {
+	return xxx_messageInfo_ListRequest.Size(m)
+}
+func (m *ListRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListRequest proto.InternalMessageInfo
+
+func (m *ListRequest) GetPrefix() string {
+	if m != nil {
+		return m.Prefix
+	}
+	return ""
+}
+
+func (m *ListRequest) GetVersions() bool {
+	if m != nil {
+		return m.Versions
+	}
+	return false
+}
+
+func (m *ListRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+type FileInfo struct {
+	Name     string               `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Size     int64                `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	Modified *timestamp.Timestamp `protobuf:"bytes,3,opt,name=modified,proto3" json:"modified,omitempty"`
+	Version  string               `protobuf:"bytes,4,opt,name=version,proto3" json:"version,omitempty"`
+	// current is set for the current version of the file
+	Current bool `protobuf:"varint,5,opt,name=current,proto3" json:"current,omitempty"`
+	// hash is the verified hash of the content, computed with the algorithm
+	Hash      string `protobuf:"bytes,6,opt,name=hash,proto3" json:"hash,omitempty"`
+	Algorithm string `protobuf:"bytes,7,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	// uploaded_by and address are of the uploading client, they are set only by StatFile
+	UploadedBy           string            `protobuf:"bytes,8,opt,name=uploaded_by,json=uploadedBy,proto3" json:"uploaded_by,omitempty"`
+	Address              string            `protobuf:"bytes,9,opt,name=address,proto3" json:"address,omitempty"`
+	Metadata             map[string]string `protobuf:"bytes,10,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *FileInfo) Reset()         { *m = FileInfo{} }
+func (m *FileInfo) String() string { return proto.CompactTextString(m) }
+func (*FileInfo) ProtoMessage()    {}
+func (*FileInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{8}
+}
+
+func (m *FileInfo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileInfo.Unmarshal(m, b)
+}
+func (m *FileInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileInfo.Marshal(b, m, deterministic)
+}
+func (m *FileInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileInfo.Merge(m, src)
+}
+func (m *FileInfo) XXX_Size() int {
+	return xxx_messageInfo_FileInfo.Size(m)
+}
+func (m *FileInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileInfo proto.InternalMessageInfo
+
+func (m *FileInfo) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *FileInfo) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *FileInfo) GetModified() *timestamp.Timestamp {
+	if m != nil {
+		return m.Modified
+	}
+	return nil
+}
+
+func (m *FileInfo) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *FileInfo) GetCurrent() bool {
+	if m != nil {
+		return m.Current
+	}
+	return false
+}
+
+func (m *FileInfo) GetHash() string {
+	if m != nil {
+		return m.Hash
+	}
+	return ""
+}
+
+func (m *FileInfo) GetAlgorithm() string {
+	if m != nil {
+		return m.Algorithm
+	}
+	return ""
+}
+
+func (m *FileInfo) GetUploadedBy() string {
+	if m != nil {
+		return m.UploadedBy
+	}
+	return ""
+}
+
+func (m *FileInfo) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+func (m *FileInfo) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+type ListResponse struct {
+	Files                []*FileInfo `protobuf:"bytes,1,rep,name=files,proto3" json:"files,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *ListResponse) Reset()         { *m = ListResponse{} }
+func (m *ListResponse) String() string { return proto.CompactTextString(m) }
+func (*ListResponse) ProtoMessage()    {}
+func (*ListResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{9}
+}
+
+func (m *ListResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListResponse.Unmarshal(m, b)
+}
+func (m *ListResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListResponse.Marshal(b, m, deterministic)
+}
+func (m *ListResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListResponse.Merge(m, src)
+}
+func (m *ListResponse) XXX_Size() int {
+	return xxx_messageInfo_ListResponse.Size(m)
+}
+func (m *ListResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListResponse proto.InternalMessageInfo
+
+func (m *ListResponse) GetFiles() []*FileInfo {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+type Space struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// role is "read" or "write"
+	Role                 string   `protobuf:"bytes,2,opt,name=role,proto3" json:"role,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Space) Reset()         { *m = Space{} }
+func (m *Space) String() string { return proto.CompactTextString(m) }
+func (*Space) ProtoMessage()    {}
+func (*Space) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{10}
+}
+
+func (m *Space) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Space.Unmarshal(m, b)
+}
+func (m *Space) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Space.Marshal(b, m, deterministic)
+}
+func (m *Space) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Space.Merge(m, src)
+}
+func (m *Space) XXX_Size() int {
+	return xxx_messageInfo_Space.Size(m)
+}
+func (m *Space) XXX_DiscardUnknown() {
+	xxx_messageInfo_Space.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Space proto.InternalMessageInfo
+
+func (m *Space) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Space) GetRole() string {
+	if m != nil {
+		return m.Role
+	}
+	return ""
+}
+
+type SpaceList struct {
+	Spaces               []*Space `protobuf:"bytes,1,rep,name=spaces,proto3" json:"spaces,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SpaceList) Reset()         { *m = SpaceList{} }
+func (m *SpaceList) String() string { return proto.CompactTextString(m) }
+func (*SpaceList) ProtoMessage()    {}
+func (*SpaceList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{11}
+}
+
+func (m *SpaceList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SpaceList.Unmarshal(m, b)
+}
+func (m *SpaceList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SpaceList.Marshal(b, m, deterministic)
+}
+func (m *SpaceList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SpaceList.Merge(m, src)
+}
+func (m *SpaceList) XXX_Size() int {
+	return xxx_messageInfo_SpaceList.Size(m)
+}
+func (m *SpaceList) XXX_DiscardUnknown() {
+	xxx_messageInfo_SpaceList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SpaceList proto.InternalMessageInfo
+
+func (m *SpaceList) GetSpaces() []*Space {
+	if m != nil {
+		return m.Spaces
+	}
+	return nil
+}
+
+type QuotaRequest struct {
+	// name is "@space" for a shared space, empty for the client's own folder
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *QuotaRequest) Reset()         { *m = QuotaRequest{} }
+func (m *QuotaRequest) String() string { return proto.CompactTextString(m) }
+func (*QuotaRequest) ProtoMessage()    {}
+func (*QuotaRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{12}
+}
+
+func (m *QuotaRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QuotaRequest.Unmarshal(m, b)
+}
+func (m *QuotaRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QuotaRequest.Marshal(b, m, deterministic)
+}
+func (m *QuotaRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QuotaRequest.Merge(m, src)
+}
+func (m *QuotaRequest) XXX_Size() int {
+	return xxx_messageInfo_QuotaRequest.Size(m)
+}
+func (m *QuotaRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_QuotaRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QuotaRequest proto.InternalMessageInfo
+
+func (m *QuotaRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type QuotaResponse struct {
+	UsedBytes int64 `protobuf:"varint,1,opt,name=used_bytes,json=usedBytes,proto3" json:"used_bytes,omitempty"`
+	UsedFiles int64 `protobuf:"varint,2,opt,name=used_files,json=usedFiles,proto3" json:"used_files,omitempty"`
+	// the limits, zero means unlimited
+	MaxBytes             int64    `protobuf:"varint,3,opt,name=max_bytes,json=maxBytes,proto3" json:"max_bytes,omitempty"`
+	MaxFiles             int64    `protobuf:"varint,4,opt,name=max_files,json=maxFiles,proto3" json:"max_files,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *QuotaResponse) Reset()         { *m = QuotaResponse{} }
+func (m *QuotaResponse) String() string { return proto.CompactTextString(m) }
+func (*QuotaResponse) ProtoMessage()    {}
+func (*QuotaResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{13}
+}
+
+func (m *QuotaResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QuotaResponse.Unmarshal(m, b)
+}
+func (m *QuotaResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QuotaResponse.Marshal(b, m, deterministic)
+}
+func (m *QuotaResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QuotaResponse.Merge(m, src)
+}
+func (m *QuotaResponse) XXX_Size() int {
+	return xxx_messageInfo_QuotaResponse.Size(m)
+}
+func (m *QuotaResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_QuotaResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QuotaResponse proto.InternalMessageInfo
+
+func (m *QuotaResponse) GetUsedBytes() int64 {
+	if m != nil {
+		return m.UsedBytes
+	}
+	return 0
+}
+
+func (m *QuotaResponse) GetUsedFiles() int64 {
+	if m != nil {
+		return m.UsedFiles
+	}
+	return 0
+}
+
+func (m *QuotaResponse) GetMaxBytes() int64 {
+	if m != nil {
+		return m.MaxBytes
+	}
+	return 0
+}
+
+func (m *QuotaResponse) GetMaxFiles() int64 {
+	if m != nil {
+		return m.MaxFiles
+	}
+	return 0
+}
+
+type HoldRequest struct {
+	// owner is the client's identity, or "@space" for a shared space
+	Owner string `protobuf:"bytes,1,opt,name=owner,proto3" json:"owner,omitempty"`
+	// name is the held file or folder, empty for the whole folder of the owner
+	Name    string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Reason  string `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	Release bool   `protobuf:"varint,4,opt,name=release,proto3" json:"release,omitempty"`
+	// list only returns the holds, without changing them
+	List                 bool     `protobuf:"varint,5,opt,name=list,proto3" json:"list,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HoldRequest) Reset()         { *m = HoldRequest{} }
+func (m *HoldRequest) String() string { return proto.CompactTextString(m) }
+func (*HoldRequest) ProtoMessage()    {}
+func (*HoldRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{14}
+}
+
+func (m *HoldRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HoldRequest.Unmarshal(m, b)
+}
+func (m *HoldRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HoldRequest.Marshal(b, m, deterministic)
+}
+func (m *HoldRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HoldRequest.Merge(m, src)
+}
+func (m *HoldRequest) XXX_Size() int {
+	return xxx_messageInfo_HoldRequest.Size(m)
+}
+func (m *HoldRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_HoldRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HoldRequest proto.InternalMessageInfo
+
+func (m *HoldRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *HoldRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *HoldRequest) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *HoldRequest) GetRelease() bool {
+	if m != nil {
+		return m.Release
+	}
+	return false
+}
+
+func (m *HoldRequest) GetList() bool {
+	if m != nil {
+		return m.List
+	}
+	return false
+}
+
+type Hold struct {
+	Owner  string `protobuf:"bytes,1,opt,name=owner,proto3" json:"owner,omitempty"`
+	Name   string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Reason string `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	// by is the identity of the admin who placed the hold
+	By                   string               `protobuf:"bytes,4,opt,name=by,proto3" json:"by,omitempty"`
+	Placed               *timestamp.Timestamp `protobuf:"bytes,5,opt,name=placed,proto3" json:"placed,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *Hold) Reset()         { *m = Hold{} }
+func (m *Hold) String() string { return proto.CompactTextString(m) }
+func (*Hold) ProtoMessage()    {}
+func (*Hold) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{15}
+}
+
+func (m *Hold) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Hold.Unmarshal(m, b)
+}
+func (m *Hold) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Hold.Marshal(b, m, deterministic)
+}
+func (m *Hold) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Hold.Merge(m, src)
+}
+func (m *Hold) XXX_Size() int {
+	return xxx_messageInfo_Hold.Size(m)
+}
+func (m *Hold) XXX_DiscardUnknown() {
+	xxx_messageInfo_Hold.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Hold proto.InternalMessageInfo
+
+func (m *Hold) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *Hold) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Hold) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *Hold) GetBy() string {
+	if m != nil {
+		return m.By
+	}
+	return ""
+}
+
+func (m *Hold) GetPlaced() *timestamp.Timestamp {
+	if m != nil {
+		return m.Placed
+	}
+	return nil
+}
+
+type HoldList struct {
+	Holds                []*Hold  `protobuf:"bytes,1,rep,name=holds,proto3" json:"holds,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HoldList) Reset()         { *m = HoldList{} }
+func (m *HoldList) String() string { return proto.CompactTextString(m) }
+func (*HoldList) ProtoMessage()    {}
+func (*HoldList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{16}
+}
+
+func (m *HoldList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HoldList.Unmarshal(m, b)
+}
+func (m *HoldList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HoldList.Marshal(b, m, deterministic)
+}
+func (m *HoldList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HoldList.Merge(m, src)
+}
+func (m *HoldList) XXX_Size() int {
+	return xxx_messageInfo_HoldList.Size(m)
+}
+func (m *HoldList) XXX_DiscardUnknown() {
+	xxx_messageInfo_HoldList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HoldList proto.InternalMessageInfo
+
+func (m *HoldList) GetHolds() []*Hold {
+	if m != nil {
+		return m.Holds
+	}
+	return nil
+}
+
+type DeleteRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// owner deletes the file of another client or space, it requires the replicate permission
+	Owner                string   `protobuf:"bytes,2,opt,name=owner,proto3" json:"owner,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteRequest) Reset()         { *m = DeleteRequest{} }
+func (m *DeleteRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteRequest) ProtoMessage()    {}
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{17}
+}
+
+func (m *DeleteRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteRequest.Unmarshal(m, b)
+}
+func (m *DeleteRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteRequest.Marshal(b, m, deterministic)
+}
+func (m *DeleteRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteRequest.Merge(m, src)
+}
+func (m *DeleteRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteRequest.Size(m)
+}
+func (m *DeleteRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteRequest proto.InternalMessageInfo
+
+func (m *DeleteRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *DeleteRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+type MoveRequest struct {
+	Source string `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	// destination must be in the same folder or space as the source
+	Destination string `protobuf:"bytes,2,opt,name=destination,proto3" json:"destination,omitempty"`
+	// owner moves the file of another client or space, it requires the replicate permission
+	Owner                string   `protobuf:"bytes,3,opt,name=owner,proto3" json:"owner,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MoveRequest) Reset()         { *m = MoveRequest{} }
+func (m *MoveRequest) String() string { return proto.CompactTextString(m) }
+func (*MoveRequest) ProtoMessage()    {}
+func (*MoveRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{18}
+}
+
+func (m *MoveRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MoveRequest.Unmarshal(m, b)
+}
+func (m *MoveRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MoveRequest.Marshal(b, m, deterministic)
+}
+func (m *MoveRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MoveRequest.Merge(m, src)
+}
+func (m *MoveRequest) XXX_Size() int {
+	return xxx_messageInfo_MoveRequest.Size(m)
+}
+func (m *MoveRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MoveRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MoveRequest proto.InternalMessageInfo
+
+func (m *MoveRequest) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *MoveRequest) GetDestination() string {
+	if m != nil {
+		return m.Destination
+	}
+	return ""
+}
+
+func (m *MoveRequest) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+type UndeleteRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// id selects the deleted file, default is the last deleted one with the name
+	Id                   string   `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UndeleteRequest) Reset()         { *m = UndeleteRequest{} }
+func (m *UndeleteRequest) String() string { return proto.CompactTextString(m) }
+func (*UndeleteRequest) ProtoMessage()    {}
+func (*UndeleteRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{19}
+}
+
+func (m *UndeleteRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UndeleteRequest.Unmarshal(m, b)
+}
+func (m *UndeleteRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UndeleteRequest.Marshal(b, m, deterministic)
+}
+func (m *UndeleteRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UndeleteRequest.Merge(m, src)
+}
+func (m *UndeleteRequest) XXX_Size() int {
+	return xxx_messageInfo_UndeleteRequest.Size(m)
+}
+func (m *UndeleteRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UndeleteRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UndeleteRequest proto.InternalMessageInfo
+
+func (m *UndeleteRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *UndeleteRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type TrashEntry struct {
+	Name    string               `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Id      string               `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Size    int64                `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	Deleted *timestamp.Timestamp `protobuf:"bytes,4,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	// by is the identity of the client who deleted the file
+	By string `protobuf:"bytes,5,opt,name=by,proto3" json:"by,omitempty"`
+	// expires is when the file is removed from the trash
+	Expires              *timestamp.Timestamp `protobuf:"bytes,6,opt,name=expires,proto3" json:"expires,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *TrashEntry) Reset()         { *m = TrashEntry{} }
+func (m *TrashEntry) String() string { return proto.CompactTextString(m) }
+func (*TrashEntry) ProtoMessage()    {}
+func (*TrashEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{20}
+}
+
+func (m *TrashEntry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TrashEntry.Unmarshal(m, b)
+}
+func (m *TrashEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TrashEntry.Marshal(b, m, deterministic)
+}
+func (m *TrashEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TrashEntry.Merge(m, src)
+}
+func (m *TrashEntry) XXX_Size() int {
+	return xxx_messageInfo_TrashEntry.Size(m)
+}
+func (m *TrashEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_TrashEntry.DiscardUnknown(m)
 }
 
-func (*UploadRequest_Chunk) isUploadRequest_TestOneof() {}
+var xxx_messageInfo_TrashEntry proto.InternalMessageInfo
 
-func (*UploadRequest_Name) isUploadRequest_TestOneof() {}
+func (m *TrashEntry) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
 
-func (*UploadRequest_Hash) isUploadRequest_TestOneof() {}
+func (m *TrashEntry) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
 
-func (m *UploadRequest) GetTestOneof() isUploadRequest_TestOneof {
+func (m *TrashEntry) GetSize() int64 {
 	if m != nil {
-		return m.TestOneof
+		return m.Size
 	}
-	return nil
+	return 0
 }
 
-func (m *UploadRequest) GetChunk() []byte {
-	if x, ok := m.GetTestOneof().(*UploadRequest_Chunk); ok {
-		return x.Chunk
+func (m *TrashEntry) GetDeleted() *timestamp.Timestamp {
+	if m != nil {
+		return m.Deleted
 	}
 	return nil
 }
 
-func (m *UploadRequest) GetName() string {
-	if x, ok := m.GetTestOneof().(*UploadRequest_Name); ok {
-		return x.Name
+func (m *TrashEntry) GetBy() string {
+	if m != nil {
+		return m.By
 	}
 	return ""
 }
 
-func (m *UploadRequest) GetHash() string {
-	if x, ok := m.GetTestOneof().(*UploadRequest_Hash); ok {
-		return x.Hash
+func (m *TrashEntry) GetExpires() *timestamp.Timestamp {
+	if m != nil {
+		return m.Expires
 	}
-	return ""
+	return nil
 }
 
-// XXX_OneofWrappers is for the internal use of the proto package.
-func (*UploadRequest) XXX_OneofWrappers() []interface{} {
-	return []interface{}{
-		(*UploadRequest_Chunk)(nil),
-		(*UploadRequest_Name)(nil),
-		(*UploadRequest_Hash)(nil),
+type TrashList struct {
+	Entries              []*TrashEntry `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *TrashList) Reset()         { *m = TrashList{} }
+func (m *TrashList) String() string { return proto.CompactTextString(m) }
+func (*TrashList) ProtoMessage()    {}
+func (*TrashList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73847c5369340d2a, []int{21}
+}
+
+func (m *TrashList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TrashList.Unmarshal(m, b)
+}
+func (m *TrashList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TrashList.Marshal(b, m, deterministic)
+}
+func (m *TrashList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TrashList.Merge(m, src)
+}
+func (m *TrashList) XXX_Size() int {
+	return xxx_messageInfo_TrashList.Size(m)
+}
+func (m *TrashList) XXX_DiscardUnknown() {
+	xxx_messageInfo_TrashList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TrashList proto.InternalMessageInfo
+
+func (m *TrashList) GetEntries() []*TrashEntry {
+	if m != nil {
+		return m.Entries
 	}
+	return nil
 }
 
 func init() {
+	proto.RegisterEnum("pb.Compression", Compression_name, Compression_value)
 	proto.RegisterType((*UploadRequest)(nil), "pb.UploadRequest")
+	proto.RegisterType((*Header)(nil), "pb.Header")
+	proto.RegisterMapType((map[string]string)(nil), "pb.Header.MetadataEntry")
+	proto.RegisterType((*EnrollRequest)(nil), "pb.EnrollRequest")
+	proto.RegisterType((*RenewRequest)(nil), "pb.RenewRequest")
+	proto.RegisterType((*Certificate)(nil), "pb.Certificate")
+	proto.RegisterType((*DownloadRequest)(nil), "pb.DownloadRequest")
+	proto.RegisterType((*DownloadResponse)(nil), "pb.DownloadResponse")
+	proto.RegisterType((*ListRequest)(nil), "pb.ListRequest")
+	proto.RegisterType((*FileInfo)(nil), "pb.FileInfo")
+	proto.RegisterMapType((map[string]string)(nil), "pb.FileInfo.MetadataEntry")
+	proto.RegisterType((*ListResponse)(nil), "pb.ListResponse")
+	proto.RegisterType((*Space)(nil), "pb.Space")
+	proto.RegisterType((*SpaceList)(nil), "pb.SpaceList")
+	proto.RegisterType((*QuotaRequest)(nil), "pb.QuotaRequest")
+	proto.RegisterType((*QuotaResponse)(nil), "pb.QuotaResponse")
+	proto.RegisterType((*HoldRequest)(nil), "pb.HoldRequest")
+	proto.RegisterType((*Hold)(nil), "pb.Hold")
+	proto.RegisterType((*HoldList)(nil), "pb.HoldList")
+	proto.RegisterType((*DeleteRequest)(nil), "pb.DeleteRequest")
+	proto.RegisterType((*MoveRequest)(nil), "pb.MoveRequest")
+	proto.RegisterType((*UndeleteRequest)(nil), "pb.UndeleteRequest")
+	proto.RegisterType((*TrashEntry)(nil), "pb.TrashEntry")
+	proto.RegisterType((*TrashList)(nil), "pb.TrashList")
 }
 
 func init() {
@@ -129,20 +1428,83 @@ func init() {
 }
 
 var fileDescriptor_73847c5369340d2a = []byte{
-	// 194 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4b, 0xcc, 0x49, 0x4e,
-	0x2c, 0x29, 0x4a, 0xac, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2a, 0x48, 0x92, 0x92,
-	0x4e, 0xcf, 0xcf, 0x4f, 0xcf, 0x49, 0xd5, 0x07, 0x8b, 0x24, 0x95, 0xa6, 0xe9, 0xa7, 0xe6, 0x16,
-	0x94, 0x54, 0x42, 0x14, 0x28, 0x25, 0x72, 0xf1, 0x86, 0x16, 0xe4, 0xe4, 0x27, 0xa6, 0x04, 0xa5,
-	0x16, 0x96, 0xa6, 0x16, 0x97, 0x08, 0x89, 0x71, 0xb1, 0x26, 0x67, 0x94, 0xe6, 0x65, 0x4b, 0x30,
-	0x2a, 0x30, 0x6a, 0xf0, 0x78, 0x30, 0x04, 0x41, 0xb8, 0x42, 0x22, 0x5c, 0x2c, 0x79, 0x89, 0xb9,
-	0xa9, 0x12, 0x4c, 0x0a, 0x8c, 0x1a, 0x9c, 0x1e, 0x0c, 0x41, 0x60, 0x1e, 0x48, 0x34, 0x23, 0xb1,
-	0x38, 0x43, 0x82, 0x19, 0x26, 0x0a, 0xe2, 0x39, 0xf1, 0x70, 0x71, 0x95, 0xa4, 0x16, 0x97, 0xc4,
-	0xe7, 0xe7, 0xa5, 0xe6, 0xa7, 0x19, 0xb9, 0x73, 0x71, 0x38, 0x42, 0x5d, 0x25, 0x64, 0xcd, 0xc5,
-	0x05, 0xb1, 0xce, 0x2d, 0x33, 0x27, 0x55, 0x48, 0x50, 0xaf, 0x20, 0x49, 0x0f, 0xc5, 0x7a, 0x29,
-	0x31, 0x3d, 0x88, 0x6b, 0xf5, 0x60, 0xae, 0xd5, 0x73, 0x05, 0xb9, 0x56, 0x89, 0x41, 0x83, 0x31,
-	0x89, 0x0d, 0x2c, 0x66, 0x0c, 0x08, 0x00, 0x00, 0xff, 0xff, 0x5e, 0x08, 0x67, 0xff, 0xe5, 0x00,
-	0x00, 0x00,
+	// 1215 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x56, 0xeb, 0x8f, 0x14, 0x45,
+	0x10, 0xdf, 0x99, 0x7d, 0xcd, 0xd4, 0x3e, 0xee, 0x68, 0x2f, 0x64, 0x32, 0xa8, 0x2c, 0x1d, 0x3f,
+	0x6c, 0x88, 0xee, 0xc1, 0x0a, 0x2a, 0x12, 0x43, 0x3c, 0x38, 0x3d, 0x12, 0x40, 0x1d, 0x20, 0x26,
+	0x26, 0x86, 0xf4, 0xce, 0xf4, 0xde, 0x4e, 0x98, 0x9d, 0x1e, 0xa6, 0x7b, 0xe1, 0x96, 0xc4, 0xc4,
+	0x6f, 0x7e, 0xf1, 0xff, 0x30, 0xf1, 0xaf, 0xf0, 0x4f, 0x33, 0xfd, 0x98, 0xc7, 0xde, 0xb1, 0x77,
+	0x24, 0xfa, 0xad, 0xeb, 0xd5, 0x55, 0x5d, 0x55, 0xbf, 0xea, 0x82, 0x21, 0x49, 0x42, 0x22, 0x72,
+	0xf2, 0x76, 0x92, 0xe5, 0x4c, 0x30, 0x64, 0x67, 0x33, 0xff, 0xca, 0x31, 0x63, 0xc7, 0x09, 0xdd,
+	0x57, 0x9c, 0xd9, 0x6a, 0xbe, 0x4f, 0x97, 0x99, 0x58, 0x6b, 0x05, 0xff, 0xea, 0x69, 0xa1, 0x88,
+	0x97, 0x94, 0x0b, 0xb2, 0xcc, 0xb4, 0x02, 0xfe, 0xc3, 0x82, 0xc1, 0xf3, 0x2c, 0x61, 0x24, 0x0a,
+	0xe8, 0xab, 0x15, 0xe5, 0x02, 0x5d, 0x86, 0x76, 0xb8, 0x58, 0xa5, 0x2f, 0x3d, 0x6b, 0x64, 0x8d,
+	0xfb, 0x47, 0x8d, 0x40, 0x93, 0x68, 0x0f, 0x5a, 0x29, 0x59, 0x52, 0xcf, 0x1e, 0x59, 0x63, 0xf7,
+	0xa8, 0x11, 0x28, 0x4a, 0x72, 0x17, 0x84, 0x2f, 0xbc, 0x66, 0xc1, 0x95, 0x14, 0xfa, 0x04, 0x3a,
+	0x0b, 0x4a, 0x22, 0x9a, 0x7b, 0xad, 0x91, 0x35, 0xee, 0x4d, 0x61, 0x92, 0xcd, 0x26, 0x47, 0x8a,
+	0x73, 0xd4, 0x08, 0x8c, 0xec, 0xa0, 0x0f, 0x20, 0x28, 0x17, 0x2f, 0x58, 0x4a, 0xd9, 0x1c, 0xff,
+	0x65, 0x43, 0x47, 0xab, 0x20, 0x64, 0x5c, 0xc9, 0x08, 0x5c, 0xe3, 0x08, 0x41, 0x8b, 0xc7, 0x6f,
+	0xb5, 0xfb, 0x66, 0xa0, 0xce, 0xe8, 0x16, 0x38, 0x4b, 0x2a, 0x48, 0x44, 0x04, 0xf1, 0x9a, 0xa3,
+	0xe6, 0xb8, 0x37, 0xf5, 0x2a, 0x47, 0x93, 0xc7, 0x46, 0x74, 0x98, 0x8a, 0x7c, 0x1d, 0x94, 0x9a,
+	0xe8, 0x26, 0xf4, 0x42, 0xb6, 0xcc, 0x72, 0xca, 0x79, 0xcc, 0x52, 0x15, 0xe1, 0x70, 0xba, 0x23,
+	0x0d, 0xef, 0x57, 0xec, 0xa0, 0xae, 0x83, 0x3c, 0xe8, 0xbe, 0xa6, 0xb9, 0x52, 0x6f, 0xab, 0x98,
+	0x0a, 0x12, 0xed, 0x41, 0x9b, 0xbd, 0x49, 0x69, 0xee, 0x75, 0x14, 0x5f, 0x13, 0xe8, 0x2a, 0xf4,
+	0x56, 0x2a, 0xa9, 0x34, 0x7a, 0x31, 0x5b, 0x7b, 0x5d, 0x25, 0x83, 0x82, 0x75, 0xb0, 0xf6, 0xef,
+	0xc2, 0x60, 0x23, 0x3c, 0xb4, 0x0b, 0xcd, 0x97, 0x74, 0x6d, 0x5e, 0x2c, 0x8f, 0xf2, 0xe6, 0xd7,
+	0x24, 0x59, 0x99, 0x84, 0x07, 0x9a, 0xf8, 0xda, 0xfe, 0xca, 0xc2, 0x5f, 0xc2, 0xe0, 0x30, 0xcd,
+	0x59, 0x92, 0x14, 0x25, 0xdb, 0x83, 0xb6, 0x60, 0x2f, 0x69, 0x6a, 0xcc, 0x35, 0x21, 0xaf, 0x0c,
+	0x79, 0xae, 0xcc, 0xfb, 0x81, 0x3c, 0xe2, 0x11, 0xf4, 0x03, 0x9a, 0xd2, 0x37, 0x85, 0x9d, 0xd1,
+	0xb0, 0x2a, 0x8d, 0x7b, 0xd0, 0xbb, 0x4f, 0x73, 0x11, 0xcf, 0xe3, 0x90, 0x08, 0x8a, 0x46, 0xd0,
+	0x0b, 0x2b, 0xd2, 0x28, 0xd6, 0x59, 0x68, 0x08, 0x76, 0x48, 0x8c, 0x0f, 0x3b, 0x24, 0xf8, 0x1e,
+	0xec, 0x3c, 0x60, 0x6f, 0xd2, 0x7a, 0x43, 0xbd, 0xab, 0x9a, 0xb5, 0x84, 0xda, 0x1b, 0x09, 0xc5,
+	0xaf, 0x60, 0xb7, 0xba, 0x80, 0x67, 0x2c, 0xe5, 0xb4, 0xd6, 0x4e, 0xd6, 0xf6, 0x76, 0xaa, 0x1a,
+	0xd7, 0x3e, 0xd3, 0xb8, 0x67, 0x5b, 0xf4, 0xc0, 0x85, 0x6e, 0xc8, 0x52, 0x41, 0x53, 0x81, 0x7f,
+	0x86, 0xde, 0xa3, 0x98, 0x8b, 0x0a, 0x00, 0x9d, 0x2c, 0xa7, 0xf3, 0xf8, 0xc4, 0x44, 0x6c, 0x28,
+	0xe4, 0x83, 0x63, 0x82, 0xe4, 0xca, 0x85, 0x13, 0x94, 0x74, 0xd5, 0x06, 0xcd, 0x5a, 0x1b, 0xe0,
+	0xdf, 0x9b, 0xe0, 0x7c, 0x17, 0x27, 0xf4, 0x61, 0x3a, 0x67, 0xef, 0xdd, 0xd4, 0x5f, 0x80, 0xb3,
+	0x64, 0x51, 0x3c, 0x8f, 0x69, 0xa4, 0x6e, 0xeb, 0x4d, 0xfd, 0x89, 0x46, 0xf1, 0xa4, 0x40, 0xf1,
+	0xe4, 0x59, 0x81, 0xe2, 0xa0, 0xd4, 0xad, 0xa7, 0xb4, 0xb5, 0xd9, 0xa3, 0x1e, 0x74, 0xc3, 0x55,
+	0x9e, 0xd3, 0x54, 0xa8, 0xee, 0x75, 0x82, 0x82, 0x94, 0xfe, 0x55, 0x6a, 0x74, 0xf3, 0xaa, 0x33,
+	0xfa, 0x10, 0x5c, 0x92, 0x1c, 0xb3, 0x3c, 0x16, 0x8b, 0xa5, 0xe9, 0xdc, 0x8a, 0x71, 0xba, 0xb3,
+	0x9d, 0xd3, 0x9d, 0x2d, 0x9d, 0x91, 0x28, 0x92, 0xc0, 0xf1, 0x5c, 0x1d, 0x86, 0x21, 0xd5, 0xc3,
+	0x0a, 0xb4, 0x82, 0x42, 0xab, 0x2f, 0xeb, 0x58, 0x24, 0x68, 0x1b, 0x5e, 0xff, 0x1b, 0x56, 0xa6,
+	0xd0, 0xd7, 0xb5, 0x35, 0xad, 0x84, 0xa1, 0x3d, 0x8f, 0x13, 0xca, 0x3d, 0x4b, 0x45, 0xd0, 0xaf,
+	0x47, 0x10, 0x68, 0x11, 0xde, 0x87, 0xf6, 0xd3, 0x8c, 0x84, 0x74, 0x5b, 0xc9, 0x72, 0x96, 0x14,
+	0x9e, 0xd4, 0x19, 0x4f, 0xc0, 0x55, 0x06, 0xd2, 0x13, 0xba, 0x06, 0x1d, 0x2e, 0x89, 0xc2, 0x85,
+	0x2b, 0x5d, 0x28, 0x71, 0x60, 0x04, 0x18, 0x43, 0xff, 0xa7, 0x15, 0x13, 0xe4, 0x1c, 0x84, 0xa8,
+	0xc1, 0x6c, 0x94, 0x4c, 0xe8, 0x1f, 0x01, 0xac, 0xb8, 0x4a, 0xbb, 0x50, 0x97, 0xcb, 0x96, 0x71,
+	0x25, 0xe7, 0x40, 0x32, 0x4a, 0xb1, 0x7e, 0x9e, 0x5d, 0x89, 0xe5, 0xf3, 0x38, 0xba, 0x02, 0xee,
+	0x92, 0x9c, 0x18, 0xe3, 0xa6, 0x92, 0x3a, 0x4b, 0x72, 0xa2, 0x6d, 0x8d, 0x50, 0x9b, 0xb6, 0x4a,
+	0xa1, 0xb2, 0xc4, 0xbf, 0x41, 0xef, 0x88, 0x25, 0x51, 0x6d, 0xd8, 0xe8, 0x56, 0xb7, 0xea, 0x13,
+	0x0f, 0xd5, 0x7f, 0x07, 0x93, 0xaa, 0xcb, 0xd0, 0xc9, 0x29, 0xe1, 0x2c, 0x35, 0xa8, 0x30, 0x94,
+	0x6c, 0x91, 0x9c, 0x26, 0x94, 0x70, 0xaa, 0x7c, 0x39, 0x41, 0x41, 0xca, 0x5b, 0x92, 0x98, 0x17,
+	0x6d, 0xaa, 0xce, 0xf8, 0x4f, 0x0b, 0x5a, 0xd2, 0xff, 0xff, 0xe0, 0x78, 0x08, 0xf6, 0x6c, 0x6d,
+	0xd0, 0x61, 0xcf, 0xd6, 0x68, 0x0a, 0x9d, 0x2c, 0x21, 0x21, 0x8d, 0x94, 0xc3, 0xf3, 0x81, 0x66,
+	0x34, 0xf1, 0x75, 0x70, 0x64, 0x34, 0xaa, 0xd4, 0x1f, 0x43, 0x7b, 0xc1, 0x92, 0xa8, 0xa8, 0xb4,
+	0xa3, 0xc6, 0x92, 0x4c, 0x95, 0x66, 0xe3, 0x3b, 0x30, 0x78, 0x40, 0x13, 0x2a, 0xe8, 0x79, 0xa3,
+	0xb0, 0x7c, 0x96, 0x5d, 0x1f, 0x1d, 0xbf, 0x42, 0xef, 0x31, 0x7b, 0x4d, 0x6b, 0x33, 0x89, 0xb3,
+	0x55, 0x1e, 0x16, 0xa6, 0x86, 0x92, 0x03, 0x3a, 0xa2, 0x5c, 0xc4, 0x29, 0x11, 0xd5, 0x2c, 0xad,
+	0xb3, 0xb6, 0x4c, 0xa6, 0xdb, 0xb0, 0xf3, 0x3c, 0x8d, 0x2e, 0x8c, 0x6d, 0x08, 0x76, 0x1c, 0x99,
+	0x5b, 0xed, 0x38, 0xc2, 0xff, 0x58, 0x00, 0xcf, 0x72, 0xc2, 0x17, 0x1a, 0x88, 0xef, 0x61, 0x52,
+	0x8e, 0xb8, 0xe6, 0xc6, 0xbf, 0xdd, 0xd5, 0xbe, 0x23, 0xaf, 0x75, 0x61, 0xe2, 0x0b, 0x55, 0x53,
+	0xbd, 0x76, 0x59, 0xbd, 0x5b, 0xd0, 0xa5, 0x27, 0x59, 0x9c, 0x53, 0xee, 0x75, 0x2e, 0xbe, 0xc5,
+	0xa8, 0xe2, 0xdb, 0xe0, 0xaa, 0x17, 0xa8, 0x02, 0x8e, 0xa1, 0x4b, 0x53, 0x91, 0xc7, 0x25, 0x58,
+	0x87, 0xb2, 0x84, 0xd5, 0x0b, 0x83, 0x42, 0x7c, 0xfd, 0x1a, 0xf4, 0x6a, 0xdb, 0x01, 0x72, 0xa0,
+	0xf5, 0xe4, 0x87, 0x27, 0x87, 0xbb, 0x0d, 0x79, 0xfa, 0xfe, 0x97, 0x87, 0x3f, 0xee, 0x5a, 0xd3,
+	0xbf, 0xdb, 0xe0, 0x7c, 0x6b, 0xf6, 0x33, 0x74, 0x17, 0x40, 0xaf, 0x55, 0x12, 0x43, 0xe8, 0x92,
+	0xbc, 0x76, 0x63, 0xcd, 0xf2, 0x2f, 0x9f, 0x09, 0xf6, 0x50, 0xee, 0x6d, 0xb8, 0x31, 0xb6, 0xd0,
+	0x37, 0xd0, 0x2f, 0xfe, 0x40, 0x65, 0xfe, 0x81, 0x34, 0x3f, 0xf5, 0xad, 0xfa, 0x7b, 0x9b, 0x4c,
+	0x3d, 0x24, 0x70, 0xe3, 0x86, 0x85, 0x6e, 0x80, 0x2b, 0x5f, 0xa7, 0x71, 0xaf, 0x16, 0x9b, 0xda,
+	0xf7, 0xe6, 0xef, 0x56, 0x8c, 0xc2, 0x06, 0xed, 0x83, 0xf3, 0x54, 0x10, 0xb1, 0xdd, 0xd9, 0xc6,
+	0x9c, 0xc4, 0x0d, 0x74, 0x13, 0x3a, 0x6a, 0xa4, 0x71, 0xb4, 0xe5, 0x1d, 0xfe, 0xa0, 0x1c, 0x7b,
+	0xd2, 0x17, 0x6e, 0xa0, 0x09, 0xb4, 0xd5, 0x3c, 0x43, 0x2a, 0x80, 0xfa, 0xfc, 0xf3, 0x2f, 0xd5,
+	0x38, 0x65, 0x4c, 0x9f, 0x82, 0xfb, 0x88, 0x1e, 0x93, 0x44, 0x61, 0x7f, 0xa7, 0x84, 0x56, 0x3d,
+	0xa0, 0x02, 0x88, 0xb8, 0x81, 0xee, 0x00, 0x68, 0xa8, 0x55, 0xf9, 0xde, 0x80, 0xde, 0xf6, 0x7c,
+	0xa3, 0xdb, 0xe0, 0x48, 0xa8, 0x29, 0x43, 0xe5, 0xa7, 0x06, 0xbc, 0x73, 0xcc, 0xf6, 0xc1, 0x29,
+	0x20, 0xa4, 0x73, 0x76, 0x0a, 0x50, 0x67, 0x72, 0xf6, 0x99, 0x2e, 0x8b, 0xea, 0xae, 0xb3, 0x65,
+	0x19, 0x94, 0x9d, 0x57, 0xe6, 0xab, 0xa3, 0xb7, 0x3c, 0xfd, 0x9a, 0x8d, 0x8d, 0xcf, 0xd7, 0xeb,
+	0x6a, 0xb5, 0x87, 0xa9, 0x7c, 0xb5, 0xd5, 0x72, 0xa7, 0xf3, 0x5b, 0xdf, 0xf3, 0xde, 0xa1, 0x3d,
+	0xeb, 0xa8, 0xf7, 0x7c, 0xfe, 0xef, 0x00, 0xba, 0x42, 0xb9, 0x2d, 0x52, 0x0c, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -158,6 +1520,31 @@ const _ = grpc.SupportPackageIsVersion6
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type AlcatrazClient interface {
 	UploadFile(ctx context.Context, opts ...grpc.CallOption) (Alcatraz_UploadFileClient, error)
+	// DownloadFile sends the header, the chunks and the hash of the file.
+	DownloadFile(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (Alcatraz_DownloadFileClient, error)
+	ListFiles(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// StatFile returns the indexed metadata of the file, the current or the selected version.
+	StatFile(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (*FileInfo, error)
+	// Spaces returns the shared spaces the client is a member of.
+	Spaces(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*SpaceList, error)
+	// Quota returns the storage used by the client, or by a shared space, and its limits.
+	Quota(ctx context.Context, in *QuotaRequest, opts ...grpc.CallOption) (*QuotaResponse, error)
+	// LegalHold places or releases a legal hold, which blocks the deletion of the files, and
+	// returns the holds of the owner. It requires the admin permission.
+	LegalHold(ctx context.Context, in *HoldRequest, opts ...grpc.CallOption) (*HoldList, error)
+	// DeleteFile moves the file to the trash, from which it's removed after the trash expiry.
+	DeleteFile(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// MoveFile renames the file, inside the client's folder or the shared space.
+	MoveFile(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Undelete restores a file from the trash, to its old name.
+	Undelete(ctx context.Context, in *UndeleteRequest, opts ...grpc.CallOption) (*FileInfo, error)
+	// ListTrash lists the deleted files under the prefix folder.
+	ListTrash(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*TrashList, error)
+	// Enroll signs the certificate request of a new client, which presents a one-time
+	// bootstrap token. It's the only RPC allowed without client certificate.
+	Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*Certificate, error)
+	// Renew signs a new certificate request for the client, with the same identity.
+	Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*Certificate, error)
 }
 
 type alcatrazClient struct {
@@ -202,9 +1589,165 @@ func (x *alcatrazUploadFileClient) CloseAndRecv() (*empty.Empty, error) {
 	return m, nil
 }
 
+func (c *alcatrazClient) DownloadFile(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (Alcatraz_DownloadFileClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Alcatraz_serviceDesc.Streams[1], "/pb.Alcatraz/DownloadFile", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &alcatrazDownloadFileClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Alcatraz_DownloadFileClient interface {
+	Recv() (*DownloadResponse, error)
+	grpc.ClientStream
+}
+
+type alcatrazDownloadFileClient struct {
+	grpc.ClientStream
+}
+
+func (x *alcatrazDownloadFileClient) Recv() (*DownloadResponse, error) {
+	m := new(DownloadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *alcatrazClient) ListFiles(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/ListFiles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) StatFile(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (*FileInfo, error) {
+	out := new(FileInfo)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/StatFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) Spaces(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*SpaceList, error) {
+	out := new(SpaceList)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/Spaces", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) Quota(ctx context.Context, in *QuotaRequest, opts ...grpc.CallOption) (*QuotaResponse, error) {
+	out := new(QuotaResponse)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/Quota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) LegalHold(ctx context.Context, in *HoldRequest, opts ...grpc.CallOption) (*HoldList, error) {
+	out := new(HoldList)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/LegalHold", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) DeleteFile(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/DeleteFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) MoveFile(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/MoveFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) Undelete(ctx context.Context, in *UndeleteRequest, opts ...grpc.CallOption) (*FileInfo, error) {
+	out := new(FileInfo)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/Undelete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) ListTrash(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*TrashList, error) {
+	out := new(TrashList)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/ListTrash", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*Certificate, error) {
+	out := new(Certificate)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/Enroll", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *alcatrazClient) Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*Certificate, error) {
+	out := new(Certificate)
+	err := c.cc.Invoke(ctx, "/pb.Alcatraz/Renew", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // AlcatrazServer is the server API for Alcatraz service.
 type AlcatrazServer interface {
 	UploadFile(Alcatraz_UploadFileServer) error
+	// DownloadFile sends the header, the chunks and the hash of the file.
+	DownloadFile(*DownloadRequest, Alcatraz_DownloadFileServer) error
+	ListFiles(context.Context, *ListRequest) (*ListResponse, error)
+	// StatFile returns the indexed metadata of the file, the current or the selected version.
+	StatFile(context.Context, *DownloadRequest) (*FileInfo, error)
+	// Spaces returns the shared spaces the client is a member of.
+	Spaces(context.Context, *empty.Empty) (*SpaceList, error)
+	// Quota returns the storage used by the client, or by a shared space, and its limits.
+	Quota(context.Context, *QuotaRequest) (*QuotaResponse, error)
+	// LegalHold places or releases a legal hold, which blocks the deletion of the files, and
+	// returns the holds of the owner. It requires the admin permission.
+	LegalHold(context.Context, *HoldRequest) (*HoldList, error)
+	// DeleteFile moves the file to the trash, from which it's removed after the trash expiry.
+	DeleteFile(context.Context, *DeleteRequest) (*empty.Empty, error)
+	// MoveFile renames the file, inside the client's folder or the shared space.
+	MoveFile(context.Context, *MoveRequest) (*empty.Empty, error)
+	// Undelete restores a file from the trash, to its old name.
+	Undelete(context.Context, *UndeleteRequest) (*FileInfo, error)
+	// ListTrash lists the deleted files under the prefix folder.
+	ListTrash(context.Context, *ListRequest) (*TrashList, error)
+	// Enroll signs the certificate request of a new client, which presents a one-time
+	// bootstrap token. It's the only RPC allowed without client certificate.
+	Enroll(context.Context, *EnrollRequest) (*Certificate, error)
+	// Renew signs a new certificate request for the client, with the same identity.
+	Renew(context.Context, *RenewRequest) (*Certificate, error)
 }
 
 // UnimplementedAlcatrazServer can be embedded to have forward compatible implementations.
@@ -214,6 +1757,42 @@ type UnimplementedAlcatrazServer struct {
 func (*UnimplementedAlcatrazServer) UploadFile(srv Alcatraz_UploadFileServer) error {
 	return status.Errorf(codes.Unimplemented, "method UploadFile not implemented")
 }
+func (*UnimplementedAlcatrazServer) DownloadFile(req *DownloadRequest, srv Alcatraz_DownloadFileServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadFile not implemented")
+}
+func (*UnimplementedAlcatrazServer) ListFiles(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFiles not implemented")
+}
+func (*UnimplementedAlcatrazServer) StatFile(ctx context.Context, req *DownloadRequest) (*FileInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StatFile not implemented")
+}
+func (*UnimplementedAlcatrazServer) Spaces(ctx context.Context, req *empty.Empty) (*SpaceList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Spaces not implemented")
+}
+func (*UnimplementedAlcatrazServer) Quota(ctx context.Context, req *QuotaRequest) (*QuotaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Quota not implemented")
+}
+func (*UnimplementedAlcatrazServer) LegalHold(ctx context.Context, req *HoldRequest) (*HoldList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LegalHold not implemented")
+}
+func (*UnimplementedAlcatrazServer) DeleteFile(ctx context.Context, req *DeleteRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (*UnimplementedAlcatrazServer) MoveFile(ctx context.Context, req *MoveRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveFile not implemented")
+}
+func (*UnimplementedAlcatrazServer) Undelete(ctx context.Context, req *UndeleteRequest) (*FileInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Undelete not implemented")
+}
+func (*UnimplementedAlcatrazServer) ListTrash(ctx context.Context, req *ListRequest) (*TrashList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTrash not implemented")
+}
+func (*UnimplementedAlcatrazServer) Enroll(ctx context.Context, req *EnrollRequest) (*Certificate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Enroll not implemented")
+}
+func (*UnimplementedAlcatrazServer) Renew(ctx context.Context, req *RenewRequest) (*Certificate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Renew not implemented")
+}
 
 func RegisterAlcatrazServer(s *grpc.Server, srv AlcatrazServer) {
 	s.RegisterService(&_Alcatraz_serviceDesc, srv)
@@ -245,16 +1824,285 @@ func (x *alcatrazUploadFileServer) Recv() (*UploadRequest, error) {
 	return m, nil
 }
 
+func _Alcatraz_DownloadFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AlcatrazServer).DownloadFile(m, &alcatrazDownloadFileServer{stream})
+}
+
+type Alcatraz_DownloadFileServer interface {
+	Send(*DownloadResponse) error
+	grpc.ServerStream
+}
+
+type alcatrazDownloadFileServer struct {
+	grpc.ServerStream
+}
+
+func (x *alcatrazDownloadFileServer) Send(m *DownloadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Alcatraz_ListFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).ListFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/ListFiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).ListFiles(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_StatFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DownloadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).StatFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/StatFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).StatFile(ctx, req.(*DownloadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_Spaces_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).Spaces(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/Spaces",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).Spaces(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_Quota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QuotaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).Quota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/Quota",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).Quota(ctx, req.(*QuotaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_LegalHold_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HoldRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).LegalHold(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/LegalHold",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).LegalHold(ctx, req.(*HoldRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/DeleteFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).DeleteFile(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_MoveFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).MoveFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/MoveFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).MoveFile(ctx, req.(*MoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_Undelete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UndeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).Undelete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/Undelete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).Undelete(ctx, req.(*UndeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_ListTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).ListTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/ListTrash",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).ListTrash(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_Enroll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).Enroll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/Enroll",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).Enroll(ctx, req.(*EnrollRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Alcatraz_Renew_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlcatrazServer).Renew(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Alcatraz/Renew",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlcatrazServer).Renew(ctx, req.(*RenewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Alcatraz_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pb.Alcatraz",
 	HandlerType: (*AlcatrazServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListFiles",
+			Handler:    _Alcatraz_ListFiles_Handler,
+		},
+		{
+			MethodName: "StatFile",
+			Handler:    _Alcatraz_StatFile_Handler,
+		},
+		{
+			MethodName: "Spaces",
+			Handler:    _Alcatraz_Spaces_Handler,
+		},
+		{
+			MethodName: "Quota",
+			Handler:    _Alcatraz_Quota_Handler,
+		},
+		{
+			MethodName: "LegalHold",
+			Handler:    _Alcatraz_LegalHold_Handler,
+		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _Alcatraz_DeleteFile_Handler,
+		},
+		{
+			MethodName: "MoveFile",
+			Handler:    _Alcatraz_MoveFile_Handler,
+		},
+		{
+			MethodName: "Undelete",
+			Handler:    _Alcatraz_Undelete_Handler,
+		},
+		{
+			MethodName: "ListTrash",
+			Handler:    _Alcatraz_ListTrash_Handler,
+		},
+		{
+			MethodName: "Enroll",
+			Handler:    _Alcatraz_Enroll_Handler,
+		},
+		{
+			MethodName: "Renew",
+			Handler:    _Alcatraz_Renew_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "UploadFile",
 			Handler:       _Alcatraz_UploadFile_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "DownloadFile",
+			Handler:       _Alcatraz_DownloadFile_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "alcatraz.proto",
 }
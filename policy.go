@@ -0,0 +1,250 @@
+package alcatraz
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Effect is the decision of a matching policy rule.
+type Effect string
+
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+// PolicyRule matches requests by identity, RPC method and target path. Empty lists match everything.
+type PolicyRule struct {
+	// Effect is allow(default) or deny
+	Effect Effect `yaml:"effect"`
+	// Identities are glob patterns, like "Reese" or "spiffe://example.org/*"
+	Identities []string `yaml:"identities"`
+	// Methods are RPC names, like "UploadFile", or "*"
+	Methods []string `yaml:"methods"`
+	// Paths are patterns in gitignore syntax, like "reports/**", relative to the client's
+	// folder, or "@space/**" for a shared space. Rules with paths don't match requests without path.
+	Paths []string `yaml:"paths"`
+}
+
+// Policy is a fine-grained authorization on top of the client permissions. When it has rules,
+// a request is allowed only if an allow rule matches and no deny rule does.
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+const methodPrefix = "/pb.Alcatraz/"
+
+// pathMethods are the streaming RPCs with path in their first message. They are decided when
+// it's received, the other streams are decided before the handler.
+var pathMethods = map[string]bool{
+	"/pb.Alcatraz/UploadFile":   true,
+	"/pb.Alcatraz/DownloadFile": true,
+}
+
+type policyRule struct {
+	PolicyRule
+	paths []rule
+}
+
+// policy is the compiled Policy, nil allows everything.
+type policy struct {
+	rules []policyRule
+}
+
+func newPolicy(config Policy) (*policy, error) {
+	if len(config.Rules) == 0 {
+		return nil, nil
+	}
+
+	p := &policy{}
+	for i, r := range config.Rules {
+		compiled := policyRule{PolicyRule: r}
+
+		switch r.Effect {
+		case "":
+			compiled.Effect = EffectAllow
+		case EffectAllow, EffectDeny:
+		default:
+			return nil, fmt.Errorf("rules[%d]: unknown effect %q, expected %q or %q", i, r.Effect, EffectAllow, EffectDeny)
+		}
+
+		for _, identity := range r.Identities {
+			if _, err := path.Match(identity, ""); err != nil {
+				return nil, fmt.Errorf("rules[%d]: bad identity pattern %q: %v", i, identity, err)
+			}
+		}
+
+		for _, method := range r.Methods {
+			if _, ok := methodPermissions[methodPrefix+method]; !ok && method != "*" {
+				return nil, fmt.Errorf("rules[%d]: unknown method %q", i, method)
+			}
+		}
+
+		for _, pattern := range r.Paths {
+			if strings.HasPrefix(pattern, "!") {
+				return nil, fmt.Errorf("rules[%d]: negated path %q is not supported, use a deny rule", i, pattern)
+			}
+			parsed, ok, err := parseRule(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rules[%d]: %v", i, err)
+			}
+			if ok {
+				compiled.paths = append(compiled.paths, parsed)
+			}
+		}
+
+		p.rules = append(p.rules, compiled)
+	}
+
+	return p, nil
+}
+
+// decide returns if the request is allowed and the index of the deciding rule, -1 when no rule matched.
+func (p *policy) decide(identity, method, target string, hasPath bool) (bool, int) {
+	if p == nil {
+		return true, -1
+	}
+
+	allowed, decidedBy := false, -1
+	for i, r := range p.rules {
+		if !r.match(identity, method, target, hasPath) {
+			continue
+		}
+		if r.Effect == EffectDeny {
+			return false, i
+		}
+		if !allowed {
+			allowed, decidedBy = true, i
+		}
+	}
+	return allowed, decidedBy
+}
+
+func (r policyRule) match(identity, method, target string, hasPath bool) bool {
+	if !matchAny(r.Identities, func(p string) bool { ok, _ := path.Match(p, identity); return ok }) {
+		return false
+	}
+
+	method = strings.TrimPrefix(method, methodPrefix)
+	if !matchAny(r.Methods, func(p string) bool { return p == "*" || p == method }) {
+		return false
+	}
+
+	if len(r.Paths) == 0 {
+		return true
+	}
+	if !hasPath {
+		return false
+	}
+	for _, p := range r.paths {
+		if p.match(target, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports if any of the patterns matches, empty list matches everything.
+func matchAny(patterns []string, match func(string) bool) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) accessPolicy() *policy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policy
+}
+
+// checkPolicy logs the decision and returns PermissionDenied, if the request is not allowed.
+func (s *Server) checkPolicy(identity, method, target string, hasPath bool) error {
+	p := s.accessPolicy()
+	if p == nil {
+		return nil
+	}
+
+	allowed, rule := p.decide(identity, method, target, hasPath)
+	fields := logrus.Fields{"method": method, "rule": rule}
+	if hasPath {
+		fields["path"] = target
+	}
+
+	if !allowed {
+		s.audit("policy denied", identity, fields)
+		if hasPath {
+			return grpc.Errorf(codes.PermissionDenied, "%s of %q is not allowed", strings.TrimPrefix(method, methodPrefix), target)
+		}
+		return grpc.Errorf(codes.PermissionDenied, "%s is not allowed", method)
+	}
+
+	log.WithFields(fields).WithField("identity", identity).Debug("Policy allowed request")
+	return nil
+}
+
+// requestPath returns the target path of the request, if it has one.
+func requestPath(req interface{}) (string, bool) {
+	switch r := req.(type) {
+	case *pb.UploadRequest:
+		if header := r.GetHeader(); header != nil {
+			return cleanName(header.GetName()), true
+		}
+		if _, ok := r.GetTestOneof().(*pb.UploadRequest_Name); ok {
+			return cleanName(r.GetName()), true
+		}
+	case *pb.DownloadRequest:
+		return cleanName(r.GetName()), true
+	case *pb.ListRequest:
+		if prefix := cleanName(r.GetPrefix()); prefix != "" {
+			return prefix, true
+		}
+	case *pb.DeleteRequest:
+		return cleanName(r.GetName()), true
+	case *pb.MoveRequest:
+		// the destination is checked by the handler
+		return cleanName(r.GetSource()), true
+	case *pb.UndeleteRequest:
+		return cleanName(r.GetName()), true
+	}
+	return "", false
+}
+
+// cleanName makes the name relative and removes "..", so it can't escape the client's folder.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// policyStream checks the policy when the first message, with the path, is received.
+type policyStream struct {
+	grpc.ServerStream
+	server   *Server
+	identity string
+	method   string
+	checked  bool
+}
+
+func (s *policyStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.checked {
+		return nil
+	}
+
+	s.checked = true
+	target, hasPath := requestPath(m)
+	return s.server.checkPolicy(s.identity, s.method, target, hasPath)
+}
@@ -0,0 +1,118 @@
+package alcatraz
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPolicyDecide(t *testing.T) {
+	p, err := newPolicy(Policy{Rules: []PolicyRule{
+		{Identities: []string{"Reese"}, Methods: []string{"UploadFile"}, Paths: []string{"reports/**"}},
+		{Identities: []string{"spiffe://example.org/*"}, Methods: []string{"*"}},
+		{Effect: EffectDeny, Paths: []string{"*.exe"}},
+		{Identities: []string{"Malcolm"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		identity, method, path string
+		hasPath                bool
+		allowed                bool
+		rule                   int
+	}{
+		{"Reese", "/pb.Alcatraz/UploadFile", "reports/2020/q1.pdf", true, true, 0},
+		{"Reese", "/pb.Alcatraz/UploadFile", "shared/q1.pdf", true, false, -1},
+		{"Reese", "/pb.Alcatraz/Renew", "", false, false, -1},
+		{"Reese", "/pb.Alcatraz/UploadFile", "reports/setup.exe", true, false, 2},
+		{"spiffe://example.org/backup", "/pb.Alcatraz/Renew", "", false, true, 1},
+		{"spiffe://example.org/backup/db", "/pb.Alcatraz/Renew", "", false, false, -1},
+		{"Malcolm", "/pb.Alcatraz/UploadFile", "anything/at/all.txt", true, true, 3},
+		{"Malcolm", "/pb.Alcatraz/UploadFile", "tools/setup.exe", true, false, 2},
+		{"Dewey", "/pb.Alcatraz/UploadFile", "reports/q1.pdf", true, false, -1},
+	}
+
+	for _, test := range tests {
+		allowed, rule := p.decide(test.identity, test.method, test.path, test.hasPath)
+		if allowed != test.allowed || rule != test.rule {
+			t.Errorf("%s %s %q: expected %v by rule %d, got %v by %d", test.identity, test.method, test.path,
+				test.allowed, test.rule, allowed, rule)
+		}
+	}
+
+	if allowed, _ := (*policy)(nil).decide("Dewey", "/pb.Alcatraz/UploadFile", "", false); !allowed {
+		t.Error("without policy everything is allowed")
+	}
+
+	invalid := []struct {
+		rule PolicyRule
+		err  string
+	}{
+		{PolicyRule{Effect: "maybe"}, "unknown effect"},
+		{PolicyRule{Methods: []string{"Upload"}}, `unknown method "Upload"`},
+		{PolicyRule{Identities: []string{"[Reese"}}, "bad identity pattern"},
+		{PolicyRule{Paths: []string{"!reports/**"}}, "use a deny rule"},
+	}
+	for _, test := range invalid {
+		_, err := newPolicy(Policy{Rules: []PolicyRule{test.rule}})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error with %q, got %v", test.err, err)
+		}
+	}
+}
+
+func TestPolicyUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Reese", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients:      []ClientACL{{Identity: "Reese", Permissions: []Permission{PermissionUpload}}},
+		Policy: Policy{Rules: []PolicyRule{
+			{Identities: []string{"Reese"}, Methods: []string{"UploadFile"}, Paths: []string{"reports/**"}},
+		}},
+		LogLevel: "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Upload(context.Background(), "reports/q1.txt", strings.NewReader("q1")); err != nil {
+		t.Errorf("upload to reports should be allowed, got %v", err)
+	}
+
+	err = client.Upload(context.Background(), "shared/q1.txt", strings.NewReader("q1"))
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("upload to shared should be denied, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "storage", "Reese", "shared")); !os.IsNotExist(err) {
+		t.Error("denied upload should not create anything")
+	}
+}
@@ -0,0 +1,342 @@
+package alcatraz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/avalchev94/alcatraz/pb"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// usageFile is the usage index in the internal folder.
+const usageFile = "usage.json"
+
+// usage is the storage used by a client or a shared space. The bytes count the current files
+// and their versions, the files only the current ones.
+type usage struct {
+	// Storage is the counted folder, the usage is recounted when it changes
+	Storage string `json:"storage"`
+	Bytes   int64  `json:"bytes"`
+	Files   int64  `json:"files"`
+	// Versions is set when the versions are counted, the older usage is recounted
+	Versions bool `json:"versions"`
+}
+
+// usageIndex tracks the used storage by owner, the client's identity or "@space". Owners
+// missing in the index are counted from the metadata index, or by scanning their folder.
+type usageIndex struct {
+	filename    string
+	storagePath string
+	index       *metaIndex
+
+	mu    sync.Mutex
+	usage map[string]*usage
+	// pending is reserved by the running uploads
+	pending map[string]*usage
+}
+
+func loadUsageIndex(storagePath string) (*usageIndex, error) {
+	idx := &usageIndex{
+		filename:    filepath.Join(storagePath, internalDir, usageFile),
+		storagePath: storagePath,
+		usage:       map[string]*usage{},
+		pending:     map[string]*usage{},
+	}
+
+	data, err := ioutil.ReadFile(idx.filename)
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read usage index: %v", err)
+	}
+	if err := json.Unmarshal(data, &idx.usage); err != nil {
+		return nil, fmt.Errorf("failed to parse usage index %q: %v", idx.filename, err)
+	}
+	return idx, nil
+}
+
+// get returns the usage of the owner, counting it if needed. mu must be held.
+func (idx *usageIndex) get(owner, storage string) (*usage, error) {
+	if idx.pending[owner] == nil {
+		idx.pending[owner] = &usage{}
+	}
+	if u, ok := idx.usage[owner]; ok && u.Storage == storage && (u.Versions || idx.index == nil) {
+		return u, nil
+	}
+
+	u := &usage{Storage: storage}
+	if idx.index != nil {
+		u.Versions = true
+		var err error
+		if u.Bytes, u.Files, err = idx.index.usage(owner); err != nil {
+			return nil, fmt.Errorf("failed to count usage of %q: %v", owner, err)
+		}
+		idx.usage[owner] = u
+		return u, idx.save()
+	}
+
+	err := filepath.Walk(filepath.Join(idx.storagePath, storage), func(_ string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			u.Bytes += info.Size()
+			u.Files++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count usage of %q: %v", owner, err)
+	}
+
+	idx.usage[owner] = u
+	return u, idx.save()
+}
+
+// save writes the index to a temporary file, which replaces the old one. mu must be held.
+func (idx *usageIndex) save() error {
+	data, err := json.Marshal(idx.usage)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.filename), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directories: %v", err)
+	}
+	if err := ioutil.WriteFile(idx.filename+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write usage index: %v", err)
+	}
+	return os.Rename(idx.filename+".tmp", idx.filename)
+}
+
+// reservation is the storage taken by a running upload.
+type reservation struct {
+	idx   *usageIndex
+	loc   location
+	quota Quota
+	// old is the size of the replaced file, -1 for a new file
+	old int64
+	// keepsOld is set when the replaced file is kept as a version, its bytes stay used
+	keepsOld bool
+	bytes    int64
+	// counted is set when the new file is in the pending files
+	counted bool
+}
+
+// reserve starts an upload to loc, which replaces a file with size old, -1 for a new file, and
+// keeps it as a version when keepsOld is set. The file count is checked here and the size by grow.
+func (idx *usageIndex) reserve(loc location, old int64, keepsOld bool) (*reservation, error) {
+	r := &reservation{idx: idx, loc: loc, quota: loc.quota, old: old, keepsOld: keepsOld && old >= 0}
+	if idx == nil {
+		return r, nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	u, err := idx.get(loc.owner, loc.storage)
+	if err != nil {
+		log.Error(err)
+		return nil, grpc.Errorf(codes.Internal, "failed to check quota")
+	}
+	pending := idx.pending[loc.owner]
+	if old < 0 && r.quota.MaxFiles > 0 && u.Files+pending.Files >= r.quota.MaxFiles {
+		return nil, grpc.Errorf(codes.ResourceExhausted, "quota of %d files exceeded, %s remaining",
+			r.quota.MaxFiles, r.remaining(u, pending))
+	}
+
+	if old < 0 {
+		pending.Files++
+		r.counted = true
+	}
+	return r, nil
+}
+
+// grow reserves total bytes for the upload, it fails with ResourceExhausted over the quota.
+func (r *reservation) grow(total int64) error {
+	if r.idx == nil || total <= r.bytes {
+		return nil
+	}
+
+	r.idx.mu.Lock()
+	defer r.idx.mu.Unlock()
+
+	u, err := r.idx.get(r.loc.owner, r.loc.storage)
+	if err != nil {
+		log.Error(err)
+		return grpc.Errorf(codes.Internal, "failed to check quota")
+	}
+	pending := r.idx.pending[r.loc.owner]
+	if r.quota.MaxBytes > 0 && total > r.allowance(u, pending) {
+		return grpc.Errorf(codes.ResourceExhausted, "quota of %d bytes exceeded, %s remaining",
+			r.quota.MaxBytes, r.remaining(u, pending))
+	}
+
+	pending.Bytes += total - r.bytes
+	r.bytes = total
+	return nil
+}
+
+// allowance is how many bytes the upload could store, including the replaced file, unless
+// it's kept as a version.
+func (r *reservation) allowance(u, pending *usage) int64 {
+	allowance := r.quota.MaxBytes - u.Bytes - pending.Bytes + r.bytes
+	if r.old > 0 && !r.keepsOld {
+		allowance += r.old
+	}
+	if allowance < 0 {
+		return 0
+	}
+	return allowance
+}
+
+func (r *reservation) remaining(u, pending *usage) string {
+	bytes, files := "unlimited bytes", "unlimited files"
+	if r.quota.MaxBytes > 0 {
+		bytes = fmt.Sprintf("%d bytes", r.allowance(u, pending))
+	}
+	if r.quota.MaxFiles > 0 {
+		left := r.quota.MaxFiles - u.Files - pending.Files
+		if r.counted {
+			left++
+		}
+		if left < 0 {
+			left = 0
+		}
+		files = fmt.Sprintf("%d files", left)
+	}
+	return bytes + " and " + files
+}
+
+// commit adds the stored file of the given size to the usage.
+func (r *reservation) commit(size int64) {
+	r.finish(&size)
+}
+
+// release frees the reservation of a failed upload, the replaced file is left as it was.
+func (r *reservation) release() {
+	r.finish(nil)
+}
+
+// finish frees the reservation, and replaces the old file with the stored one of the given size,
+// when stored is set.
+func (r *reservation) finish(stored *int64) {
+	if r.idx == nil {
+		return
+	}
+
+	r.idx.mu.Lock()
+	defer r.idx.mu.Unlock()
+
+	pending := r.idx.pending[r.loc.owner]
+	pending.Bytes -= r.bytes
+	if r.counted {
+		pending.Files--
+	}
+	if stored == nil {
+		return
+	}
+
+	u, err := r.idx.get(r.loc.owner, r.loc.storage)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if r.old >= 0 {
+		if !r.keepsOld {
+			u.Bytes -= r.old
+		}
+		u.Files--
+	}
+	u.Bytes += *stored
+	u.Files++
+
+	if err := r.idx.save(); err != nil {
+		log.Errorf("Failed to save usage index: %v", err)
+	}
+}
+
+// add changes the usage of the owner by the deleted or restored files, and the removed versions.
+func (idx *usageIndex) add(loc location, bytes, files int64) {
+	if idx == nil {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	u, err := idx.get(loc.owner, loc.storage)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	u.Bytes += bytes
+	u.Files += files
+
+	if err := idx.save(); err != nil {
+		log.Errorf("Failed to save usage index: %v", err)
+	}
+}
+
+// Quota returns the usage and the quota of the client's folder, or of a shared space.
+func (s *Server) Quota(ctx context.Context, req *pb.QuotaRequest) (*pb.QuotaResponse, error) {
+	client := s.identityFromCtx(ctx)
+
+	loc, err := s.locate(client, cleanName(req.GetName()), false)
+	if err != nil {
+		return nil, err
+	}
+	if s.usage == nil {
+		return nil, grpc.Errorf(codes.Unimplemented, "usage is not tracked")
+	}
+
+	s.usage.mu.Lock()
+	u, err := s.usage.get(loc.owner, loc.storage)
+	s.usage.mu.Unlock()
+	if err != nil {
+		log.Error(err)
+		return nil, grpc.Errorf(codes.Internal, "failed to get usage")
+	}
+
+	return &pb.QuotaResponse{
+		UsedBytes: u.Bytes,
+		UsedFiles: u.Files,
+		MaxBytes:  loc.quota.MaxBytes,
+		MaxFiles:  loc.quota.MaxFiles,
+	}, nil
+}
+
+// QuotaUsage is the storage used by the client, or by a shared space, and its limits.
+type QuotaUsage struct {
+	UsedBytes int64
+	UsedFiles int64
+	// Quota is zero when unlimited
+	Quota Quota
+}
+
+// Quota returns the storage used by the client and its quota. name is "@space" for a
+// shared space, empty for the client's own folder.
+func (c *Client) Quota(ctx context.Context, name string) (QuotaUsage, error) {
+	if c.cli == nil {
+		return QuotaUsage{}, ErrNotConnected
+	}
+
+	resp, err := c.cli.Quota(ctx, &pb.QuotaRequest{Name: name})
+	if err != nil {
+		return QuotaUsage{}, &Error{Op: "quota", Name: name, Err: err}
+	}
+
+	return QuotaUsage{
+		UsedBytes: resp.GetUsedBytes(),
+		UsedFiles: resp.GetUsedFiles(),
+		Quota:     Quota{MaxBytes: resp.GetMaxBytes(), MaxFiles: resp.GetMaxFiles()},
+	}, nil
+}
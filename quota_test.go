@@ -0,0 +1,167 @@
+package alcatraz
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUsageIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the files uploaded before are counted
+	if err := os.MkdirAll(filepath.Join(dir, "Reese", "old"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "Reese", "old", "a.txt"), []byte("12345"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := loadUsageIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := location{owner: "Reese", storage: "Reese", quota: Quota{MaxBytes: 20, MaxFiles: 3}}
+
+	first, err := idx.reserve(loc, -1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.grow(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// the running uploads share the quota
+	second, err := idx.reserve(loc, -1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = second.grow(6)
+	if status.Code(err) != codes.ResourceExhausted || !strings.Contains(err.Error(), "5 bytes and 1 files remaining") {
+		t.Errorf("expected ResourceExhausted with the remaining allowance, got %v", err)
+	}
+	if _, err := idx.reserve(loc, -1, false); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted for the files, got %v", err)
+	}
+
+	first.commit(8)
+	second.release()
+
+	// replacing a file counts only the difference
+	replace, err := idx.reserve(loc, 5, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replace.grow(12); err != nil {
+		t.Errorf("expected the replaced file to be freed, got %v", err)
+	}
+	replace.commit(12)
+
+	reloaded, err := loadUsageIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := reloaded.usage["Reese"]; u == nil || u.Bytes != 20 || u.Files != 2 {
+		t.Errorf("expected 20 bytes in 2 files, got %+v", u)
+	}
+
+	// the failed upload leaves the file it would replace
+	failed, err := reloaded.reserve(loc, 12, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed.release()
+	if u := reloaded.usage["Reese"]; u.Bytes != 20 || u.Files != 2 || *reloaded.pending["Reese"] != (usage{}) {
+		t.Errorf("expected 20 bytes in 2 files and nothing pending, got %+v", u)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Dewey", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients: []ClientACL{{
+			Identity:    "Dewey",
+			Permissions: []Permission{PermissionUpload},
+			Quota:       Quota{MaxBytes: 10, MaxFiles: 2},
+		}},
+		LogLevel: "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, ChunkSize: 2, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	if err := client.Upload(ctx, "a.txt", strings.NewReader("123456"), WithSize(6)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the declared size is checked before any data
+	err = client.Upload(ctx, "b.txt", strings.NewReader("123456"), WithSize(6))
+	if status.Code(err) != codes.ResourceExhausted || !strings.Contains(err.Error(), "4 bytes and 1 files remaining") {
+		t.Errorf("expected ResourceExhausted with the remaining allowance, got %v", err)
+	}
+
+	// unknown and compressed sizes are checked while writing
+	for _, options := range [][]UploadOption{nil, {WithCompression(CompressionGzip)}} {
+		err = client.Upload(ctx, "b.txt", bytes.NewReader(make([]byte, 1000)), options...)
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("expected ResourceExhausted, got %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "storage", "Dewey", "b.txt")); !os.IsNotExist(err) {
+		t.Error("the rejected upload should be removed")
+	}
+
+	// the replaced file is freed
+	if err := client.Upload(ctx, "a.txt", strings.NewReader("12345678")); err != nil {
+		t.Errorf("expected the replacing upload to succeed, got %v", err)
+	}
+	if err := client.Upload(ctx, "b.txt", strings.NewReader("9")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Upload(ctx, "c.txt", strings.NewReader("0")); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted for the files, got %v", err)
+	}
+
+	usage, err := client.Quota(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := QuotaUsage{UsedBytes: 9, UsedFiles: 2, Quota: Quota{MaxBytes: 10, MaxFiles: 2}}
+	if usage != expected {
+		t.Errorf("expected %+v, got %+v", expected, usage)
+	}
+}
@@ -0,0 +1,225 @@
+package alcatraz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bandwidth limits the upload speed. The schedules are checked in order,
+// the first one matching the current time overrides the default Limit.
+type Bandwidth struct {
+	// Limit is in bytes per second, 0 means unlimited
+	Limit     int64      `yaml:"limit"`
+	Schedules []Schedule `yaml:"schedules"`
+}
+
+// Schedule is a time window, in local time, with its own limit.
+type Schedule struct {
+	// Days are "mon", "tue", ..., "sun", empty means every day
+	Days []string `yaml:"days"`
+	// Start and End are in "15:04" format, window could go over midnight
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	Limit int64  `yaml:"limit"`
+	// Pause stops the uploads during the window
+	Pause bool `yaml:"pause"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+type window struct {
+	days       daySet
+	start, end time.Duration
+	limit      int64
+	pause      bool
+}
+
+func parseSchedule(s Schedule) (window, error) {
+	w := window{limit: s.Limit, pause: s.Pause}
+
+	if len(s.Days) > 0 {
+		w.days = daySet{}
+		for _, day := range s.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return window{}, fmt.Errorf("unknown day %q", day)
+			}
+			w.days[weekday] = true
+		}
+	}
+
+	var err error
+	if w.start, err = parseClock(s.Start); err != nil {
+		return window{}, fmt.Errorf("bad start: %v", err)
+	}
+	if w.end, err = parseClock(s.End); err != nil {
+		return window{}, fmt.Errorf("bad end: %v", err)
+	}
+
+	return w, nil
+}
+
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w window) contains(t time.Time) bool {
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	day := t.Weekday()
+
+	if w.start <= w.end {
+		return w.days.has(day) && clock >= w.start && clock < w.end
+	}
+
+	// the window goes over midnight, the part after it belongs to the previous day
+	if clock >= w.start {
+		return w.days.has(day)
+	}
+	return clock < w.end && w.days.has((day+6)%7)
+}
+
+type daySet map[time.Weekday]bool
+
+func (d daySet) has(day time.Weekday) bool {
+	return len(d) == 0 || d[day]
+}
+
+// rateLimiter is a token bucket, shared by all goroutines uploading through it.
+type rateLimiter struct {
+	limit   int64
+	windows []window
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newRateLimiter returns nil limiter if there is nothing to limit.
+func newRateLimiter(b Bandwidth) (*rateLimiter, error) {
+	if b.Limit < 0 {
+		return nil, fmt.Errorf("negative bandwidth limit %d", b.Limit)
+	}
+	if b.Limit == 0 && len(b.Schedules) == 0 {
+		return nil, nil
+	}
+
+	windows := []window{}
+	for i, s := range b.Schedules {
+		w, err := parseSchedule(s)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %d: %v", i, err)
+		}
+		windows = append(windows, w)
+	}
+
+	return &rateLimiter{
+		limit:   b.Limit,
+		windows: windows,
+		now:     time.Now,
+	}, nil
+}
+
+// current returns the limit at the given time and if uploads are paused.
+func (l *rateLimiter) current(t time.Time) (int64, bool) {
+	for _, w := range l.windows {
+		if w.contains(t) {
+			return w.limit, w.pause
+		}
+	}
+	return l.limit, false
+}
+
+// wait blocks until n bytes could be sent. Waiting is interrupted if ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		now := l.now()
+		limit, paused := l.current(now)
+		if paused {
+			l.mu.Unlock()
+			if err := l.resume(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		if limit == 0 {
+			l.mu.Unlock()
+			return nil
+		}
+
+		// refill the bucket, it holds at most one second worth of bytes
+		if l.last.IsZero() {
+			l.tokens = float64(limit)
+		} else {
+			l.tokens += now.Sub(l.last).Seconds() * float64(limit)
+		}
+		if l.tokens > float64(limit) {
+			l.tokens = float64(limit)
+		}
+		l.last = now
+
+		// reserve the bytes, the debt is paid by sleeping
+		l.tokens -= float64(n)
+		debt := l.tokens
+		l.mu.Unlock()
+
+		if debt >= 0 {
+			return nil
+		}
+		return sleep(ctx, time.Duration(-debt/float64(limit)*float64(time.Second)))
+	}
+}
+
+// resume blocks while the uploads are paused. It's called before the upload stream is opened,
+// so the server doesn't keep an idle stream during the pause window.
+func (l *rateLimiter) resume(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		now := l.now()
+		_, paused := l.current(now)
+		l.mu.Unlock()
+		if !paused {
+			return nil
+		}
+		// check every minute if the pause window is over
+		if err := sleep(ctx, time.Minute-time.Duration(now.Second())*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+package alcatraz
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScheduleWindow(t *testing.T) {
+	limiter, err := newRateLimiter(Bandwidth{
+		Limit: 1000,
+		Schedules: []Schedule{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00", Pause: true},
+			{Start: "22:00", End: "06:00", Limit: 0},
+			{Days: []string{"sat"}, Start: "00:00", End: "12:00", Limit: 100},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		time   string
+		limit  int64
+		paused bool
+	}{
+		{"2020-03-30 10:00", 0, true},     // monday, business hours
+		{"2020-03-30 17:00", 1000, false}, // monday, after work
+		{"2020-03-29 10:00", 1000, false}, // sunday
+		{"2020-03-30 23:00", 0, false},    // night
+		{"2020-03-31 05:59", 0, false},    // night, next day
+		{"2020-04-04 11:00", 100, false},  // saturday
+	}
+
+	for _, test := range tests {
+		now, _ := time.ParseInLocation("2006-01-02 15:04", test.time, time.Local)
+		limit, paused := limiter.current(now)
+		if limit != test.limit || paused != test.paused {
+			t.Errorf("%s: expected %d/%v, got %d/%v", test.time, test.limit, test.paused, limit, paused)
+		}
+	}
+
+	if _, err := newRateLimiter(Bandwidth{Schedules: []Schedule{{Start: "25:00", End: "10:00"}}}); err == nil {
+		t.Error("bad start time, error should be returned")
+	}
+	if _, err := newRateLimiter(Bandwidth{Schedules: []Schedule{{Days: []string{"someday"}}}}); err == nil {
+		t.Error("bad day, error should be returned")
+	}
+	if limiter, _ := newRateLimiter(Bandwidth{}); limiter != nil {
+		t.Error("nothing to limit, limiter should be nil")
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	limiter, _ := newRateLimiter(Bandwidth{Limit: 1000})
+
+	// the bucket starts full
+	start := time.Now()
+	if err := limiter.wait(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("first second worth of bytes should not wait")
+	}
+
+	// then, 100 bytes take 100ms
+	start = time.Now()
+	limiter.wait(context.Background(), 100)
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("expected to wait ~100ms, waited %v", elapsed)
+	}
+
+	// canceled context interrupts the waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.wait(ctx, 100000); err == nil {
+		t.Error("context is canceled, error should be returned")
+	}
+}
+
+func TestRateLimiterResume(t *testing.T) {
+	limiter, _ := newRateLimiter(Bandwidth{Schedules: []Schedule{{Start: "09:00", End: "17:00", Pause: true}}})
+	at := func(clock string) func() time.Time {
+		return func() time.Time {
+			now, _ := time.ParseInLocation("2006-01-02 15:04", "2020-03-30 "+clock, time.Local)
+			return now
+		}
+	}
+
+	limiter.now = at("08:00")
+	if err := limiter.resume(context.Background()); err != nil {
+		t.Errorf("not paused, expected no wait, got %v", err)
+	}
+
+	limiter.now = at("10:00")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.resume(ctx); err != context.DeadlineExceeded {
+		t.Errorf("paused, expected to wait until the context is done, got %v", err)
+	}
+}
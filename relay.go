@@ -0,0 +1,170 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Relay forwards the uploads to an upstream server, for the sites which can't reach it. The
+// files are stored on the relay with their hash and the client's identity, and kept in a queue
+// until the upstream acknowledges them. The relay uploads with its own certificate, the files
+// of the clients are stored under their escaped identity in the relay's folder, the files of the
+// spaces in the same space upstream.
+type Relay struct {
+	// Upstream is the address of the upstream server, like "central:8080", empty disables the relay
+	Upstream string `yaml:"upstream"`
+	// Certificates identify the relay to the upstream, default are the server's
+	Certificates CertFiles `yaml:"certificates"`
+	// RetryInterval is how long to wait after a failure, default is 1 minute
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+func (r Relay) validate(replication Replication) error {
+	for _, secondary := range replication.Secondaries {
+		if secondary == relayQueue {
+			return fmt.Errorf("secondary can't be named %q", relayQueue)
+		}
+	}
+	if r.RetryInterval < 0 {
+		return fmt.Errorf("retry interval can't be negative")
+	}
+	return nil
+}
+
+func (r Relay) certificates(server CertFiles) CertFiles {
+	if r.Certificates == (CertFiles{}) {
+		return server
+	}
+	return r.Certificates
+}
+
+func (r Relay) retryInterval() time.Duration {
+	if r.RetryInterval == 0 {
+		return time.Minute
+	}
+	return r.RetryInterval
+}
+
+// relayQueue is the queue of the upstream, next to the secondaries' ones.
+const relayQueue = "relay"
+
+// metadata added to the relayed files, about the upload to the relay
+const (
+	originIdentity = "origin-identity"
+	originAddress  = "origin-address"
+	originHash     = "origin-" + hashAlgorithm
+	originUploaded = "origin-uploaded"
+)
+
+// sendUpstream uploads the current file to the upstream, with the origin in its metadata. Files
+// which don't match their verified hash anymore are not sent, see dropCorrupt.
+func (s *Server) sendUpstream(ctx context.Context, client *Client, op replicaOp) error {
+	loc, ok := s.ownerLocation(op.Owner)
+	if !ok {
+		return errUnknownOwner
+	}
+	loc.name = op.Name
+
+	rec, ok, err := s.index.get(loc.owner, loc.name, "")
+	if err != nil {
+		return err
+	}
+	file, err := s.openCopy(loc)
+	if os.IsNotExist(err) {
+		// it's deleted or moved, the move queues the new name
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !ok {
+		// the stored file isn't indexed, it's sent after reindex
+		file.Close()
+		return fmt.Errorf("%q of %q is not indexed, reindex is needed", op.Name, op.Owner)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != rec.Hash {
+		return s.dropCorrupt(ctx, loc, rec, actual)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	stored, err := s.readMetadata(loc.owner, loc.name)
+	if err != nil {
+		return err
+	}
+	metadata := map[string]string{}
+	for key, value := range stored {
+		metadata[key] = value
+	}
+	metadata[originIdentity] = rec.Identity
+	metadata[originAddress] = rec.Address
+	metadata[originHash] = rec.Hash
+	metadata[originUploaded] = rec.Uploaded.Format(time.RFC3339)
+
+	err = client.Upload(ctx, relayName(op.Owner, op.Name), file, WithSize(size), WithMetadata(metadata))
+	if status.Code(err) == codes.AlreadyExists {
+		// WORM files upstream are not replaced
+		log.Warnf("Relay: %q of %q already exists on %q and can't be replaced", op.Name, op.Owner, client.Host)
+		return nil
+	}
+	return err
+}
+
+// relayName is the upstream name of the owner's file. The identity is escaped into one folder,
+// it can have slashes like the SPIFFE IDs, the raw one is kept in the origin-identity metadata.
+func relayName(owner, name string) string {
+	if strings.HasPrefix(owner, spacePrefix) {
+		return path.Join(owner, name)
+	}
+	folder := url.PathEscape(owner)
+	if folder == "." || folder == ".." {
+		folder = strings.Replace(folder, ".", "%2E", -1)
+	}
+	return folder + "/" + name
+}
+
+// dropCorrupt handles the file which doesn't match its verified hash. A mirrored file with a
+// healthy copy is repaired and sent on the next try, otherwise the file is quarantined and its
+// upload is acknowledged, so the queue moves on.
+func (s *Server) dropCorrupt(ctx context.Context, loc location, rec fileRecord, hash string) error {
+	replicationMetrics.Add(relayQueue+".corrupt", 1)
+	if len(s.mirrorDirs) > 0 {
+		healthy, err := s.checkCopies(ctx, loc, rec, nil, true, map[string]*DiskHealth{})
+		if err != nil {
+			return err
+		}
+		if healthy {
+			return fmt.Errorf("%q of %q was damaged and is repaired, it's sent again", rec.Name, rec.Owner)
+		}
+	}
+
+	log.Errorf("Relay: %q of %q doesn't match its verified hash, it's quarantined and not sent", rec.Name, rec.Owner)
+	quarantined, err := s.quarantine(loc, rec, loc.path(s.StoragePath), hash)
+	if err != nil {
+		log.Errorf("Relay: failed to quarantine %q of %q: %v", rec.Name, rec.Owner, err)
+	}
+	if quarantined {
+		scrubMetrics.Add("corrupt_files", 1)
+		s.syncMirrors(rec.Owner, rec.Name)
+	}
+	return nil
+}
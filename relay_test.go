@@ -0,0 +1,154 @@
+package alcatraz
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelayName(t *testing.T) {
+	tests := []struct {
+		owner, name, expected string
+	}{
+		{"Malcolm", "a.txt", "Malcolm/a.txt"},
+		{"spiffe://org/a", "b/c.txt", "spiffe:%2F%2Forg%2Fa/b/c.txt"},
+		{"spiffe://org/team", "x/f", "spiffe:%2F%2Forg%2Fteam/x/f"},
+		{"spiffe://org/team/x", "f", "spiffe:%2F%2Forg%2Fteam%2Fx/f"},
+		{"..", "f", "%2E%2E/f"},
+		{"@team", "a.txt", "@team/a.txt"},
+	}
+
+	for _, test := range tests {
+		if name := relayName(test.owner, test.name); name != test.expected {
+			t.Errorf("%q of %q: expected %q, got %q", test.name, test.owner, test.expected, name)
+		}
+	}
+}
+
+func TestRelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, relayFiles := ca.issue(t, "relay", nil)
+	_, clientFiles := ca.issue(t, "Malcolm", nil)
+
+	upstream, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "upstream"),
+		Certificates: serverFiles,
+		Clients:      []ClientACL{{Identity: "relay", Permissions: []Permission{PermissionUpload, PermissionList}}},
+		LogLevel:     "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreamAddr, stopUpstream := serveTLS(t, upstream)
+	defer stopUpstream()
+
+	storage := filepath.Join(dir, "relay")
+	relay, err := NewServer(ServerConfig{
+		StoragePath:  storage,
+		Certificates: serverFiles,
+		Clients:      []ClientACL{{Identity: "Malcolm", Permissions: []Permission{PermissionUpload, PermissionList}}},
+		Relay:        Relay{Upstream: upstreamAddr, Certificates: relayFiles, RetryInterval: 50 * time.Millisecond},
+		LogLevel:     "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayAddr, stopRelay := serveTLS(t, relay)
+	defer stopRelay()
+
+	client, err := NewClient(ClientConfig{Host: relayAddr, Certificates: clientFiles, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	// the uploads are kept until the upstream is reached
+	if err := client.Upload(ctx, "a.txt", strings.NewReader("content of a.txt"), WithMetadata(map[string]string{"job": "nightly"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Upload(ctx, "b.txt", strings.NewReader("content of b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if length := relay.index.queueLength(relayQueue); length != 2 {
+		t.Errorf("expected 2 queued uploads, got %d", length)
+	}
+
+	// the damaged file, with the same size and modification time, is not forwarded
+	damaged := filepath.Join(storage, "Malcolm", "b.txt")
+	info, err := os.Stat(damaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(damaged, []byte("content of b.txU"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(damaged, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file missing in the index is kept queued
+	if err := ioutil.WriteFile(filepath.Join(storage, "Malcolm", "c.txt"), []byte("content of c.txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	relay.replicate(replicaOp{Op: replicaUpload, Owner: "Malcolm", Name: "c.txt"})
+
+	forwarding, stop := context.WithCancel(ctx)
+	defer stop()
+	relay.replicateAll(forwarding)
+
+	var rec fileRecord
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if rec, _, _ = upstream.index.get("relay", "Malcolm/a.txt", ""); rec.Name != "" {
+			break
+		}
+	}
+	if rec.Identity != "relay" {
+		t.Fatalf("expected the file to be uploaded by the relay, got %+v", rec)
+	}
+	local, _, _ := relay.index.get("Malcolm", "a.txt", "")
+	metadata, err := upstream.readMetadata("relay", "Malcolm/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["job"] != "nightly" || metadata[originIdentity] != "Malcolm" || metadata[originHash] != local.Hash || rec.Hash != local.Hash {
+		t.Errorf("expected the origin in the metadata, got %v", metadata)
+	}
+
+	// it's quarantined and the queue moves on
+	for start := time.Now(); time.Since(start) < 5*time.Second && relay.index.queueLength(relayQueue) > 1; time.Sleep(10 * time.Millisecond) {
+	}
+	if length := relay.index.queueLength(relayQueue); length != 1 {
+		t.Errorf("expected only the unindexed file queued, got %d queued", length)
+	}
+	if _, ok, _ := upstream.index.get("relay", "Malcolm/b.txt", ""); ok {
+		t.Errorf("expected the damaged file not to be forwarded")
+	}
+	if _, err := os.Stat(damaged); !os.IsNotExist(err) {
+		t.Errorf("expected the damaged file to be quarantined, got %v", err)
+	}
+
+	// it's sent once reindexed
+	if err := relay.reindex(); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); time.Since(start) < 5*time.Second && relay.index.queueLength(relayQueue) > 0; time.Sleep(10 * time.Millisecond) {
+	}
+	if _, ok, _ := upstream.index.get("relay", "Malcolm/c.txt", ""); !ok {
+		t.Errorf("expected the reindexed file to be forwarded")
+	}
+}
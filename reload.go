@@ -0,0 +1,265 @@
+package alcatraz
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+)
+
+// serverTLS is the server's certificate and the certificate authority, used for the new handshakes.
+type serverTLS struct {
+	certificate tls.Certificate
+	leaf        *x509.Certificate
+	certPool    *x509.CertPool
+	caHash      [sha256.Size]byte
+	// staple is the OCSP response for the certificate
+	staple []byte
+}
+
+func loadServerTLS(certs CertFiles) (*serverTLS, error) {
+	// Load the server certificate
+	certificate, err := certs.getCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("could not get certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("could not parse certificate: %v", err)
+	}
+
+	// Get a certificate pool with the certificate authority
+	certPool, err := certs.getCertAuthPool()
+	if err != nil {
+		return nil, fmt.Errorf("could not get cert auth pool: %v", err)
+	}
+
+	ca, err := ioutil.ReadFile(certs.CertAuth)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ca certificate: %v", err)
+	}
+
+	return &serverTLS{
+		certificate: certificate,
+		leaf:        leaf,
+		certPool:    certPool,
+		caHash:      sha256.Sum256(ca),
+	}, nil
+}
+
+func (s *Server) tlsConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	certificate := s.tls.certificate
+	certificate.OCSPStaple = s.tls.staple
+
+	return &tls.Config{
+		ClientAuth:   s.clientAuth(),
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    s.tls.certPool,
+		NextProtos:   []string{"h2"},
+	}, nil
+}
+
+func (s *Server) identitySource() Identity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.identity
+}
+
+// clientAuth allows connections without client certificate only for enrollment.
+func (s *Server) clientAuth() tls.ClientAuthType {
+	if s.Enrollment.enabled() {
+		return tls.VerifyClientCertIfGiven
+	}
+	return tls.RequireAndVerifyClientCert
+}
+
+func (s *Server) accessList() accessList {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.acl
+}
+
+func (s *Server) revocationList() *revocationList {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.crl
+}
+
+// refreshCRL reloads the CRL file periodically, until ctx is done.
+func (s *Server) refreshCRL(ctx context.Context) {
+	interval := s.CRLRefresh
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			crl := s.revocationList()
+			if crl == nil {
+				continue
+			}
+			if err := crl.refresh(); err != nil {
+				log.Errorf("Failed to refresh CRL, keeping the old one: %v", err)
+			}
+		}
+	}
+}
+
+// Reload applies the clients, limits, spaces, policy, identity source, certificates, revocation
+// checks and log level from config. The established connections and streams are not interrupted,
+// the new certificates are used for the new connections and the new clients are checked on the
+// next request. If config is invalid, the server keeps the old one.
+func (s *Server) Reload(config ServerConfig) error {
+	lvl, err := logrus.ParseLevel(config.LogLevel)
+	if err != nil {
+		return fmt.Errorf("couldn't parse log level: %v", err)
+	}
+
+	if err := validateIdentity(config.Identity); err != nil {
+		return err
+	}
+	if err := config.Limits.validate(); err != nil {
+		return fmt.Errorf("invalid limits: %v", err)
+	}
+	acl, err := newAccessList(config)
+	if err != nil {
+		return fmt.Errorf("invalid clients: %v", err)
+	}
+	spaces, err := newSpaces(config, acl)
+	if err != nil {
+		return fmt.Errorf("invalid spaces: %v", err)
+	}
+
+	policy, err := newPolicy(config.Policy)
+	if err != nil {
+		return fmt.Errorf("invalid policy: %v", err)
+	}
+
+	serverTLS, err := loadServerTLS(config.Certificates)
+	if err != nil {
+		return err
+	}
+
+	var crl *revocationList
+	if config.CRL != "" {
+		if crl, err = newRevocationList(config.CRL, config.Certificates.CertAuth); err != nil {
+			return err
+		}
+	}
+
+	ocspClient, err := newOCSPClient(config.OCSP, config.Certificates.CertAuth)
+	if err != nil {
+		return fmt.Errorf("invalid ocsp: %v", err)
+	}
+
+	if config.Port != s.Port || !reflect.DeepEqual(storageDirs(config.StoragePath), s.storageDirs()) ||
+		config.Mirroring != s.Mirroring || config.Versioning != s.Versioning ||
+		config.Metrics != s.Metrics || !reflect.DeepEqual(config.Scrubbing, s.Scrubbing) ||
+		!reflect.DeepEqual(config.Replication, s.Replication) || !reflect.DeepEqual(config.Relay, s.Relay) {
+		log.Warn("Reload: port, storage path, mirroring, versioning, scrub, metrics, replication and relay changes require restart, they are ignored")
+	}
+
+	s.mu.Lock()
+	oldIdentity, oldACL, oldSpaces, oldPolicy, oldTLS, oldCRL, oldOCSP := s.identity, s.acl, s.spaces, s.policy, s.tls, s.crl, s.ocsp
+	s.identity, s.acl, s.spaces, s.policy, s.tls, s.crl, s.ocsp = config.Identity, acl, spaces, policy, serverTLS, crl, ocspClient
+	s.mu.Unlock()
+
+	// the new certificate needs its own staple
+	if _, err := s.updateStaple(); err != nil {
+		log.Errorf("Failed to update OCSP staple: %v", err)
+	}
+
+	logrus.SetLevel(lvl)
+
+	// the changes held for the unknown owners are sent, if their owner is back
+	s.releaseHeld()
+
+	changes := diffAccessLists(oldACL, acl)
+	changes = append(changes, diffSpaces(oldSpaces, spaces)...)
+	if !reflect.DeepEqual(oldPolicy, policy) {
+		changes = append(changes, fmt.Sprintf("policy changed, %d rules", len(config.Policy.Rules)))
+	}
+	if oldIdentity != config.Identity {
+		changes = append(changes, fmt.Sprintf("client identity is taken from %q", config.Identity.Source))
+	}
+	if oldTLS.leaf.SerialNumber.Cmp(serverTLS.leaf.SerialNumber) != 0 {
+		changes = append(changes, fmt.Sprintf("server certificate changed, serial %s, expires %s",
+			serverTLS.leaf.SerialNumber, serverTLS.leaf.NotAfter))
+	}
+	if oldTLS.caHash != serverTLS.caHash {
+		changes = append(changes, "certificate authority changed")
+	}
+	if oldCRL != nil && crl == nil {
+		changes = append(changes, "CRL disabled")
+	} else if crl != nil && (oldCRL == nil || oldCRL.filename != crl.filename || !oldCRL.modTime.Equal(crl.modTime)) {
+		changes = append(changes, fmt.Sprintf("CRL %q loaded with %d revoked certificates", crl.filename, len(crl.revoked)))
+	}
+
+	if (oldOCSP == nil) != (ocspClient == nil) || (ocspClient != nil && oldOCSP.OCSP != ocspClient.OCSP) {
+		changes = append(changes, "OCSP settings changed")
+	}
+
+	if len(changes) == 0 {
+		log.Info("Reload: nothing changed")
+	}
+	for _, change := range changes {
+		log.Infof("Reload: %s", change)
+	}
+
+	return nil
+}
+
+func diffAccessLists(old, new accessList) []string {
+	changes := []string{}
+	for identity, client := range new {
+		if oldClient, ok := old[identity]; !ok {
+			changes = append(changes, fmt.Sprintf("added client %q", identity))
+		} else if !reflect.DeepEqual(oldClient, client) {
+			changes = append(changes, fmt.Sprintf("changed client %q", identity))
+		}
+	}
+	for identity := range old {
+		if _, ok := new[identity]; !ok {
+			changes = append(changes, fmt.Sprintf("removed client %q", identity))
+		}
+	}
+
+	sort.Strings(changes)
+	return changes
+}
+
+func diffSpaces(old, new spaceList) []string {
+	changes := []string{}
+	for name, space := range new {
+		if oldSpace, ok := old[name]; !ok {
+			changes = append(changes, fmt.Sprintf("added space %q", name))
+		} else if !reflect.DeepEqual(oldSpace, space) {
+			changes = append(changes, fmt.Sprintf("changed space %q", name))
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			changes = append(changes, fmt.Sprintf("removed space %q", name))
+		}
+	}
+
+	sort.Strings(changes)
+	return changes
+}
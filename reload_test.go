@@ -0,0 +1,89 @@
+package alcatraz
+
+import (
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/testdata"
+)
+
+func TestServerReload(t *testing.T) {
+	config := ServerConfig{
+		LogLevel: "debug",
+		Certificates: CertFiles{
+			Certificate: testdata.Path("server1.pem"),
+			Key:         testdata.Path("server1.key"),
+			CertAuth:    testdata.Path("ca.pem"),
+		},
+		StoragePath: "storage",
+		Clients:     []ClientACL{{Identity: "Reese", Permissions: []Permission{PermissionUpload}}},
+	}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	defer os.RemoveAll(config.StoragePath)
+
+	tlsConfig, err := server.tlsConfigForClient(nil)
+	if err != nil || len(tlsConfig.Certificates) != 1 || tlsConfig.ClientCAs == nil {
+		t.Fatalf("unexpected tls config: %v", err)
+	}
+
+	// invalid config keeps the old one
+	bad := config
+	bad.Clients = []ClientACL{{Identity: "Dewey", Permissions: []Permission{"fly"}}}
+	if err := server.Reload(bad); err == nil {
+		t.Error("invalid clients, error should be returned")
+	}
+	bad = config
+	bad.Certificates.CertAuth = "missing.crt"
+	if err := server.Reload(bad); err == nil {
+		t.Error("missing ca, error should be returned")
+	}
+	if _, ok := server.accessList().lookup("Reese"); !ok {
+		t.Error("failed reload should keep the old clients")
+	}
+
+	config.Clients = []ClientACL{{Identity: "Dewey", Permissions: []Permission{PermissionUpload}}}
+	if err := server.Reload(config); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if _, ok := server.accessList().lookup("Reese"); ok {
+		t.Error("Reese should be removed")
+	}
+	if _, ok := server.accessList().lookup("Dewey"); !ok {
+		t.Error("Dewey should be added")
+	}
+
+	// the requests of the removed client, which were authorized before the reload, fail
+	if _, err := server.locate("Reese", "a.txt", true); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+	if _, err := server.locateAs("Reese", "Dewey", "a.txt", true); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestDiffAccessLists(t *testing.T) {
+	old, _ := newAccessList(ServerConfig{Clients: []ClientACL{
+		{Identity: "Reese", Permissions: []Permission{PermissionUpload}},
+		{Identity: "Dewey"},
+	}})
+	new, _ := newAccessList(ServerConfig{Clients: []ClientACL{
+		{Identity: "Reese", Permissions: []Permission{PermissionUpload, PermissionList}},
+		{Identity: "Malcolm"},
+	}})
+
+	changes := diffAccessLists(old, new)
+	expected := []string{`added client "Malcolm"`, `changed client "Reese"`, `removed client "Dewey"`}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], changes[i])
+		}
+	}
+}
@@ -0,0 +1,580 @@
+package alcatraz
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Replication forwards the stored, deleted and moved files to the secondary servers. The
+// secondaries know the same clients and spaces, and allow the primary's identity to replicate.
+type Replication struct {
+	// Secondaries are the addresses of the secondary servers, like "standby:8080"
+	Secondaries []string `yaml:"secondaries"`
+	// Certificates are used to connect to the secondaries, default are the server's
+	Certificates CertFiles `yaml:"certificates"`
+	// RetryInterval is how long to wait after a failure, default is 1 minute
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+func (r Replication) validate() error {
+	seen := map[string]bool{}
+	for _, secondary := range r.Secondaries {
+		if secondary == "" {
+			return fmt.Errorf("secondary address is required")
+		}
+		if seen[secondary] {
+			return fmt.Errorf("secondary %q is listed more than once", secondary)
+		}
+		seen[secondary] = true
+	}
+	if r.RetryInterval < 0 {
+		return fmt.Errorf("retry interval can't be negative")
+	}
+	return nil
+}
+
+func (r Replication) certificates(server CertFiles) CertFiles {
+	if r.Certificates == (CertFiles{}) {
+		return server
+	}
+	return r.Certificates
+}
+
+func (r Replication) retryInterval() time.Duration {
+	if r.RetryInterval == 0 {
+		return time.Minute
+	}
+	return r.RetryInterval
+}
+
+// replication operations
+const (
+	replicaUpload = "upload"
+	replicaDelete = "delete"
+	replicaMove   = "move"
+)
+
+// replicaOp is a change waiting to be sent to a secondary. Uploads send the file as it is when
+// they are sent, so the later changes of the file win.
+type replicaOp struct {
+	Op          string    `json:"op"`
+	Owner       string    `json:"owner"`
+	Name        string    `json:"name"`
+	Destination string    `json:"destination,omitempty"`
+	Queued      time.Time `json:"queued"`
+}
+
+// replicationBucket has a queue for every secondary, by the secondary's address.
+var replicationBucket = []byte("replication")
+
+// heldBucket keeps the operations of the owners which are not known, by queue. They are queued
+// again when the server starts or the config is reloaded, the owner could be back.
+var heldBucket = []byte("replication-held")
+
+// errUnknownOwner is returned by the senders for the operations of an owner removed from the
+// config, the operation is held until the owner is back.
+var errUnknownOwner = errors.New("owner is not known")
+
+// replicationMetrics count the sent, the held and the failed operations, by secondary or relay.
+var replicationMetrics = expvar.NewMap("replication")
+
+// openQueues creates the queues of the secondaries and the relay. New queues start with all
+// current files queued, so the new secondary or upstream catches up.
+func (idx *metaIndex) openQueues(secondaries []string) error {
+	if idx == nil {
+		return nil
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		queues, err := tx.CreateBucketIfNotExists(replicationBucket)
+		if err != nil {
+			return err
+		}
+		for _, secondary := range secondaries {
+			if queues.Bucket([]byte(secondary)) != nil {
+				continue
+			}
+			queue, err := queues.CreateBucket([]byte(secondary))
+			if err != nil {
+				return err
+			}
+
+			queued := 0
+			err = tx.Bucket(filesBucket).ForEach(func(_, v []byte) error {
+				var rec fileRecord
+				if err := json.Unmarshal(v, &rec); err != nil {
+					return err
+				}
+				queued++
+				return pushOp(queue, replicaOp{Op: replicaUpload, Owner: rec.Owner, Name: rec.Name, Queued: time.Now()})
+			})
+			if err != nil {
+				return err
+			}
+			log.Infof("Queue of %q starts with %d files", secondary, queued)
+		}
+		return nil
+	})
+}
+
+func pushOp(queue *bolt.Bucket, op replicaOp) error {
+	seq, err := queue.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return queue.Put(key, data)
+}
+
+// enqueue adds the operation to the given queues.
+func (idx *metaIndex) enqueue(secondaries []string, op replicaOp) error {
+	if idx == nil || len(secondaries) == 0 {
+		return nil
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		queues := tx.Bucket(replicationBucket)
+		if queues == nil {
+			return fmt.Errorf("replication queues are not open")
+		}
+		for _, secondary := range secondaries {
+			queue := queues.Bucket([]byte(secondary))
+			if queue == nil {
+				return fmt.Errorf("queue of %q is not open", secondary)
+			}
+			if err := pushOp(queue, op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// peek returns the first operation in the secondary's queue, and its key for ack.
+func (idx *metaIndex) peek(secondary string) (replicaOp, []byte, bool, error) {
+	var op replicaOp
+	var key []byte
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		queues := tx.Bucket(replicationBucket)
+		if queues == nil || queues.Bucket([]byte(secondary)) == nil {
+			return nil
+		}
+		k, v := queues.Bucket([]byte(secondary)).Cursor().First()
+		if k == nil {
+			return nil
+		}
+		key = append([]byte{}, k...)
+		return json.Unmarshal(v, &op)
+	})
+	return op, key, key != nil, err
+}
+
+// ack removes the sent operation from the secondary's queue.
+func (idx *metaIndex) ack(secondary string, key []byte) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		if queues := tx.Bucket(replicationBucket); queues != nil && queues.Bucket([]byte(secondary)) != nil {
+			return queues.Bucket([]byte(secondary)).Delete(key)
+		}
+		return nil
+	})
+}
+
+// hold moves the operation from the queue to the held ones.
+func (idx *metaIndex) hold(queue string, key []byte, op replicaOp) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		queues := tx.Bucket(replicationBucket)
+		if queues == nil || queues.Bucket([]byte(queue)) == nil {
+			return fmt.Errorf("queue of %q is not open", queue)
+		}
+		held, err := tx.CreateBucketIfNotExists(heldBucket)
+		if err != nil {
+			return err
+		}
+		bucket, err := held.CreateBucketIfNotExists([]byte(queue))
+		if err != nil {
+			return err
+		}
+		if err := pushOp(bucket, op); err != nil {
+			return err
+		}
+		return queues.Bucket([]byte(queue)).Delete(key)
+	})
+}
+
+// release moves the held operations to the end of their queues, it returns their number.
+func (idx *metaIndex) release(queues []string) (int, error) {
+	if idx == nil {
+		return 0, nil
+	}
+
+	released := 0
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		held := tx.Bucket(heldBucket)
+		if held == nil {
+			return nil
+		}
+		for _, name := range queues {
+			bucket := held.Bucket([]byte(name))
+			queue := tx.Bucket(replicationBucket).Bucket([]byte(name))
+			if bucket == nil || queue == nil {
+				continue
+			}
+			err := bucket.ForEach(func(_, v []byte) error {
+				var op replicaOp
+				if err := json.Unmarshal(v, &op); err != nil {
+					return err
+				}
+				released++
+				return pushOp(queue, op)
+			})
+			if err != nil {
+				return err
+			}
+			if err := held.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return released, err
+}
+
+// queueLength returns the number of operations waiting for the secondary.
+func (idx *metaIndex) queueLength(secondary string) int {
+	length := 0
+	idx.db.View(func(tx *bolt.Tx) error {
+		if queues := tx.Bucket(replicationBucket); queues != nil && queues.Bucket([]byte(secondary)) != nil {
+			length = queues.Bucket([]byte(secondary)).Stats().KeyN
+		}
+		return nil
+	})
+	return length
+}
+
+// replicate copies the change to the mirror folders, queues it for the secondaries, and the
+// uploads for the upstream of the relay, and wakes their senders.
+func (s *Server) replicate(op replicaOp) {
+	s.syncMirrors(op.Owner, op.Name)
+	if op.Op == replicaMove {
+		s.syncMirrors(op.Owner, op.Destination)
+	}
+
+	queues := s.Replication.Secondaries
+	if s.Relay.Upstream != "" && op.Op == replicaUpload {
+		queues = append(queues[:len(queues):len(queues)], relayQueue)
+	}
+	if len(queues) == 0 {
+		return
+	}
+
+	op.Queued = time.Now()
+	if err := s.index.enqueue(queues, op); err != nil {
+		log.Errorf("Failed to queue %s of %q for replication: %v", op.Op, op.Name, err)
+		return
+	}
+	s.wakeSenders()
+}
+
+// wakeSenders wakes the senders of the secondaries and the relay.
+func (s *Server) wakeSenders() {
+	for _, wake := range s.replicaWake {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// releaseHeld queues again the operations held for the unknown owners, they are held again if
+// their owner is still not known.
+func (s *Server) releaseHeld() {
+	released, err := s.index.release(s.replicaQueues())
+	if err != nil {
+		log.Errorf("Failed to queue the held changes: %v", err)
+		return
+	}
+	if released > 0 {
+		log.Infof("Queued %d changes held for unknown owners", released)
+		s.wakeSenders()
+	}
+}
+
+// replicaQueues are the queues of the secondaries and of the relay's upstream.
+func (c ServerConfig) replicaQueues() []string {
+	queues := append([]string{}, c.Replication.Secondaries...)
+	if c.Relay.Upstream != "" {
+		queues = append(queues, relayQueue)
+	}
+	return queues
+}
+
+// replicateAll sends the queued changes to every secondary and to the upstream, until ctx is done.
+func (s *Server) replicateAll(ctx context.Context) {
+	for _, secondary := range s.Replication.Secondaries {
+		go s.forward(ctx, secondary, secondary, s.Replication.certificates(s.Certificates), s.Replication.retryInterval(), s.sendReplica)
+	}
+	if s.Relay.Upstream != "" {
+		go s.forward(ctx, relayQueue, s.Relay.Upstream, s.Relay.certificates(s.Certificates), s.Relay.retryInterval(), s.sendUpstream)
+	}
+}
+
+// forward sends the changes of the queue to the server at host, in order. A failed change is
+// retried until the server acknowledges it, the queue waits meanwhile.
+func (s *Server) forward(ctx context.Context, queue, host string, certs CertFiles, retry time.Duration,
+	send func(context.Context, *Client, replicaOp) error) {
+	client, err := NewClient(ClientConfig{
+		Host:         host,
+		Certificates: certs,
+		LogLevel:     s.LogLevel,
+	})
+	if err == nil {
+		err = client.Connect()
+	}
+	if err != nil {
+		log.Errorf("Forwarding to %q is disabled: %v", host, err)
+		return
+	}
+	defer client.Close()
+
+	wake := s.replicaWake[queue]
+	for {
+		op, key, ok, err := s.index.peek(queue)
+		if err == nil && ok {
+			if err = send(ctx, client, op); err == nil {
+				replicationMetrics.Add(queue+".sent", 1)
+				if err := s.index.ack(queue, key); err != nil {
+					log.Errorf("Failed to update the queue of %q: %v", host, err)
+				}
+				continue
+			} else if err == errUnknownOwner {
+				// the other owners' changes are not blocked, these are sent if the owner is back
+				log.Warnf("Owner %q is not known anymore, %s of %q to %q is held", op.Owner, op.Op, op.Name, host)
+				if err = s.index.hold(queue, key, op); err == nil {
+					replicationMetrics.Add(queue+".held", 1)
+					continue
+				}
+			}
+			replicationMetrics.Add(queue+".failed", 1)
+			log.Warnf("Sending %s of %q to %q failed, %d changes are waiting: %v", op.Op, op.Name, host, s.index.queueLength(queue), err)
+		} else if err != nil {
+			log.Errorf("Failed to read the queue of %q: %v", host, err)
+		}
+
+		// a failure is retried after the interval, the empty queue waits for new changes
+		if err != nil {
+			if sleep(ctx, retry) != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		}
+	}
+}
+
+// sendReplica sends a change to the secondary. Changes already applied on the secondary, like a
+// deleted file which is not there, are acknowledged.
+func (s *Server) sendReplica(ctx context.Context, client *Client, op replicaOp) error {
+	loc, ok := s.ownerLocation(op.Owner)
+	if !ok {
+		return errUnknownOwner
+	}
+
+	switch op.Op {
+	case replicaDelete:
+		_, err := client.cli.DeleteFile(ctx, &pb.DeleteRequest{Name: op.Name, Owner: op.Owner})
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+	case replicaMove:
+		_, err := client.cli.MoveFile(ctx, &pb.MoveRequest{Source: op.Name, Destination: op.Destination, Owner: op.Owner})
+		if status.Code(err) == codes.NotFound {
+			// the secondary missed the source, the destination is sent instead
+			return s.sendReplica(ctx, client, replicaOp{Op: replicaUpload, Owner: op.Owner, Name: op.Destination})
+		}
+		return err
+	}
+
+	loc.name = op.Name
+	file, err := s.openCopy(loc)
+	if os.IsNotExist(err) {
+		// it's deleted or moved, which is queued after the upload
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	metadata, err := s.readMetadata(loc.owner, loc.name)
+	if err != nil {
+		return err
+	}
+	rec, _, err := s.index.get(loc.owner, loc.name, "")
+	if err != nil {
+		return err
+	}
+
+	err = client.Upload(ctx, op.Name, file, WithSize(info.Size()), WithMetadata(metadata), withReplica(op.Owner, rec.Identity))
+	if status.Code(err) == codes.AlreadyExists {
+		// WORM files on the secondary are not replaced
+		log.Warnf("Replication: %q of %q already exists on %q and can't be replaced", op.Name, op.Owner, client.Host)
+		return nil
+	}
+	return err
+}
+
+// withReplica uploads the file of the owner, as a primary server replicating it.
+func withReplica(owner, uploadedBy string) UploadOption {
+	return func(o *uploadOptions) { o.owner, o.uploadedBy = owner, uploadedBy }
+}
+
+// locateAs resolves the name in the owner's folder or space for a replicating primary, or in
+// the client's own folder or space without owner.
+func (s *Server) locateAs(identity, owner, name string, write bool) (location, error) {
+	if owner == "" {
+		return s.locate(identity, name, write)
+	}
+
+	client, err := s.requestClient(identity)
+	if err != nil {
+		return location{}, err
+	}
+	if !client.Can(PermissionReplicate) {
+		s.audit("replication denied", identity, logrus.Fields{"owner": owner, "name": name})
+		return location{}, grpc.Errorf(codes.PermissionDenied, "replication is not allowed")
+	}
+	loc, ok := s.ownerLocation(owner)
+	if !ok {
+		return location{}, grpc.Errorf(codes.NotFound, "unknown owner %q", owner)
+	}
+	loc.name = name
+	return loc, nil
+}
+
+// CheckReplicas compares the current files of every client and space on the primary with the
+// ones on the secondaries, by size and hash. It connects to both with the replication
+// certificates, which need the replicate permission. It returns an error if they differ.
+func CheckReplicas(config ServerConfig, primary string) error {
+	if len(config.Replication.Secondaries) == 0 {
+		return fmt.Errorf("no secondaries are configured")
+	}
+	acl, err := newAccessList(config)
+	if err != nil {
+		return fmt.Errorf("invalid clients: %v", err)
+	}
+	spaces, err := newSpaces(config, acl)
+	if err != nil {
+		return fmt.Errorf("invalid spaces: %v", err)
+	}
+	owners := []string{}
+	for identity := range acl {
+		owners = append(owners, identity)
+	}
+	for name := range spaces {
+		owners = append(owners, spacePrefix+name)
+	}
+	sort.Strings(owners)
+
+	connect := func(host string) (*Client, error) {
+		client, err := NewClient(ClientConfig{
+			Host:         host,
+			Certificates: config.Replication.certificates(config.Certificates),
+			LogLevel:     config.LogLevel,
+		})
+		if err == nil {
+			err = client.Connect()
+		}
+		return client, err
+	}
+	list := func(client *Client, owner string) (map[string]*pb.FileInfo, error) {
+		resp, err := client.cli.ListFiles(context.Background(), &pb.ListRequest{Owner: owner})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %q on %q: %v", owner, client.Host, err)
+		}
+		files := map[string]*pb.FileInfo{}
+		for _, file := range resp.GetFiles() {
+			files[file.GetName()] = file
+		}
+		return files, nil
+	}
+
+	source, err := connect(primary)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	differences := 0
+	for _, secondary := range config.Replication.Secondaries {
+		replica, err := connect(secondary)
+		if err != nil {
+			return err
+		}
+		defer replica.Close()
+
+		missing, extra, changed := 0, 0, 0
+		for _, owner := range owners {
+			expected, err := list(source, owner)
+			if err != nil {
+				return err
+			}
+			actual, err := list(replica, owner)
+			if err != nil {
+				return err
+			}
+
+			for name, file := range expected {
+				other, ok := actual[name]
+				if !ok {
+					log.Warnf("%s: %q of %q is missing", secondary, name, owner)
+					missing++
+				} else if other.GetSize() != file.GetSize() || other.GetHash() != file.GetHash() {
+					log.Warnf("%s: %q of %q differs", secondary, name, owner)
+					changed++
+				}
+			}
+			for name := range actual {
+				if _, ok := expected[name]; !ok {
+					log.Warnf("%s: %q of %q is not on the primary", secondary, name, owner)
+					extra++
+				}
+			}
+		}
+
+		log.Infof("%s: %d missing, %d different and %d extra files", secondary, missing, changed, extra)
+		differences += missing + changed + extra
+	}
+
+	if differences > 0 {
+		return fmt.Errorf("the secondaries differ in %d files", differences)
+	}
+	return nil
+}
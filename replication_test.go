@@ -0,0 +1,192 @@
+package alcatraz
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, primaryFiles := ca.issue(t, "primary", nil)
+	_, clientFiles := ca.issue(t, "Malcolm", nil)
+
+	clients := []ClientACL{
+		{Identity: "Malcolm", Permissions: []Permission{PermissionUpload, PermissionList, PermissionDelete}},
+		{Identity: "primary", Permissions: []Permission{PermissionUpload, PermissionList, PermissionDelete, PermissionReplicate}},
+	}
+	secondary, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "secondary"),
+		Certificates: serverFiles,
+		Clients:      clients,
+		LogLevel:     "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondaryAddr, stopSecondary := serveTLS(t, secondary)
+	defer stopSecondary()
+
+	config := ServerConfig{
+		StoragePath:  filepath.Join(dir, "primary"),
+		Certificates: serverFiles,
+		Clients:      clients,
+		Replication: Replication{
+			Secondaries:   []string{secondaryAddr},
+			Certificates:  primaryFiles,
+			RetryInterval: 50 * time.Millisecond,
+		},
+		LogLevel: "info",
+	}
+	primary, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primaryAddr, stopPrimary := serveTLS(t, primary)
+	defer stopPrimary()
+
+	connect := func(addr string) *Client {
+		client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, LogLevel: "info"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	client := connect(primaryAddr)
+	defer client.Close()
+	ctx := context.Background()
+
+	waitFor := func(expected string) {
+		t.Helper()
+		names := ""
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			records, _ := secondary.index.list("Malcolm", "", false)
+			list := []string{}
+			for _, rec := range records {
+				list = append(list, rec.Name)
+			}
+			if names = strings.Join(list, ","); names == expected {
+				return
+			}
+		}
+		t.Fatalf("expected %s on the secondary, got %s", expected, names)
+	}
+
+	// the changes are queued while the secondary is not reached
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := client.Upload(ctx, name, strings.NewReader("content of "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Delete(ctx, "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Move(ctx, "a.txt", "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if length := primary.index.queueLength(secondaryAddr); length != 4 {
+		t.Errorf("expected 4 queued changes, got %d", length)
+	}
+
+	replicating, stop := context.WithCancel(ctx)
+	primary.replicateAll(replicating)
+	waitFor("docs/a.txt")
+	if rec, ok, _ := secondary.index.get("Malcolm", "docs/a.txt", ""); !ok || rec.Identity != "Malcolm" {
+		t.Errorf("expected the uploading client to be replicated, got %+v", rec)
+	}
+
+	// the outage is caught up from the queue
+	stop()
+	if err := client.Upload(ctx, "c.txt", strings.NewReader("content of c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	replicating, stop = context.WithCancel(ctx)
+	defer stop()
+	primary.replicateAll(replicating)
+	waitFor("c.txt,docs/a.txt")
+
+	if err := CheckReplicas(config, primaryAddr); err != nil {
+		t.Errorf("expected the replicas to match, got %v", err)
+	}
+	other := connect(secondaryAddr)
+	defer other.Close()
+	if err := other.Upload(ctx, "x.txt", strings.NewReader("only on the secondary")); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckReplicas(config, primaryAddr); err == nil || !strings.Contains(err.Error(), "differ in 1 files") {
+		t.Errorf("expected the extra file to be found, got %v", err)
+	}
+
+	// only the primary writes the files of the others
+	_, err = other.cli.ListFiles(ctx, &pb.ListRequest{Owner: "primary"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestReplicationHeld(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+
+	config := ServerConfig{
+		StoragePath:  filepath.Join(dir, "primary"),
+		Certificates: serverFiles,
+		Clients:      []ClientACL{{Identity: "Malcolm", Permissions: []Permission{PermissionUpload}}},
+		Replication:  Replication{Secondaries: []string{"secondary:8080"}},
+		LogLevel:     "info",
+	}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.index.Close()
+
+	// the change of the removed owner is held, not dropped
+	server.replicate(replicaOp{Op: replicaDelete, Owner: "Dewey", Name: "a.txt"})
+	op, key, ok, err := server.index.peek("secondary:8080")
+	if err != nil || !ok {
+		t.Fatalf("expected the queued change, got %v, %v", ok, err)
+	}
+	if err := server.sendReplica(context.Background(), nil, op); err != errUnknownOwner {
+		t.Fatalf("expected errUnknownOwner, got %v", err)
+	}
+	if err := server.index.hold("secondary:8080", key, op); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.index.queueLength("secondary:8080"); n != 0 {
+		t.Errorf("expected empty queue, got %d", n)
+	}
+
+	// the owner is back after the reload
+	config.Clients = append(config.Clients, ClientACL{Identity: "Dewey", Permissions: []Permission{PermissionUpload}})
+	if err := server.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	if op, _, ok, _ := server.index.peek("secondary:8080"); !ok || op.Owner != "Dewey" || op.Name != "a.txt" {
+		t.Errorf("expected the held change to be queued again, got %+v", op)
+	}
+}
@@ -0,0 +1,272 @@
+package alcatraz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// WORM(write once, read many) makes the stored files immutable. Uploads to existing names are
+// rejected, or stored as new versions when versioning is enabled.
+type WORM struct {
+	Enabled bool `yaml:"enabled"`
+	// Retention is how long after the upload the file and its versions can't be deleted
+	Retention time.Duration `yaml:"retention"`
+}
+
+// holdsFile keeps the legal holds in the internal folder.
+const holdsFile = "holds.json"
+
+// hold blocks the deletion of a file, or of all files in a folder, until it's released.
+type hold struct {
+	Reason string    `json:"reason"`
+	By     string    `json:"by"`
+	Placed time.Time `json:"placed"`
+}
+
+// holdList is the legal holds by owner and name, empty name holds the whole folder.
+type holdList struct {
+	filename string
+
+	mu    sync.Mutex
+	holds map[string]map[string]hold
+}
+
+func loadHoldList(storagePath string) (*holdList, error) {
+	holds := &holdList{
+		filename: filepath.Join(storagePath, internalDir, holdsFile),
+		holds:    map[string]map[string]hold{},
+	}
+
+	data, err := ioutil.ReadFile(holds.filename)
+	if os.IsNotExist(err) {
+		return holds, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read legal holds: %v", err)
+	}
+	if err := json.Unmarshal(data, &holds.holds); err != nil {
+		return nil, fmt.Errorf("failed to parse legal holds %q: %v", holds.filename, err)
+	}
+	return holds, nil
+}
+
+// held returns the hold on the owner's file, or on any of its folders.
+func (l *holdList) held(owner, name string) (string, hold, bool) {
+	if l == nil {
+		return "", hold{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for held, h := range l.holds[owner] {
+		if held == "" || held == name || strings.HasPrefix(name, held+"/") {
+			return held, h, true
+		}
+	}
+	return "", hold{}, false
+}
+
+// place holds the owner's file or folder, replacing the previous hold on it.
+func (l *holdList) place(owner, name string, h hold) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holds[owner] == nil {
+		l.holds[owner] = map[string]hold{}
+	}
+	l.holds[owner][name] = h
+	return l.save()
+}
+
+// release removes the hold on the owner's file or folder, it returns false if there is none.
+func (l *holdList) release(owner, name string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.holds[owner][name]; !ok {
+		return false, nil
+	}
+	delete(l.holds[owner], name)
+	if len(l.holds[owner]) == 0 {
+		delete(l.holds, owner)
+	}
+	return true, l.save()
+}
+
+// save writes the holds to a temporary file, which replaces the old one. mu must be held.
+func (l *holdList) save() error {
+	data, err := json.Marshal(l.holds)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.filename), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directories: %v", err)
+	}
+	if err := ioutil.WriteFile(l.filename+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write legal holds: %v", err)
+	}
+	return os.Rename(l.filename+".tmp", l.filename)
+}
+
+// list returns the holds of the owner, sorted by name.
+func (l *holdList) list(owner string) []*pb.Hold {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	holds := []*pb.Hold{}
+	for name, h := range l.holds[owner] {
+		placed, _ := ptypes.TimestampProto(h.Placed)
+		holds = append(holds, &pb.Hold{Owner: owner, Name: name, Reason: h.Reason, By: h.By, Placed: placed})
+	}
+	sort.Slice(holds, func(i, j int) bool { return holds[i].Name < holds[j].Name })
+	return holds
+}
+
+// checkRetention returns FailedPrecondition, when the file at loc, stored at modified, can't
+// be deleted at now.
+func (s *Server) checkRetention(loc location, modified, now time.Time) error {
+	if held, h, ok := s.holds.held(loc.owner, loc.name); ok {
+		return grpc.Errorf(codes.FailedPrecondition, "%q is under legal hold on %q: %s", loc.prefix+loc.name, loc.prefix+held, h.Reason)
+	}
+	if loc.worm.Enabled {
+		if until := modified.Add(loc.worm.Retention); now.Before(until) {
+			return grpc.Errorf(codes.FailedPrecondition, "%q is retained until %s", loc.prefix+loc.name, until.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// ownerLocation returns the folder of the owner, a client's identity or "@space".
+func (s *Server) ownerLocation(owner string) (location, bool) {
+	if strings.HasPrefix(owner, spacePrefix) {
+		space, ok := s.spaceList()[strings.TrimPrefix(owner, spacePrefix)]
+		if !ok {
+			return location{}, false
+		}
+		return location{owner: owner, storage: space.Storage, prefix: owner + "/", quota: space.Quota, worm: space.WORM}, true
+	}
+
+	client, ok := s.accessList().lookup(owner)
+	if !ok {
+		return location{}, false
+	}
+	return location{owner: owner, storage: client.Storage, quota: client.Quota, worm: client.WORM}, true
+}
+
+// owners returns the locations of the known clients and spaces.
+func (s *Server) owners() []location {
+	owners := []location{}
+	for identity := range s.accessList() {
+		loc, _ := s.ownerLocation(identity)
+		owners = append(owners, loc)
+	}
+	for name := range s.spaceList() {
+		loc, _ := s.ownerLocation(spacePrefix + name)
+		owners = append(owners, loc)
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i].owner < owners[j].owner })
+	return owners
+}
+
+// LegalHold places or releases a legal hold on the files of any client or space.
+func (s *Server) LegalHold(ctx context.Context, req *pb.HoldRequest) (*pb.HoldList, error) {
+	admin := s.identityFromCtx(ctx)
+	if s.holds == nil {
+		return nil, grpc.Errorf(codes.Unimplemented, "legal holds are not supported")
+	}
+
+	owner := req.GetOwner()
+	if _, ok := s.ownerLocation(owner); !ok {
+		return nil, grpc.Errorf(codes.NotFound, "unknown owner %q", owner)
+	}
+	name := cleanName(req.GetName())
+	fields := logrus.Fields{"owner": owner, "name": name}
+
+	switch {
+	case req.GetList():
+	case req.GetRelease():
+		released, err := s.holds.release(owner, name)
+		if err != nil {
+			log.Errorf("Failed to release legal hold: %v", err)
+			return nil, grpc.Errorf(codes.Internal, "failed to release legal hold")
+		}
+		if !released {
+			return nil, grpc.Errorf(codes.NotFound, "no legal hold on %q of %q", name, owner)
+		}
+		s.audit("legal hold released", admin, fields)
+	default:
+		if req.GetReason() == "" {
+			return nil, grpc.Errorf(codes.InvalidArgument, "legal hold requires a reason")
+		}
+		h := hold{Reason: req.GetReason(), By: admin, Placed: time.Now()}
+		if err := s.holds.place(owner, name, h); err != nil {
+			log.Errorf("Failed to place legal hold: %v", err)
+			return nil, grpc.Errorf(codes.Internal, "failed to place legal hold")
+		}
+		fields["reason"] = h.Reason
+		s.audit("legal hold placed", admin, fields)
+	}
+
+	return &pb.HoldList{Holds: s.holds.list(owner)}, nil
+}
+
+// Hold is a legal hold, which blocks the deletion of a file or of all files in a folder.
+type Hold struct {
+	// Owner is the client's identity, or "@space" for a shared space
+	Owner string
+	// Name is the held file or folder, empty for the whole folder of the owner
+	Name   string
+	Reason string
+	// By is the identity of the admin who placed it
+	By     string
+	Placed time.Time
+}
+
+// PlaceHold places a legal hold on the owner's file or folder, it requires the admin permission.
+func (c *Client) PlaceHold(ctx context.Context, owner, name, reason string) ([]Hold, error) {
+	return c.legalHold(ctx, &pb.HoldRequest{Owner: owner, Name: name, Reason: reason})
+}
+
+// ReleaseHold releases the legal hold on the owner's file or folder.
+func (c *Client) ReleaseHold(ctx context.Context, owner, name string) ([]Hold, error) {
+	return c.legalHold(ctx, &pb.HoldRequest{Owner: owner, Name: name, Release: true})
+}
+
+// Holds returns the legal holds on the owner's files.
+func (c *Client) Holds(ctx context.Context, owner string) ([]Hold, error) {
+	return c.legalHold(ctx, &pb.HoldRequest{Owner: owner, List: true})
+}
+
+func (c *Client) legalHold(ctx context.Context, req *pb.HoldRequest) ([]Hold, error) {
+	if c.cli == nil {
+		return nil, ErrNotConnected
+	}
+
+	resp, err := c.cli.LegalHold(ctx, req)
+	if err != nil {
+		return nil, &Error{Op: "hold", Name: path.Join(req.GetOwner(), req.GetName()), Err: err}
+	}
+
+	holds := []Hold{}
+	for _, h := range resp.GetHolds() {
+		placed, _ := ptypes.Timestamp(h.GetPlaced())
+		holds = append(holds, Hold{Owner: h.GetOwner(), Name: h.GetName(), Reason: h.GetReason(), By: h.GetBy(), Placed: placed})
+	}
+	return holds, nil
+}
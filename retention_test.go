@@ -0,0 +1,206 @@
+package alcatraz
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	holds, err := loadHoldList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := holds.place("Reese", "cases", hold{Reason: "case 42", By: "Hal"}); err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{holds: holds}
+
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	worm := WORM{Enabled: true, Retention: 24 * time.Hour}
+	tests := []struct {
+		loc      location
+		modified time.Time
+		err      string
+	}{
+		{location{owner: "Reese", name: "cases/a.txt"}, now.Add(-1000 * time.Hour), "legal hold"},
+		{location{owner: "Reese", name: "casesX.txt"}, now, ""},
+		{location{owner: "Dewey", name: "cases/a.txt"}, now, ""},
+		{location{owner: "Dewey", name: "a.txt", worm: worm}, now.Add(-time.Hour), "retained until 2020-04-02T11:00:00Z"},
+		{location{owner: "Dewey", name: "a.txt", worm: worm}, now.Add(-25 * time.Hour), ""},
+	}
+
+	for _, test := range tests {
+		err := server.checkRetention(test.loc, test.modified, now)
+		if test.err == "" && err != nil {
+			t.Errorf("%+v: expected no error, got %v", test.loc, err)
+		} else if test.err != "" && (status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%+v: expected FailedPrecondition with %q, got %v", test.loc, test.err, err)
+		}
+	}
+
+	// the holds survive restarts, until released
+	reloaded, err := loadHoldList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, h, ok := reloaded.held("Reese", "cases/b.txt"); !ok || h.Reason != "case 42" {
+		t.Errorf("expected the hold to be loaded, got %+v", h)
+	}
+	if released, err := reloaded.release("Reese", "cases"); !released || err != nil {
+		t.Errorf("expected the hold to be released, got %v, %v", released, err)
+	}
+	if released, _ := reloaded.release("Reese", "cases"); released {
+		t.Error("expected nothing to release")
+	}
+}
+
+func TestWORM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients: []ClientACL{
+			{Identity: "Hal", Permissions: []Permission{PermissionAdmin}},
+			{Identity: "Reese", Permissions: []Permission{PermissionUpload}, WORM: WORM{Enabled: true, Retention: time.Hour}},
+		},
+		LogLevel: "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	clients := map[string]*Client{}
+	for _, name := range []string{"Hal", "Reese"} {
+		_, files := ca.issue(t, name, nil)
+		client, err := NewClient(ClientConfig{Host: addr, Certificates: files, LogLevel: "info"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients[name] = client
+	}
+	ctx := context.Background()
+
+	if err := clients["Reese"].Upload(ctx, "a.txt", strings.NewReader("first")); err != nil {
+		t.Fatal(err)
+	}
+	err = clients["Reese"].Upload(ctx, "a.txt", strings.NewReader("second"))
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists, got %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "storage", "Reese", "a.txt"))
+	if err != nil || string(data) != "first" {
+		t.Errorf("expected the first upload to stay, got %q, %v", data, err)
+	}
+
+	// only admins place legal holds
+	if _, err := clients["Reese"].PlaceHold(ctx, "Reese", "", "mine"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+	if _, err := clients["Hal"].PlaceHold(ctx, "Reese", "a.txt", ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument without reason, got %v", err)
+	}
+	if _, err := clients["Hal"].PlaceHold(ctx, "Dewey", "", "case 42"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for unknown owner, got %v", err)
+	}
+	holds, err := clients["Hal"].PlaceHold(ctx, "Reese", "/a.txt", "case 42")
+	if err != nil || len(holds) != 1 || holds[0].Name != "a.txt" || holds[0].By != "Hal" {
+		t.Errorf("expected the hold on a.txt, got %+v, %v", holds, err)
+	}
+
+	loc, _ := server.locate("Reese", "a.txt", false)
+	if err := server.checkRetention(loc, time.Time{}, time.Now()); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected the held file to be retained, got %v", err)
+	}
+
+	if _, err := clients["Hal"].ReleaseHold(ctx, "Reese", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if holds, err := clients["Hal"].Holds(ctx, "Reese"); err != nil || len(holds) != 0 {
+		t.Errorf("expected no holds, got %+v, %v", holds, err)
+	}
+	if _, err := clients["Hal"].ReleaseHold(ctx, "Reese", "a.txt"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestWORMVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Reese", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients: []ClientACL{{
+			Identity:    "Reese",
+			Permissions: []Permission{PermissionUpload, PermissionList},
+			WORM:        WORM{Enabled: true, Retention: 24 * time.Hour},
+		}},
+		Versioning: Versioning{Enabled: true, KeepLast: 1},
+		LogLevel:   "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	// the replaced files are versions, retained over the versioning rules
+	for _, content := range []string{"one", "two", "three"} {
+		if err := client.Upload(ctx, "a.txt", strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := client.List(ctx, "", WithVersions()); len(files) != 3 {
+		t.Errorf("expected all versions to be retained, got %+v", files)
+	}
+
+	server.prune(time.Now().Add(25 * time.Hour))
+	if files, _ := client.List(ctx, "", WithVersions()); len(files) != 2 {
+		t.Errorf("expected the versions after the retention to be pruned, got %+v", files)
+	}
+}
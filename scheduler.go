@@ -0,0 +1,263 @@
+package alcatraz
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PriorityRule changes the priority of the files matching all of its conditions.
+// Zero conditions are not checked.
+type PriorityRule struct {
+	// Pattern is in gitignore syntax, relative to the monitored folder
+	Pattern string `yaml:"pattern"`
+	MinSize int64  `yaml:"min_size"`
+	MaxSize int64  `yaml:"max_size"`
+	// MinAge and MaxAge are compared with the time since the file's last modification
+	MinAge   time.Duration `yaml:"min_age"`
+	MaxAge   time.Duration `yaml:"max_age"`
+	Priority int           `yaml:"priority"`
+}
+
+// Scheduling decides the order in which the found files are uploaded.
+// Bigger priority goes first, on equal priority the smaller file goes first.
+type Scheduling struct {
+	// Rules are checked in order, the first matching one is added to the folder's priority
+	Rules []PriorityRule `yaml:"rules"`
+	// LargeFileSize is the size above which a file is considered large, default is 10MB
+	LargeFileSize int64 `yaml:"large_file_size"`
+	// MaxLargeUploads limits the concurrent uploads of large files, so small ones can bypass them.
+	// Default is half of the parallel uploads.
+	MaxLargeUploads int `yaml:"max_large_uploads"`
+	// Aging adds 1 to the priority of a queued file for every period it waits, default is 1 minute
+	Aging time.Duration `yaml:"aging"`
+}
+
+type priorityRule struct {
+	PriorityRule
+	pattern *rule
+}
+
+func (r priorityRule) match(name string, size int64, age time.Duration) bool {
+	if r.pattern != nil && !r.pattern.match(name, false) {
+		return false
+	}
+	if size < r.MinSize || (r.MaxSize > 0 && size > r.MaxSize) {
+		return false
+	}
+	if age < r.MinAge || (r.MaxAge > 0 && age > r.MaxAge) {
+		return false
+	}
+	return true
+}
+
+// uploadQueue is a priority queue of the files waiting for upload.
+// The effective priorities change while the files wait, so it's scanned on every pop.
+type uploadQueue struct {
+	rules           []priorityRule
+	largeFileSize   int64
+	maxLargeUploads int
+	aging           time.Duration
+
+	mu             sync.Mutex
+	tasks          []*uploadTask
+	uploading      int
+	largeUploading int
+	notify         chan struct{}
+	now            func() time.Time
+}
+
+func newUploadQueue(s Scheduling, parallel int) (*uploadQueue, error) {
+	rules := []priorityRule{}
+	for i, r := range s.Rules {
+		pr := priorityRule{PriorityRule: r}
+		if r.Pattern != "" {
+			parsed, ok, err := parseRule(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("priority rule %d: %v", i, err)
+			}
+			if ok {
+				pr.pattern = &parsed
+			}
+		}
+		rules = append(rules, pr)
+	}
+
+	q := &uploadQueue{
+		rules:           rules,
+		largeFileSize:   s.LargeFileSize,
+		maxLargeUploads: s.MaxLargeUploads,
+		aging:           s.Aging,
+		notify:          make(chan struct{}, 1),
+		now:             time.Now,
+	}
+	if q.largeFileSize <= 0 {
+		q.largeFileSize = 10 << 20
+	}
+	if q.maxLargeUploads <= 0 {
+		q.maxLargeUploads = parallel / 2
+		if q.maxLargeUploads < 1 {
+			q.maxLargeUploads = 1
+		}
+	}
+	if q.aging <= 0 {
+		q.aging = time.Minute
+	}
+
+	return q, nil
+}
+
+// push adds the task to the queue, calculating its base priority.
+func (q *uploadQueue) push(task *uploadTask) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	task.queued = now
+	task.priority = task.folder.Priority
+
+	if rel, err := filepath.Rel(task.folder.Path, task.filename); err == nil {
+		for _, r := range q.rules {
+			if r.match(filepath.ToSlash(rel), task.size, now.Sub(task.modTime)) {
+				task.priority += r.Priority
+				break
+			}
+		}
+	}
+
+	q.tasks = append(q.tasks, task)
+	q.signal()
+}
+
+// pop blocks until there is a task which could be uploaded.
+func (q *uploadQueue) pop(ctx context.Context) (*uploadTask, error) {
+	for {
+		q.mu.Lock()
+		if i := q.next(); i >= 0 {
+			task := q.tasks[i]
+			q.tasks = append(q.tasks[:i], q.tasks[i+1:]...)
+			q.uploading++
+			if q.isLarge(task) {
+				q.largeUploading++
+			}
+			// there might be more tasks for the other waiting workers
+			if len(q.tasks) > 0 {
+				q.signal()
+			}
+			q.mu.Unlock()
+			return task, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.notify:
+		}
+	}
+}
+
+// done must be called when the upload of a popped task finishes.
+func (q *uploadQueue) done(task *uploadTask) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.uploading--
+	if q.isLarge(task) {
+		q.largeUploading--
+	}
+	q.signal()
+}
+
+// next returns the index of the task to be uploaded next, or -1.
+func (q *uploadQueue) next() int {
+	now := q.now()
+	best := -1
+	for i, task := range q.tasks {
+		if q.isLarge(task) && q.largeUploading >= q.maxLargeUploads {
+			continue
+		}
+		if best < 0 || q.before(task, q.tasks[best], now) {
+			best = i
+		}
+	}
+	return best
+}
+
+func (q *uploadQueue) before(a, b *uploadTask, now time.Time) bool {
+	if pa, pb := q.effectivePriority(a, now), q.effectivePriority(b, now); pa != pb {
+		return pa > pb
+	}
+	if a.size != b.size {
+		return a.size < b.size
+	}
+	return a.queued.Before(b.queued)
+}
+
+// effectivePriority grows with the waiting time, so no file waits forever.
+func (q *uploadQueue) effectivePriority(task *uploadTask, now time.Time) int {
+	return task.priority + int(now.Sub(task.queued)/q.aging)
+}
+
+func (q *uploadQueue) isLarge(task *uploadTask) bool {
+	return task.size > q.largeFileSize
+}
+
+func (q *uploadQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// QueueStatus is a snapshot of the upload queue.
+type QueueStatus struct {
+	Queued      int
+	QueuedBytes int64
+	Uploading   int
+	// ByPriority counts the queued files by their effective priority
+	ByPriority map[int]int
+	// LongestWait is the waiting time of the oldest queued file
+	LongestWait time.Duration
+}
+
+func (s QueueStatus) String() string {
+	priorities := []int{}
+	for p := range s.ByPriority {
+		priorities = append(priorities, p)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	parts := []string{}
+	for _, p := range priorities {
+		parts = append(parts, fmt.Sprintf("%d:%d", p, s.ByPriority[p]))
+	}
+
+	return fmt.Sprintf("queued=%d(%d bytes) uploading=%d longest_wait=%v priorities=[%s]",
+		s.Queued, s.QueuedBytes, s.Uploading, s.LongestWait.Round(time.Second), strings.Join(parts, " "))
+}
+
+func (q *uploadQueue) status() QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	status := QueueStatus{
+		Queued:     len(q.tasks),
+		Uploading:  q.uploading,
+		ByPriority: map[int]int{},
+	}
+	for _, task := range q.tasks {
+		status.QueuedBytes += task.size
+		status.ByPriority[q.effectivePriority(task, now)]++
+		if wait := now.Sub(task.queued); wait > status.LongestWait {
+			status.LongestWait = wait
+		}
+	}
+
+	return status
+}
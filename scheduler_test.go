@@ -0,0 +1,109 @@
+package alcatraz
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUploadQueue(t *testing.T) {
+	queue, err := newUploadQueue(Scheduling{
+		Rules: []PriorityRule{
+			{Pattern: "*.urgent", Priority: 10},
+			{MinAge: time.Hour, Priority: 5},
+		},
+		LargeFileSize:   100,
+		MaxLargeUploads: 1,
+		Aging:           time.Minute,
+	}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	queue.now = func() time.Time { return now }
+
+	folder := &watchedFolder{Folder: Folder{Path: "/data", Priority: 1}}
+	task := func(name string, size int64, age time.Duration) *uploadTask {
+		return &uploadTask{folder: folder, filename: filepath.Join("/data", name), size: size, modTime: now.Add(-age)}
+	}
+
+	huge1 := task("huge1.bin", 1000, 0)
+	huge2 := task("huge2.bin", 2000, 0)
+	small := task("small.txt", 10, 0)
+	old := task("old.txt", 50, 2*time.Hour)
+	urgent := task("a.urgent", 60, 0)
+	for _, file := range []*uploadTask{huge1, huge2, small, old, urgent} {
+		queue.push(file)
+	}
+
+	if urgent.priority != 11 || old.priority != 6 || small.priority != 1 {
+		t.Errorf("wrong priorities: urgent=%d old=%d small=%d", urgent.priority, old.priority, small.priority)
+	}
+
+	ctx := context.Background()
+	pop := func() *uploadTask {
+		task, err := queue.pop(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return task
+	}
+
+	if got := pop(); got != urgent {
+		t.Errorf("expected urgent file first, got %q", got.filename)
+	}
+	if got := pop(); got != old {
+		t.Errorf("expected old file second, got %q", got.filename)
+	}
+	if got := pop(); got != small {
+		t.Errorf("expected small file third, got %q", got.filename)
+	}
+	large := pop()
+	if large != huge1 {
+		t.Errorf("expected smaller huge file, got %q", large.filename)
+	}
+
+	// the second large file has to wait for the first one
+	status := queue.status()
+	if status.Queued != 1 || status.Uploading != 4 || status.QueuedBytes != 2000 {
+		t.Errorf("unexpected status %v", status)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := queue.pop(timeout); err == nil {
+		t.Error("only large files are queued, pop should block")
+	}
+
+	// small files bypass the waiting large one
+	queue.push(task("small2.txt", 10, 0))
+	if got := pop(); got.filename != "/data/small2.txt" {
+		t.Errorf("expected small file to bypass, got %q", got.filename)
+	}
+
+	queue.done(large)
+	if got := pop(); got != huge2 {
+		t.Errorf("expected the second huge file, got %q", got.filename)
+	}
+}
+
+func TestUploadQueueAging(t *testing.T) {
+	queue, _ := newUploadQueue(Scheduling{Aging: time.Minute}, 2)
+	now := time.Now()
+	queue.now = func() time.Time { return now }
+
+	folder := &watchedFolder{Folder: Folder{Path: "/data"}}
+	starving := &uploadTask{folder: folder, filename: "/data/low", size: 10}
+	queue.push(starving)
+
+	// 5 minutes later, a file with priority 3 comes
+	now = now.Add(5 * time.Minute)
+	high := &uploadTask{folder: &watchedFolder{Folder: Folder{Path: "/data", Priority: 3}}, filename: "/data/high", size: 1}
+	queue.push(high)
+
+	if got, _ := queue.pop(context.Background()); got != starving {
+		t.Errorf("waiting file should have aged above the new one, got %q", got.filename)
+	}
+}
@@ -0,0 +1,244 @@
+package alcatraz
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Scrubbing verifies the stored files against the hashes in the index, to find the files
+// damaged on the disk. The corrupt files are moved to the quarantine.
+type Scrubbing struct {
+	// Interval is how often every file is verified, 0 disables the background scrubbing
+	Interval time.Duration `yaml:"interval"`
+	// Bandwidth limits the reading speed, the schedules could pause the scrubbing
+	Bandwidth Bandwidth `yaml:"bandwidth"`
+}
+
+// quarantineDir keeps the corrupt files, by owner, name and version.
+const quarantineDir = "quarantine"
+
+// scrubMetrics are published with the other metrics, they count since the server started.
+var scrubMetrics = expvar.NewMap("scrub")
+
+// scrubResult counts the files of a scrubbing run.
+type scrubResult struct {
+	Verified int
+	Bytes    int64
+	Corrupt  int
+	Missing  int
+	// Disks are the copies by storage folder, when the files are mirrored
+	Disks []DiskHealth
+}
+
+// scrub verifies the files, which were not verified in the last interval, or all of them. The
+// mirrored files are repaired from a healthy copy, they are quarantined only without one.
+func (s *Server) scrub(ctx context.Context, limiter *rateLimiter, all bool) (scrubResult, error) {
+	result, disks := scrubResult{}, map[string]*DiskHealth{}
+	records, err := s.index.all()
+	if err != nil {
+		return result, fmt.Errorf("failed to read index: %v", err)
+	}
+
+	for _, rec := range records {
+		last := rec.Verified
+		if last.IsZero() {
+			last = rec.Uploaded
+		}
+		if rec.Hash == "" || (!all && time.Since(last) < s.Scrubbing.Interval) {
+			continue
+		}
+		loc, ok := s.ownerLocation(rec.Owner)
+		if !ok {
+			continue
+		}
+		loc.name = rec.Name
+		filename := loc.path(s.StoragePath)
+		if !rec.current {
+			filename = filepath.Join(s.versionsDir(rec.Owner, rec.Name), rec.Version)
+		}
+
+		if rec.current && len(s.mirrorDirs) > 0 {
+			healthy, err := s.checkCopies(ctx, loc, rec, limiter, true, disks)
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			if err != nil {
+				log.Errorf("Scrubbing: failed to check the copies of %q of %q: %v", rec.Name, rec.Owner, err)
+				continue
+			}
+			if healthy {
+				result.Verified++
+				result.Bytes += rec.Size
+				scrubMetrics.Add("verified_files", 1)
+				scrubMetrics.Add("verified_bytes", rec.Size)
+				if err := s.index.verify(rec, time.Now()); err != nil {
+					log.Errorf("Scrubbing: failed to update index of %q: %v", rec.Name, err)
+				}
+				continue
+			}
+			// no copy is healthy, the stored file is handled as without mirrors
+		}
+
+		hash, err := hashFile(ctx, filename, limiter)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if os.IsNotExist(err) {
+			log.Warnf("Scrubbing: %q version %s of %q is missing, reindex is needed", rec.Name, rec.Version, rec.Owner)
+			scrubMetrics.Add("missing_files", 1)
+			result.Missing++
+			continue
+		} else if err != nil {
+			log.Errorf("Scrubbing: failed to read %q of %q: %v", rec.Name, rec.Owner, err)
+			continue
+		}
+		result.Verified++
+		result.Bytes += rec.Size
+		scrubMetrics.Add("verified_files", 1)
+		scrubMetrics.Add("verified_bytes", rec.Size)
+
+		if hash == rec.Hash {
+			if err := s.index.verify(rec, time.Now()); err != nil {
+				log.Errorf("Scrubbing: failed to update index of %q: %v", rec.Name, err)
+			}
+			continue
+		}
+
+		quarantined, err := s.quarantine(loc, rec, filename, hash)
+		if err != nil {
+			log.Errorf("Scrubbing: failed to quarantine %q of %q: %v", rec.Name, rec.Owner, err)
+		}
+		if quarantined {
+			scrubMetrics.Add("corrupt_files", 1)
+			result.Corrupt++
+			if rec.current {
+				s.syncMirrors(rec.Owner, rec.Name)
+			}
+		}
+	}
+	if len(s.mirrorDirs) > 0 {
+		result.Disks = s.sortedDisks(disks)
+	}
+
+	last := expvar.String{}
+	last.Set(time.Now().Format(time.RFC3339))
+	scrubMetrics.Set("last_run", &last)
+	return result, nil
+}
+
+// quarantine moves the corrupt file, with its metadata, out of the storage and removes it from
+// the index. Files replaced since they were hashed are left, they are verified on the next run.
+func (s *Server) quarantine(loc location, rec fileRecord, filename, hash string) (bool, error) {
+	info, err := os.Stat(filename)
+	if err != nil || (rec.current && versionID(info.ModTime()) != rec.Version) {
+		return false, nil
+	}
+
+	dir := filepath.Join(s.StoragePath, internalDir, quarantineDir, rec.Owner, filepath.FromSlash(rec.Name), rec.Version)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return false, fmt.Errorf("failed to create directories: %v", err)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "record.json"), data, 0644); err != nil {
+		return false, err
+	}
+
+	metadata := filename + ".json"
+	if rec.current {
+		metadata = s.metadataPath(rec.Owner, rec.Name)
+	}
+	if err := os.Rename(metadata, filepath.Join(dir, trashMetadata)); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to move metadata: %v", err)
+	}
+	if err := os.Rename(filename, filepath.Join(dir, trashData)); err != nil {
+		return false, err
+	}
+
+	if err := s.index.remove(rec); err != nil {
+		log.Errorf("Failed to remove %q from index: %v", rec.Name, err)
+	}
+	if rec.current {
+		s.usage.add(loc, -rec.Size, -1)
+	} else {
+		s.usage.add(loc, -rec.Size, 0)
+	}
+
+	s.audit("corrupt file quarantined", "", logrus.Fields{
+		"owner":    rec.Owner,
+		"name":     rec.Name,
+		"version":  rec.Version,
+		"expected": rec.Hash,
+		"actual":   hash,
+	})
+	return true, nil
+}
+
+// scrubPeriodically verifies the files due by the interval, until ctx is done.
+func (s *Server) scrubPeriodically(ctx context.Context) {
+	if s.Scrubbing.Interval == 0 {
+		return
+	}
+	limiter, _ := newRateLimiter(s.Scrubbing.Bandwidth)
+
+	// the files are checked more often than the interval, so they are verified in time
+	check := s.Scrubbing.Interval / 10
+	if check > time.Hour {
+		check = time.Hour
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+	for {
+		result, err := s.scrub(ctx, limiter, false)
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("Scrubbing failed: %v", err)
+		} else if result.Verified > 0 {
+			log.Infof("Scrubbing verified %d files, %d bytes, %d corrupt, %d missing",
+				result.Verified, result.Bytes, result.Corrupt, result.Missing)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scrub verifies the files of the stopped server, the ones due by the scrubbing interval or
+// all of them. It returns an error, if any file is corrupt or missing.
+func Scrub(config ServerConfig, all bool) error {
+	s, err := openStorage(config)
+	if err != nil {
+		return err
+	}
+	defer s.index.Close()
+
+	limiter, err := newRateLimiter(config.Scrubbing.Bandwidth)
+	if err != nil {
+		return fmt.Errorf("invalid scrub bandwidth: %v", err)
+	}
+
+	result, err := s.scrub(context.Background(), limiter, all)
+	if err != nil {
+		return err
+	}
+	log.Infof("Verified %d files, %d bytes, %d corrupt, %d missing", result.Verified, result.Bytes, result.Corrupt, result.Missing)
+	for _, health := range result.Disks {
+		log.Infof("%s: %d copies, %d missing, %d corrupt, %d repaired", health.Dir, health.Files, health.Missing, health.Corrupt, health.Repaired)
+	}
+	if result.Corrupt > 0 || result.Missing > 0 {
+		return fmt.Errorf("found %d corrupt and %d missing files", result.Corrupt, result.Missing)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package alcatraz
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScrub(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Malcolm", nil)
+
+	storage := filepath.Join(dir, "storage")
+	server, err := NewServer(ServerConfig{
+		StoragePath:  storage,
+		Certificates: serverFiles,
+		Clients: []ClientACL{{
+			Identity:    "Malcolm",
+			Permissions: []Permission{PermissionUpload, PermissionList},
+		}},
+		Scrubbing: Scrubbing{Interval: time.Hour},
+		LogLevel:  "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := client.Upload(ctx, name, strings.NewReader("content of "+name), WithMetadata(map[string]string{"name": name})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the new uploads are not due yet
+	if result, err := server.scrub(ctx, nil, false); err != nil || result.Verified != 0 {
+		t.Errorf("expected nothing to verify, got %+v, %v", result, err)
+	}
+
+	// a bit flip keeps the size and the modification time
+	corrupt := filepath.Join(storage, "Malcolm", "b.txt")
+	info, err := os.Stat(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(corrupt, []byte("content of b.txU"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(corrupt, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(storage, "Malcolm", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.scrub(ctx, nil, true)
+	if err != nil || result.Verified != 2 || result.Corrupt != 1 || result.Missing != 1 {
+		t.Errorf("expected one corrupt and one missing file, got %+v, %v", result, err)
+	}
+
+	quarantined := filepath.Join(storage, internalDir, quarantineDir, "Malcolm", "b.txt", versionID(info.ModTime()))
+	for _, file := range []string{trashData, trashMetadata, "record.json"} {
+		if _, err := os.Stat(filepath.Join(quarantined, file)); err != nil {
+			t.Errorf("expected %s in the quarantine: %v", file, err)
+		}
+	}
+	if files, _ := client.List(ctx, ""); len(files) != 2 || files[0].Name != "a.txt" {
+		t.Errorf("expected the corrupt file to be removed from the listing, got %+v", files)
+	}
+	if usage, _ := client.Quota(ctx, ""); usage.UsedFiles != 2 {
+		t.Errorf("expected the corrupt file to be freed, got %+v", usage)
+	}
+
+	// the verified files are not due until the interval passes
+	if result, err := server.scrub(ctx, nil, false); err != nil || result.Verified != 0 {
+		t.Errorf("expected the verified file to be skipped, got %+v, %v", result, err)
+	}
+	if rec, ok, _ := server.index.get("Malcolm", "a.txt", ""); !ok || rec.Verified.IsZero() {
+		t.Errorf("expected the verification time to be stored, got %+v", rec)
+	}
+}
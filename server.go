@@ -6,10 +6,15 @@ import (
 	"crypto/tls"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/avalchev94/alcatraz/ca"
 	"github.com/avalchev94/alcatraz/pb"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/sirupsen/logrus"
@@ -17,19 +22,91 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
 )
 
 type ServerConfig struct {
-	Port           int
-	StoragePath    string
-	Certificates   CertFiles
-	AllowedClients map[string]bool
-	LogLevel       string
+	Port         int         `yaml:"port"`
+	StoragePath  string      `yaml:"storage"`
+	Certificates CertFiles   `yaml:"certificates"`
+	Clients      []ClientACL `yaml:"clients"`
+	// Limits are the default limits of the clients
+	Limits Limits `yaml:"limits"`
+	// Spaces are folders shared by several clients
+	Spaces []Space `yaml:"spaces"`
+	// Policy restricts the clients by method and path, on top of their permissions
+	Policy Policy `yaml:"policy"`
+	// Identity is how the clients are identified by their certificates, default is the common name
+	Identity Identity `yaml:"identity"`
+	// AllowedClients is the old way to allow clients, they get all permissions
+	AllowedClients map[string]bool `yaml:"-"`
+	// CRL is a certificate revocation list file, signed by the certificate authority
+	CRL string `yaml:"crl"`
+	// CRLRefresh is how often the CRL file is checked for changes, default is 1 minute
+	CRLRefresh time.Duration `yaml:"crl_refresh"`
+	OCSP       OCSP          `yaml:"ocsp"`
+	// Versioning keeps the replaced files, it's disabled by default
+	Versioning Versioning `yaml:"versioning"`
+	// Trash keeps the deleted files
+	Trash Trash `yaml:"trash"`
+	// Mirroring copies the files to several storage folders
+	Mirroring Mirroring `yaml:"mirror"`
+	// Replication forwards the changes to the secondary servers
+	Replication Replication `yaml:"replication"`
+	// Relay forwards the uploads to an upstream server
+	Relay Relay `yaml:"relay"`
+	// Scrubbing verifies the stored files in the background, it's disabled by default
+	Scrubbing Scrubbing `yaml:"scrub"`
+	// Metrics is the HTTP address of the metrics, like "localhost:9090", empty disables them
+	Metrics string `yaml:"metrics"`
+	// Enrollment signs the certificates of new clients, with one-time tokens
+	Enrollment Enrollment `yaml:"enrollment"`
+	// AuditLog is a file for the security events, default is the standard log
+	AuditLog string `yaml:"audit_log"`
+	LogLevel string `yaml:"log"`
+}
+
+// LoadServerConfig fills config with the values from a YAML file.
+// Settings missing in the file are left unchanged.
+func LoadServerConfig(filename string, config *ServerConfig) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("failed to parse config file %q: %v", filename, err)
+	}
+
+	return nil
 }
 
 type Server struct {
 	ServerConfig
-	creds credentials.TransportCredentials
+	creds    credentials.TransportCredentials
+	auditLog *logrus.Logger
+	enrollMu sync.Mutex
+	// renameMu serializes the moves and restores, which check that the destination doesn't exist
+	renameMu sync.Mutex
+	usage    *usageIndex
+	holds    *holdList
+	index    *metaIndex
+	// mirrorDirs are the storage folders after the first one, with the copies of the files
+	mirrorDirs []string
+	// replicaWake wakes the senders of the secondaries by address, and of the relay
+	replicaWake map[string]chan struct{}
+	limiters    *limiterSet
+
+	// mu guards the state which could be reloaded
+	mu       sync.RWMutex
+	identity Identity
+	acl      accessList
+	spaces   spaceList
+	policy   *policy
+	tls      *serverTLS
+	crl      *revocationList
+	ocsp     *ocspClient
 }
 
 func NewServer(config ServerConfig) (*Server, error) {
@@ -40,36 +117,157 @@ func NewServer(config ServerConfig) (*Server, error) {
 	}
 	logrus.SetLevel(lvl)
 
-	// Load the client certificate
-	certificate, err := config.Certificates.getCertificate()
+	// validate the clients
+	if err := validateIdentity(config.Identity); err != nil {
+		return nil, err
+	}
+	if err := config.Limits.validate(); err != nil {
+		return nil, fmt.Errorf("invalid limits: %v", err)
+	}
+	if err := config.Versioning.validate(); err != nil {
+		return nil, fmt.Errorf("invalid versioning: %v", err)
+	}
+	if config.Trash.Expiry < 0 {
+		return nil, fmt.Errorf("invalid trash: expiry can't be negative")
+	}
+	if err := config.Replication.validate(); err != nil {
+		return nil, fmt.Errorf("invalid replication: %v", err)
+	}
+	if err := config.Relay.validate(config.Replication); err != nil {
+		return nil, fmt.Errorf("invalid relay: %v", err)
+	}
+	if config.Scrubbing.Interval < 0 {
+		return nil, fmt.Errorf("invalid scrub: interval can't be negative")
+	}
+	if _, err := newRateLimiter(config.Scrubbing.Bandwidth); err != nil {
+		return nil, fmt.Errorf("invalid scrub: %v", err)
+	}
+	acl, err := newAccessList(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clients: %v", err)
+	}
+	if len(acl) == 0 {
+		log.Warn("No clients are configured, all requests will be rejected")
+	}
+	spaces, err := newSpaces(config, acl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spaces: %v", err)
+	}
+	policy, err := newPolicy(config.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %v", err)
+	}
+
+	// Load the certificate and the certificate authority
+	serverTLS, err := loadServerTLS(config.Certificates)
 	if err != nil {
-		return nil, fmt.Errorf("could not get certificate: %v", err)
+		return nil, err
 	}
 
-	// Get a certificate pool with the certificate authority
-	certPool, err := config.Certificates.getCertAuthPool()
+	// Load the revoked certificates
+	var crl *revocationList
+	if config.CRL != "" {
+		if crl, err = newRevocationList(config.CRL, config.Certificates.CertAuth); err != nil {
+			return nil, err
+		}
+	}
+
+	ocspClient, err := newOCSPClient(config.OCSP, config.Certificates.CertAuth)
 	if err != nil {
-		return nil, fmt.Errorf("could not get cert auth pool: %v", err)
+		return nil, fmt.Errorf("invalid ocsp: %v", err)
 	}
 
-	// Create the TLS credentials
-	creds := credentials.NewTLS(&tls.Config{
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		Certificates: []tls.Certificate{certificate},
-		ClientCAs:    certPool,
-	})
+	if config.Enrollment.RequestsPerMinute < 0 {
+		return nil, fmt.Errorf("invalid enrollment: requests per minute can't be negative")
+	}
+	if config.Enrollment.enabled() {
+		authority, err := ca.Open(config.Enrollment.CA)
+		if err != nil {
+			return nil, fmt.Errorf("invalid enrollment: %v", err)
+		}
+		authority.Close()
+	}
 
-	// finally, create the storage folder, if not exist
-	if _, err := os.Stat(config.StoragePath); os.IsNotExist(err) {
-		if err := os.MkdirAll(config.StoragePath, os.ModePerm); err != nil {
-			return nil, fmt.Errorf("failed to create storage folder %q: %v", config.StoragePath, err)
+	auditLog, err := newAuditLogger(config.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	// finally, create the storage folders, if not exist
+	mirrorDirs, err := splitStorage(&config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage: %v", err)
+	}
+	if err := restoreMainDir(config.StoragePath, mirrorDirs); err != nil {
+		return nil, err
+	}
+	for _, dir := range append([]string{config.StoragePath}, mirrorDirs...) {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return nil, fmt.Errorf("failed to create storage folder %q: %v", dir, err)
+			}
 		}
 	}
 
-	return &Server{
+	usage, err := loadUsageIndex(config.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	holds, err := loadHoldList(config.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	index, created, err := openIndex(config.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	usage.index = index
+	if err := markMirrors(mirrorDirs); err != nil {
+		index.Close()
+		return nil, fmt.Errorf("failed to mark mirrors: %v", err)
+	}
+
+	s := &Server{
 		ServerConfig: config,
-		creds:        creds,
-	}, nil
+		usage:        usage,
+		holds:        holds,
+		index:        index,
+		mirrorDirs:   mirrorDirs,
+		limiters:     newLimiterSet(),
+		auditLog:     auditLog,
+		identity:     config.Identity,
+		acl:          acl,
+		spaces:       spaces,
+		policy:       policy,
+		tls:          serverTLS,
+		crl:          crl,
+		ocsp:         ocspClient,
+	}
+
+	// the files stored before the index existed are indexed once
+	if created {
+		if err := s.reindex(); err != nil {
+			index.Close()
+			return nil, err
+		}
+	}
+	if err := index.openQueues(config.replicaQueues()); err != nil {
+		index.Close()
+		return nil, fmt.Errorf("failed to open replication queues: %v", err)
+	}
+	s.replicaWake = map[string]chan struct{}{}
+	for _, queue := range config.replicaQueues() {
+		s.replicaWake[queue] = make(chan struct{}, 1)
+	}
+	s.releaseHeld()
+
+	// Create the TLS credentials, the certificates are taken on every handshake, so they could be reloaded
+	s.creds = credentials.NewTLS(&tls.Config{
+		ClientAuth:         s.clientAuth(),
+		GetConfigForClient: s.tlsConfigForClient,
+	})
+
+	return s, nil
 }
 
 func (s *Server) Run(ctx context.Context) error {
@@ -78,8 +276,7 @@ func (s *Server) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to listen to port %d: %v", s.Port, err)
 	}
 
-	server := grpc.NewServer(grpc.Creds(s.creds), grpc.StreamInterceptor(s.authClient))
-	pb.RegisterAlcatrazServer(server, s)
+	server := s.newGRPCServer()
 
 	log.Infof("Server listening on port %d...", s.Port)
 	go func() {
@@ -88,39 +285,172 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 	}()
 
+	go s.refreshCRL(ctx)
+	go s.refreshStaple(ctx)
+	go s.pruneVersions(ctx)
+	go s.emptyTrashPeriodically(ctx)
+	go s.scrubPeriodically(ctx)
+	go s.serveMetrics(ctx)
+	s.replicateAll(ctx)
+
 	<-ctx.Done()
 
 	log.Info("Gracefully stoping server...")
 	server.GracefulStop()
 	log.Info("Server stopped")
 
-	return nil
+	return s.index.Close()
+}
+
+// newGRPCServer creates the gRPC server with the TLS credentials, the authorization and the limits.
+func (s *Server) newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.Creds(s.creds),
+		grpc.ChainStreamInterceptor(s.authClient, s.limitStream),
+		grpc.ChainUnaryInterceptor(s.authClientUnary, s.limitUnary),
+	)
+	pb.RegisterAlcatrazServer(server, s)
+	return server
+}
+
+// methodPermissions are the permissions required by the RPCs, empty one is allowed to all clients
+var methodPermissions = map[string]Permission{
+	"/pb.Alcatraz/UploadFile":   PermissionUpload,
+	"/pb.Alcatraz/DownloadFile": PermissionDownload,
+	"/pb.Alcatraz/ListFiles":    PermissionList,
+	"/pb.Alcatraz/StatFile":     PermissionList,
+	"/pb.Alcatraz/Spaces":       "",
+	"/pb.Alcatraz/Quota":        "",
+	"/pb.Alcatraz/LegalHold":    PermissionAdmin,
+	"/pb.Alcatraz/DeleteFile":   PermissionDelete,
+	"/pb.Alcatraz/MoveFile":     PermissionDelete,
+	"/pb.Alcatraz/Undelete":     PermissionDelete,
+	"/pb.Alcatraz/ListTrash":    PermissionDelete,
+	"/pb.Alcatraz/Renew":        "",
 }
 
-func (s *Server) authClient(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	name, err := getCommonNameFromCtx(ss.Context())
+func (s *Server) authClient(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	name, err := s.authorize(ss.Context(), info.FullMethod)
 	if err != nil {
-		return grpc.Errorf(codes.Unauthenticated, "failed to retrieve client common name: %v", err)
+		return err
 	}
+	ss = withIdentity(ss, name)
 
-	if !s.AllowedClients[name] {
-		return grpc.Errorf(codes.Unauthenticated, "common name is not allowed")
+	// the path comes with the first message
+	if pathMethods[info.FullMethod] {
+		ss = &policyStream{ServerStream: ss, server: s, identity: name, method: info.FullMethod}
+	} else if err := s.checkPolicy(name, info.FullMethod, "", false); err != nil {
+		return err
 	}
 
 	return handler(srv, ss)
 }
 
+func (s *Server) authClientUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	// new clients have no certificate yet, they present a token
+	if info.FullMethod == enrollMethod {
+		return handler(ctx, req)
+	}
+
+	name, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	target, hasPath := requestPath(req)
+	if err := s.checkPolicy(name, info.FullMethod, target, hasPath); err != nil {
+		return nil, err
+	}
+
+	return handler(context.WithValue(ctx, identityKey{}, name), req)
+}
+
+// authorize checks the client certificate and the permission for the method. It returns the client's identity.
+func (s *Server) authorize(ctx context.Context, method string) (string, error) {
+	cert, err := getPeerCertificate(ctx)
+	if err != nil {
+		return "", grpc.Errorf(codes.Unauthenticated, "failed to retrieve client certificate: %v", err)
+	}
+
+	name, err := s.identitySource().identity(cert)
+	if err != nil {
+		s.audit("client without identity rejected", "", logrus.Fields{
+			"subject": cert.Subject.String(),
+			"method":  method,
+			"error":   err.Error(),
+		})
+		return "", grpc.Errorf(codes.Unauthenticated, "failed to get client identity: %v", err)
+	}
+
+	if revokedAt, ok := s.revocationList().isRevoked(cert); ok {
+		s.audit("revoked certificate rejected", name, logrus.Fields{
+			"serial":     cert.SerialNumber.String(),
+			"revoked_at": revokedAt,
+			"method":     method,
+		})
+		return "", grpc.Errorf(codes.Unauthenticated, "certificate is revoked")
+	}
+
+	if err := s.checkOCSP(cert, name, method); err != nil {
+		return "", err
+	}
+
+	client, ok := s.accessList().lookup(name)
+	if !ok {
+		s.audit("unknown client rejected", name, logrus.Fields{"method": method})
+		return "", grpc.Errorf(codes.Unauthenticated, "client %q is not allowed", name)
+	}
+
+	if permission, ok := methodPermissions[method]; !ok || (permission != "" && !client.Can(permission)) {
+		s.audit("permission denied", name, logrus.Fields{"method": method})
+		return "", grpc.Errorf(codes.PermissionDenied, "%s is not allowed", method)
+	}
+
+	return name, nil
+}
+
+// requestClient returns the client of the authorized request. A reload could remove the client
+// while the request runs, then the request fails.
+func (s *Server) requestClient(identity string) (*ClientACL, error) {
+	acl := s.accessList()
+	client, ok := acl.lookup(identity)
+	if !ok && acl != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "client %q is not allowed anymore", identity)
+	}
+	return client, nil
+}
+
 func (s *Server) UploadFile(stream pb.Alcatraz_UploadFileServer) error {
-	client, _ := getCommonNameFromCtx(stream.Context())
+	client := s.identityFromCtx(stream.Context())
 
-	filename, err := s.recvFilename(stream)
+	header, err := s.recvHeader(stream)
 	if err != nil {
+		// rejected by the policy, or the stream failed
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
 		return grpc.Errorf(codes.InvalidArgument, "failed to recieve metadata: %v", err)
 	}
+	loc, err := s.locateAs(client, header.GetOwner(), header.GetName(), true)
+	if err != nil {
+		return err
+	}
+	if loc.name == "" {
+		return grpc.Errorf(codes.InvalidArgument, "expected filename in space")
+	}
+	filename := header.GetName()
 	log.Debugf("Client [%s]: started file %q upload..", client, filename)
 
+	// WORM files are never replaced, unless the old one is kept as version
+	if loc.worm.Enabled && !s.Versioning.Enabled {
+		if _, err := os.Stat(loc.path(s.StoragePath)); err == nil {
+			s.audit("worm overwrite rejected", client, logrus.Fields{"name": filename})
+			return grpc.Errorf(codes.AlreadyExists, "file %q already exists and can't be replaced", filename)
+		}
+	}
+
 	// create all sub-directories for the file
-	fullname := filepath.Join(s.StoragePath, client, filename)
+	fullname := loc.path(s.StoragePath)
 	dir := filepath.Dir(fullname)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
@@ -128,23 +458,65 @@ func (s *Server) UploadFile(stream pb.Alcatraz_UploadFileServer) error {
 		}
 	}
 
-	file, err := os.OpenFile(fullname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	// the quota is checked with the declared size up front, and while the chunks are written
+	old := int64(-1)
+	if info, err := os.Stat(fullname); err == nil {
+		old = info.Size()
+	}
+	reserved, err := s.usage.reserve(loc, old, s.Versioning.Enabled)
+	if err != nil {
+		return err
+	}
+	if err := reserved.grow(header.GetSize()); err != nil {
+		reserved.release()
+		return err
+	}
+
+	// the data is written to a temp file, which replaces the file when the upload is verified
+	file, err := s.tempFile()
 	if err != nil {
+		reserved.release()
 		return grpc.Errorf(codes.Internal, "failed to create temp file: %v", err)
 	}
 
-	// that's a little bit tricky, if success stays false, the file will be deleted
-	// if it's changed to true, file will be closed
+	// that's a little bit tricky, if success stays false, the temp file will be deleted
+	// if it's changed to true, it's already renamed
 	success := false
 	defer func() {
-		if success {
-			file.Close()
-		} else {
+		file.Close()
+		if !success {
 			os.Remove(file.Name())
+			reserved.release()
 		}
 	}()
 
+	// the decompressed data can't outgrow the declared size or the limit
+	acl, err := s.requestClient(client)
+	if err != nil {
+		return err
+	}
+	limit, err := acl.Limits.merge(s.Limits).sizeLimit(header.GetSize())
+	if err != nil {
+		return err
+	}
+	check := func(total int64) error {
+		if total > limit {
+			if header.GetSize() >= 0 {
+				return grpc.Errorf(codes.DataLoss, "recieved more than the declared %d bytes", limit)
+			}
+			return grpc.Errorf(codes.ResourceExhausted, "file is larger than the allowed %d bytes", limit)
+		}
+		return reserved.grow(total)
+	}
+
 	hash := sha256.New()
+	writer, err := newChunkWriter(io.MultiWriter(file, hash), header.GetCompression(), check)
+	if err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	defer writer.Close()
+
+	written, verified := int64(0), ""
 	for {
 		msg, err := stream.Recv()
 		if err != nil {
@@ -155,40 +527,85 @@ func (s *Server) UploadFile(stream pb.Alcatraz_UploadFileServer) error {
 		chunk := msg.GetChunk()
 		if chunk == nil {
 			// hash is always the last message, verify it
+			written, err = writer.Close()
+			if err != nil {
+				log.Errorf("Client [%s]: file upload failed on write with error: %v", client, err)
+				// quota errors are sent as they are
+				if _, ok := status.FromError(err); ok {
+					return err
+				}
+				return grpc.Errorf(codes.InvalidArgument, "failed to write file: %v", err)
+			}
+			if header.GetSize() >= 0 && written != header.GetSize() {
+				log.Errorf("Client [%s]: file upload failed because size %d is not the declared %d", client, written, header.GetSize())
+				return grpc.Errorf(codes.DataLoss, "recieved %d bytes, declared %d", written, header.GetSize())
+			}
 			if hex.EncodeToString(hash.Sum(nil)) != msg.GetHash() {
 				log.Errorf("Client [%s]: file upload failed becase hashes are not equal", client)
 				return grpc.Errorf(codes.DataLoss, "hashes are not equal")
 			}
+			verified = msg.GetHash()
 			break
 		}
 
-		if _, err := hash.Write(chunk); err != nil {
-			log.Errorf("Client [%s]: file upload failed on hash write with error: %v", client, err)
-			return grpc.Errorf(codes.Internal, "failed to write to hash: %v", err)
+		if err := writer.Write(chunk); err != nil {
+			log.Errorf("Client [%s]: file upload failed on file write with error: %v", client, err)
+			if _, ok := status.FromError(err); ok {
+				return err
+			}
+			return grpc.Errorf(codes.Internal, "failed to write file: %v", err)
 		}
+	}
 
-		if _, err := file.Write(chunk); err != nil {
-			log.Errorf("Client [%s]: file upload failed on file write with error: %v", client, err)
-			return grpc.Errorf(codes.Internal, "failed to write to hash: %v", err)
+	if err := file.Close(); err != nil {
+		log.Errorf("Client [%s]: file upload failed on close with error: %v", client, err)
+		return grpc.Errorf(codes.Internal, "failed to write file: %v", err)
+	}
+	rec := fileRecord{Hash: verified, Identity: client, Address: peerAddress(stream.Context())}
+	if header.GetOwner() != "" && header.GetUploadedBy() != "" {
+		// replicated by the primary, the address is the primary's
+		rec.Identity = header.GetUploadedBy()
+	}
+	if err := s.commitFile(loc, file.Name(), header.GetMetadata(), rec); err != nil {
+		log.Errorf("Client [%s]: failed to store %q: %v", client, filename, err)
+		if _, ok := status.FromError(err); ok {
+			s.audit("worm overwrite rejected", client, logrus.Fields{"name": filename})
+			return err
 		}
+		return grpc.Errorf(codes.Internal, "failed to store file: %v", err)
 	}
 
-	// change success to true, we want to close the file, not delete it!
+	// change success to true, the temp file is renamed, we don't want to delete it!
 	success = true
+	reserved.commit(written)
+	if loc.worm.Enabled && old >= 0 {
+		s.audit("worm file versioned", client, logrus.Fields{"name": filename})
+	}
 	log.Debugf("Client [%s]: file with name %q was uploaded", client, filename)
 
 	return stream.SendAndClose(&empty.Empty{})
 }
 
-func (s *Server) recvFilename(stream pb.Alcatraz_UploadFileServer) (string, error) {
+// recvHeader recieves the first message, older clients send only the name.
+func (s *Server) recvHeader(stream pb.Alcatraz_UploadFileServer) (*pb.Header, error) {
 	msg, err := stream.Recv()
 	if err != nil {
-		return "", fmt.Errorf("failed to recieve msg from stream: %v", err)
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to recieve msg from stream: %v", err)
+	}
+
+	header := msg.GetHeader()
+	if header == nil {
+		header = &pb.Header{Name: msg.GetName(), Size: -1}
 	}
 
-	if msg.GetName() == "" {
-		return "", fmt.Errorf("expected filename")
+	// the name must not escape the client's folder
+	header.Name = cleanName(header.GetName())
+	if header.GetName() == "" {
+		return nil, fmt.Errorf("expected filename")
 	}
 
-	return msg.GetName(), nil
+	return header, nil
 }
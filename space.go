@@ -0,0 +1,202 @@
+package alcatraz
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// SpaceRole is what a member could do in a shared space.
+type SpaceRole string
+
+const (
+	// RoleRead allows downloading and listing the files
+	RoleRead SpaceRole = "read"
+	// RoleWrite allows also uploading
+	RoleWrite SpaceRole = "write"
+)
+
+// SpaceMember is a client with access to the space.
+type SpaceMember struct {
+	Identity string    `yaml:"identity"`
+	Role     SpaceRole `yaml:"role"`
+}
+
+// Space is a folder shared by its members. The files in it are addressed as "@name/path".
+type Space struct {
+	Name string `yaml:"name"`
+	// Storage is the space's folder, relative to the storage path. Default is "spaces/<name>".
+	Storage string        `yaml:"storage"`
+	Members []SpaceMember `yaml:"members"`
+	// Quota limits the storage used by all members together
+	Quota Quota `yaml:"quota"`
+	// WORM makes the space's files immutable
+	WORM WORM `yaml:"worm"`
+}
+
+// spacePrefix starts the names in the shared spaces.
+const spacePrefix = "@"
+
+var spaceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// spaceList is the validated list of spaces, by name.
+type spaceList map[string]*Space
+
+// newSpaces validates the spaces. Their members must be known clients and their
+// folders must not overlap with the clients' ones.
+func newSpaces(config ServerConfig, acl accessList) (spaceList, error) {
+	spaces := spaceList{}
+	storages := map[string]string{}
+	for identity, client := range acl {
+		storages[client.Storage] = fmt.Sprintf("client %q", identity)
+	}
+
+	for i, space := range config.Spaces {
+		space := space
+		if !spaceName.MatchString(space.Name) {
+			return nil, fmt.Errorf("spaces[%d]: invalid name %q", i, space.Name)
+		}
+		if _, ok := spaces[space.Name]; ok {
+			return nil, fmt.Errorf("spaces[%d]: name %q is defined more than once", i, space.Name)
+		}
+
+		if space.Quota.MaxBytes < 0 || space.Quota.MaxFiles < 0 {
+			return nil, fmt.Errorf("spaces[%d] (%q): quota can't be negative", i, space.Name)
+		}
+		if space.WORM.Retention < 0 {
+			return nil, fmt.Errorf("spaces[%d] (%q): retention can't be negative", i, space.Name)
+		}
+
+		if space.Storage == "" {
+			space.Storage = filepath.Join("spaces", space.Name)
+		}
+		storage, err := cleanStoragePath(space.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("spaces[%d] (%q): %v", i, space.Name, err)
+		}
+		for used, owner := range storages {
+			if overlaps(storage, used) {
+				return nil, fmt.Errorf("spaces[%d] (%q): storage %q overlaps with %q of %s", i, space.Name, storage, used, owner)
+			}
+		}
+		storages[storage] = fmt.Sprintf("space %q", space.Name)
+		space.Storage = storage
+
+		members := map[string]bool{}
+		for _, member := range space.Members {
+			if _, ok := acl[member.Identity]; !ok {
+				return nil, fmt.Errorf("spaces[%d] (%q): member %q is not a client", i, space.Name, member.Identity)
+			}
+			if members[member.Identity] {
+				return nil, fmt.Errorf("spaces[%d] (%q): member %q is listed more than once", i, space.Name, member.Identity)
+			}
+			members[member.Identity] = true
+
+			if member.Role != RoleRead && member.Role != RoleWrite {
+				return nil, fmt.Errorf("spaces[%d] (%q): unknown role %q of %q, expected %q or %q", i, space.Name,
+					member.Role, member.Identity, RoleRead, RoleWrite)
+			}
+		}
+
+		spaces[space.Name] = &space
+	}
+
+	return spaces, nil
+}
+
+// overlaps reports if one of the storage folders is inside the other.
+func overlaps(a, b string) bool {
+	a, b = filepath.ToSlash(a)+"/", filepath.ToSlash(b)+"/"
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// role returns the member's role in the space, false if the client is not a member.
+func (s *Space) role(identity string) (SpaceRole, bool) {
+	for _, member := range s.Members {
+		if member.Identity == identity {
+			return member.Role, true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) spaceList() spaceList {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.spaces
+}
+
+// location is a file, or folder, in the client's own folder or in a shared space.
+type location struct {
+	// owner is the client's identity or "@space", the metadata is stored by it
+	owner string
+	// storage is the folder, relative to the storage path
+	storage string
+	// name is relative to the folder, empty for the folder itself
+	name string
+	// prefix is added to the name to get the name seen by the client
+	prefix string
+	quota  Quota
+	worm   WORM
+}
+
+func (l location) path(storagePath string) string {
+	return filepath.Join(storagePath, l.storage, filepath.FromSlash(l.name))
+}
+
+// locate resolves the cleaned name to a location. Names in a shared space require membership,
+// and write role when write is set.
+func (s *Server) locate(identity, name string, write bool) (location, error) {
+	if !strings.HasPrefix(name, spacePrefix) {
+		client, err := s.requestClient(identity)
+		if err != nil {
+			return location{}, err
+		}
+		return location{owner: identity, storage: client.Storage, name: name, quota: client.Quota, worm: client.WORM}, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(name, spacePrefix), "/", 2)
+	space, ok := s.spaceList()[parts[0]]
+	role, member := RoleRead, false
+	if ok {
+		role, member = space.role(identity)
+	}
+	if !member || (write && role != RoleWrite) {
+		access := RoleRead
+		if write {
+			access = RoleWrite
+		}
+		s.audit("space access denied", identity, logrus.Fields{"space": parts[0], "access": access})
+		return location{}, grpc.Errorf(codes.PermissionDenied, "no %s access to space %q", access, parts[0])
+	}
+
+	loc := location{owner: spacePrefix + space.Name, storage: space.Storage, prefix: spacePrefix + space.Name + "/", quota: space.Quota, worm: space.WORM}
+	if len(parts) == 2 {
+		loc.name = parts[1]
+	}
+	return loc, nil
+}
+
+// Spaces returns the shared spaces of the client, with its role in them.
+func (s *Server) Spaces(ctx context.Context, _ *empty.Empty) (*pb.SpaceList, error) {
+	identity := s.identityFromCtx(ctx)
+
+	list := &pb.SpaceList{}
+	for name, space := range s.spaceList() {
+		if role, ok := space.role(identity); ok {
+			list.Spaces = append(list.Spaces, &pb.Space{Name: name, Role: string(role)})
+		}
+	}
+	sort.Slice(list.Spaces, func(i, j int) bool { return list.Spaces[i].Name < list.Spaces[j].Name })
+
+	return list, nil
+}
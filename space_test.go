@@ -0,0 +1,160 @@
+package alcatraz
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewSpaces(t *testing.T) {
+	clients := []ClientACL{{Identity: "Malcolm"}, {Identity: "Dewey"}}
+	tests := []struct {
+		spaces []Space
+		err    string
+	}{
+		{[]Space{{Name: ""}}, "invalid name"},
+		{[]Space{{Name: "a/b"}}, "invalid name"},
+		{[]Space{{Name: "team"}, {Name: "team"}}, "more than once"},
+		{[]Space{{Name: "team", Storage: "../team"}}, "inside the storage"},
+		{[]Space{{Name: "team", Storage: "Malcolm/team"}}, `overlaps with "Malcolm" of client "Malcolm"`},
+		{[]Space{{Name: "team"}, {Name: "all", Storage: "spaces"}}, `of space "team"`},
+		{[]Space{{Name: "team", Members: []SpaceMember{{Identity: "Reese", Role: RoleRead}}}}, "not a client"},
+		{[]Space{{Name: "team", Members: []SpaceMember{{Identity: "Dewey", Role: "admin"}}}}, `unknown role "admin"`},
+		{[]Space{{Name: "team", Members: []SpaceMember{{Identity: "Dewey", Role: RoleRead}, {Identity: "Dewey", Role: RoleWrite}}}}, "more than once"},
+	}
+
+	for _, test := range tests {
+		config := ServerConfig{Clients: clients, Spaces: test.spaces}
+		acl, err := newAccessList(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = newSpaces(config, acl)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error with %q, got %v", test.err, err)
+		}
+	}
+
+	config := ServerConfig{Clients: clients, Spaces: []Space{
+		{Name: "team", Members: []SpaceMember{{Identity: "Dewey", Role: RoleWrite}}},
+		{Name: "shared.docs", Storage: "docs/"},
+	}}
+	acl, _ := newAccessList(config)
+	spaces, err := newSpaces(config, acl)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if storage := spaces["team"].Storage; storage != filepath.Join("spaces", "team") {
+		t.Errorf("expected default storage, got %q", storage)
+	}
+	if storage := spaces["shared.docs"].Storage; storage != "docs" {
+		t.Errorf("expected cleaned storage, got %q", storage)
+	}
+}
+
+func TestSpaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+
+	all := []Permission{PermissionUpload, PermissionDownload, PermissionList}
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients: []ClientACL{
+			{Identity: "Malcolm", Permissions: all},
+			{Identity: "Dewey", Permissions: all},
+			{Identity: "Reese", Permissions: all},
+		},
+		Spaces: []Space{{Name: "team", Members: []SpaceMember{
+			{Identity: "Dewey", Role: RoleWrite},
+			{Identity: "Malcolm", Role: RoleRead},
+		}}},
+		LogLevel: "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	clients := map[string]*Client{}
+	for _, name := range []string{"Malcolm", "Dewey", "Reese"} {
+		_, files := ca.issue(t, name, nil)
+		client, err := NewClient(ClientConfig{Host: addr, Certificates: files, LogLevel: "info"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients[name] = client
+	}
+	ctx := context.Background()
+
+	// writers upload to the space, readers can't
+	err = clients["Dewey"].Upload(ctx, "@team/plan.txt", strings.NewReader("plan"), WithMetadata(map[string]string{"by": "Dewey"}))
+	if err != nil {
+		t.Fatalf("expected upload to the space, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "storage", "spaces", "team", "plan.txt")); err != nil {
+		t.Errorf("expected the file in the space's folder: %v", err)
+	}
+	err = clients["Malcolm"].Upload(ctx, "@team/other.txt", strings.NewReader("other"))
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for reader's upload, got %v", err)
+	}
+
+	// readers download and list, other clients can't
+	buf := &bytes.Buffer{}
+	info, err := clients["Malcolm"].Download(ctx, "@team/plan.txt", buf)
+	if err != nil {
+		t.Fatalf("expected download from the space, got %v", err)
+	}
+	if buf.String() != "plan" || info.Size != 4 || info.Metadata["by"] != "Dewey" {
+		t.Errorf("unexpected download %q, %+v", buf.String(), info)
+	}
+	files, err := clients["Malcolm"].List(ctx, "@team")
+	if err != nil || len(files) != 1 || files[0].Name != "@team/plan.txt" {
+		t.Errorf("expected @team/plan.txt, got %+v, %v", files, err)
+	}
+	if _, err := clients["Reese"].Download(ctx, "@team/plan.txt", ioutil.Discard); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for non-member, got %v", err)
+	}
+	if _, err := clients["Reese"].List(ctx, "@other"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for unknown space, got %v", err)
+	}
+
+	// the own folder stays private
+	if err := clients["Reese"].Upload(ctx, "private.txt", strings.NewReader("mine")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clients["Dewey"].Download(ctx, "private.txt", ioutil.Discard); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound in the own folder, got %v", err)
+	}
+	files, err = clients["Reese"].List(ctx, "")
+	if err != nil || len(files) != 1 || files[0].Name != "private.txt" {
+		t.Errorf("expected private.txt, got %+v, %v", files, err)
+	}
+
+	spaces, err := clients["Malcolm"].Spaces(ctx)
+	if err != nil || len(spaces) != 1 || spaces[0] != (SpaceInfo{Name: "team", Role: RoleRead}) {
+		t.Errorf("expected team space, got %+v, %v", spaces, err)
+	}
+	if spaces, _ := clients["Reese"].Spaces(ctx); len(spaces) != 0 {
+		t.Errorf("expected no spaces, got %+v", spaces)
+	}
+}
@@ -0,0 +1,260 @@
+package alcatraz
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// internalDir is the folder in the storage, where the server keeps its own data.
+const internalDir = ".alcatraz"
+
+// chunkWriter writes the recieved chunks to dst, decompressing them if needed.
+type chunkWriter struct {
+	dst      *countingWriter
+	pipe     *io.PipeWriter
+	done     chan error
+	closed   bool
+	closeErr error
+}
+
+// countingWriter counts the written bytes, check is called with the new total before every write.
+type countingWriter struct {
+	w       io.Writer
+	written int64
+	check   func(total int64) error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.check != nil {
+		if err := c.check(c.written + int64(len(p))); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+func newChunkWriter(dst io.Writer, compression pb.Compression, check func(total int64) error) (*chunkWriter, error) {
+	w := &chunkWriter{dst: &countingWriter{w: dst, check: check}}
+
+	switch compression {
+	case pb.Compression_NONE:
+	case pb.Compression_GZIP:
+		// the decompression reads from a pipe, fed by the recieved chunks
+		pr, pw := io.Pipe()
+		w.pipe = pw
+		w.done = make(chan error, 1)
+		go func() {
+			gz, err := gzip.NewReader(pr)
+			if err == nil {
+				_, err = io.Copy(w.dst, gz)
+			}
+			// unblock the writing side, if decompression fails
+			pr.CloseWithError(err)
+			w.done <- err
+		}()
+	default:
+		return nil, fmt.Errorf("unknown compression %v", compression)
+	}
+
+	return w, nil
+}
+
+func (w *chunkWriter) Write(chunk []byte) error {
+	if w.pipe != nil {
+		_, err := w.pipe.Write(chunk)
+		return err
+	}
+	_, err := w.dst.Write(chunk)
+	return err
+}
+
+// Close finishes the writing and returns the number of written(decompressed) bytes.
+func (w *chunkWriter) Close() (int64, error) {
+	if !w.closed && w.pipe != nil {
+		w.pipe.Close()
+		w.closeErr = <-w.done
+	}
+	w.closed = true
+
+	if w.closeErr != nil {
+		// the check's errors are returned as they are
+		if _, ok := status.FromError(w.closeErr); ok {
+			return 0, w.closeErr
+		}
+		return 0, fmt.Errorf("failed to decompress: %v", w.closeErr)
+	}
+	return w.dst.written, nil
+}
+
+func (s *Server) metadataPath(client, filename string) string {
+	return metadataFile(s.StoragePath, client, filename)
+}
+
+// writeMetadata stores the key-value metadata sent with the file.
+// Metadata of the previous upload is removed, if there is no new.
+func (s *Server) writeMetadata(client, filename string, metadata map[string]string) error {
+	fullname := s.metadataPath(client, filename)
+	if len(metadata) == 0 {
+		if err := os.Remove(fullname); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullname), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directories: %v", err)
+	}
+	return ioutil.WriteFile(fullname, data, 0644)
+}
+
+// readMetadata returns the metadata stored with the file, nil if there is none.
+func (s *Server) readMetadata(client, filename string) (map[string]string, error) {
+	return readMetadataFile(s.metadataPath(client, filename))
+}
+
+func readMetadataFile(fullname string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(fullname)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// tempFile creates a file in the internal folder, for the data which is not verified yet.
+func (s *Server) tempFile() (*os.File, error) {
+	dir := filepath.Join(s.StoragePath, internalDir, "tmp")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directories: %v", err)
+	}
+	return ioutil.TempFile(dir, "upload-")
+}
+
+// commitFile replaces the file at loc with the verified temp file, and stores its metadata.
+// The replaced file is kept as version, when versioning is enabled. WORM files are not replaced
+// otherwise, it fails with AlreadyExists. rec is added to the index with the stored version, the
+// file isn't committed without it: the replaced file is put back and temp is left to be removed.
+func (s *Server) commitFile(loc location, temp string, metadata map[string]string, rec fileRecord) error {
+	fullname := loc.path(s.StoragePath)
+	if loc.worm.Enabled && !s.Versioning.Enabled {
+		// the link fails if the file exists, even if it was created after the upload started
+		if err := os.Link(temp, fullname); os.IsExist(err) {
+			return grpc.Errorf(codes.AlreadyExists, "file %q already exists and can't be replaced", loc.prefix+loc.name)
+		} else if err != nil {
+			return err
+		}
+		if err := s.indexFile(loc, metadata, rec, ""); err != nil {
+			os.Remove(fullname)
+			os.Remove(s.metadataPath(loc.owner, loc.name))
+			return err
+		}
+		os.Remove(temp)
+		s.replicate(replicaOp{Op: replicaUpload, Owner: loc.owner, Name: loc.name})
+		return nil
+	}
+
+	// the replaced file is put aside, as version or next to temp, until the new one is indexed
+	keptAs, aside := "", ""
+	if s.Versioning.Enabled {
+		var err error
+		if keptAs, err = s.keepVersion(loc); err != nil {
+			return err
+		}
+		if keptAs != "" {
+			aside = filepath.Join(s.versionsDir(loc.owner, loc.name), keptAs)
+		}
+	} else if _, err := os.Stat(fullname); err == nil {
+		aside = temp + ".old"
+		if err := os.Rename(s.metadataPath(loc.owner, loc.name), aside+".json"); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to put metadata aside: %v", err)
+		}
+		if err := os.Rename(fullname, aside); err != nil {
+			os.Rename(aside+".json", s.metadataPath(loc.owner, loc.name))
+			return fmt.Errorf("failed to put file aside: %v", err)
+		}
+	}
+
+	if err := os.Rename(temp, fullname); err != nil {
+		s.restoreFile(loc, aside)
+		return err
+	}
+	if err := s.indexFile(loc, metadata, rec, keptAs); err != nil {
+		os.Rename(fullname, temp)
+		s.restoreFile(loc, aside)
+		return err
+	}
+
+	if keptAs != "" {
+		if _, err := s.pruneFile(loc.owner, loc.name, time.Now()); err != nil {
+			log.Errorf("Failed to prune versions of %q: %v", loc.prefix+loc.name, err)
+		}
+	} else if aside != "" {
+		os.Remove(aside)
+		os.Remove(aside + ".json")
+	}
+	s.replicate(replicaOp{Op: replicaUpload, Owner: loc.owner, Name: loc.name})
+	return nil
+}
+
+// restoreFile puts the file and its metadata put aside by commitFile back to loc. With empty
+// aside there was no file, the new metadata is removed.
+func (s *Server) restoreFile(loc location, aside string) {
+	metadata := s.metadataPath(loc.owner, loc.name)
+	if aside == "" {
+		os.Remove(metadata)
+		return
+	}
+	if err := os.Rename(aside+".json", metadata); os.IsNotExist(err) {
+		os.Remove(metadata)
+	} else if err != nil {
+		log.Errorf("Failed to restore metadata of %q from %q: %v", loc.prefix+loc.name, aside, err)
+	}
+	if err := os.Rename(aside, loc.path(s.StoragePath)); err != nil {
+		log.Errorf("Failed to restore %q from %q: %v", loc.prefix+loc.name, aside, err)
+	}
+}
+
+// indexFile stores the metadata of the stored file at loc, and adds its record to the index.
+func (s *Server) indexFile(loc location, metadata map[string]string, rec fileRecord, keptAs string) error {
+	if err := s.writeMetadata(loc.owner, loc.name, metadata); err != nil {
+		return err
+	}
+	info, err := os.Stat(loc.path(s.StoragePath))
+	if err != nil {
+		return err
+	}
+	rec.Owner, rec.Name = loc.owner, loc.name
+	rec.Version, rec.Uploaded, rec.Size = versionID(info.ModTime()), info.ModTime(), info.Size()
+	if rec.Algorithm == "" {
+		rec.Algorithm = hashAlgorithm
+	}
+	if err := s.index.commit(rec, keptAs); err != nil {
+		return fmt.Errorf("failed to index: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,489 @@
+package alcatraz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/sirupsen/logrus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Trash keeps the deleted files, until they expire.
+type Trash struct {
+	// Expiry is how long the deleted files could be restored, default is 30 days
+	Expiry time.Duration `yaml:"expiry"`
+}
+
+func (t Trash) expiry() time.Duration {
+	if t.Expiry == 0 {
+		return 30 * 24 * time.Hour
+	}
+	return t.Expiry
+}
+
+// trashEntry describes a deleted file. It's stored in the entry's folder with the file's data
+// and metadata.
+type trashEntry struct {
+	ID      string    `json:"-"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Deleted time.Time `json:"deleted"`
+	By      string    `json:"by"`
+}
+
+const (
+	trashData      = "data"
+	trashMetadata  = "metadata.json"
+	trashEntryFile = "entry.json"
+)
+
+// trashDir is the folder with the deleted files of the owner, by their IDs.
+func (s *Server) trashDir(owner string) string {
+	return filepath.Join(s.StoragePath, internalDir, "trash", owner)
+}
+
+// trashFile moves the file at loc, with its metadata, to the owner's trash.
+func (s *Server) trashFile(loc location, size int64, by string) (trashEntry, error) {
+	entry := trashEntry{Name: loc.name, Size: size, Deleted: time.Now(), By: by}
+	if err := os.MkdirAll(s.trashDir(loc.owner), os.ModePerm); err != nil {
+		return entry, fmt.Errorf("failed to create directories: %v", err)
+	}
+
+	// the files deleted in the same nanosecond get the next free ID
+	var dir string
+	for deleted := entry.Deleted; ; deleted = deleted.Add(time.Nanosecond) {
+		entry.ID = versionID(deleted)
+		dir = filepath.Join(s.trashDir(loc.owner), entry.ID)
+		err := os.Mkdir(dir, os.ModePerm)
+		if err == nil {
+			break
+		} else if !os.IsExist(err) {
+			return entry, err
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, trashEntryFile), data, 0644); err != nil {
+		return entry, err
+	}
+	if err := os.Rename(s.metadataPath(loc.owner, loc.name), filepath.Join(dir, trashMetadata)); err != nil && !os.IsNotExist(err) {
+		return entry, fmt.Errorf("failed to move metadata: %v", err)
+	}
+	if err := os.Rename(loc.path(s.StoragePath), filepath.Join(dir, trashData)); err != nil {
+		return entry, err
+	}
+	if err := s.index.trash(loc.owner, loc.name, entry.ID); err != nil {
+		log.Errorf("Failed to move %q to trash in index: %v", loc.prefix+loc.name, err)
+	}
+	return entry, nil
+}
+
+// trashEntries returns the deleted files of the owner under the prefix folder, the last
+// deleted first.
+func (s *Server) trashEntries(owner, prefix string) ([]trashEntry, error) {
+	infos, err := ioutil.ReadDir(s.trashDir(owner))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entries := []trashEntry{}
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(s.trashDir(owner), info.Name(), trashEntryFile))
+		if err != nil {
+			// the entry is being created or removed
+			continue
+		}
+		entry := trashEntry{ID: info.Name()}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Errorf("Failed to parse trash entry %q of %q: %v", info.Name(), owner, err)
+			continue
+		}
+		if prefix == "" || entry.Name == prefix || strings.HasPrefix(entry.Name, prefix+"/") {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+	return entries, nil
+}
+
+// emptyTrash removes the expired files from the trash of the known owners, the held ones are
+// kept. The trash of the removed owners is kept too, their holds are not known.
+func (s *Server) emptyTrash(now time.Time) {
+	removed := 0
+	for _, loc := range s.owners() {
+		entries, err := s.trashEntries(loc.owner, "")
+		if err != nil {
+			log.Errorf("Failed to read trash of %q: %v", loc.owner, err)
+			continue
+		}
+		for _, entry := range entries {
+			if now.Sub(entry.Deleted) < s.Trash.expiry() {
+				continue
+			}
+			if _, _, held := s.holds.held(loc.owner, entry.Name); held {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(s.trashDir(loc.owner), entry.ID)); err != nil {
+				log.Errorf("Failed to remove %q from trash of %q: %v", entry.Name, loc.owner, err)
+				continue
+			}
+			if err := s.index.removeTrash(loc.owner, entry.ID); err != nil {
+				log.Errorf("Failed to remove %q from trash index of %q: %v", entry.Name, loc.owner, err)
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Infof("Removed %d expired files from trash", removed)
+	}
+}
+
+// emptyTrashPeriodically removes the expired files every hour, until ctx is done.
+func (s *Server) emptyTrashPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		s.emptyTrash(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removable returns the info of the file at loc, if it exists and could be deleted or moved.
+func (s *Server) removable(identity string, loc location) (os.FileInfo, error) {
+	name := loc.prefix + loc.name
+	if loc.name == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "expected filename")
+	}
+
+	info, err := os.Stat(loc.path(s.StoragePath))
+	if os.IsNotExist(err) {
+		return nil, grpc.Errorf(codes.NotFound, "file %q not found", name)
+	} else if err != nil {
+		log.Errorf("Client [%s]: failed to stat %q: %v", identity, name, err)
+		return nil, grpc.Errorf(codes.Internal, "failed to stat file")
+	}
+	if info.IsDir() {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%q is a folder", name)
+	}
+
+	if err := s.checkRetention(loc, info.ModTime(), time.Now()); err != nil {
+		s.audit("delete denied", identity, logrus.Fields{"name": name, "reason": err.Error()})
+		return nil, err
+	}
+	return info, nil
+}
+
+// DeleteFile moves a file from the client's folder, or from a shared space, to the trash.
+func (s *Server) DeleteFile(ctx context.Context, req *pb.DeleteRequest) (*empty.Empty, error) {
+	client := s.identityFromCtx(ctx)
+
+	loc, err := s.locateAs(client, req.GetOwner(), cleanName(req.GetName()), true)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.removable(client, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := s.trashFile(loc, info.Size(), client)
+	if err != nil {
+		log.Errorf("Client [%s]: failed to delete %q: %v", client, loc.prefix+loc.name, err)
+		return nil, grpc.Errorf(codes.Internal, "failed to delete file")
+	}
+	s.usage.add(loc, -info.Size(), -1)
+	s.replicate(replicaOp{Op: replicaDelete, Owner: loc.owner, Name: loc.name})
+
+	s.audit("file deleted", client, logrus.Fields{"name": loc.prefix + loc.name, "trash_id": entry.ID})
+	return &empty.Empty{}, nil
+}
+
+// MoveFile renames a file, with its metadata and versions. The destination must be in the same
+// folder or space, and must not exist.
+func (s *Server) MoveFile(ctx context.Context, req *pb.MoveRequest) (*empty.Empty, error) {
+	client := s.identityFromCtx(ctx)
+
+	// the source is checked by the policy interceptor
+	destination := cleanName(req.GetDestination())
+	if err := s.checkPolicy(client, methodPrefix+"MoveFile", destination, true); err != nil {
+		return nil, err
+	}
+
+	src, err := s.locateAs(client, req.GetOwner(), cleanName(req.GetSource()), true)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := s.locateAs(client, req.GetOwner(), destination, true)
+	if err != nil {
+		return nil, err
+	}
+	if src.owner != dst.owner {
+		return nil, grpc.Errorf(codes.InvalidArgument, "files can't be moved to another folder or space")
+	}
+	if dst.name == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "expected destination filename")
+	}
+	if _, err := s.removable(client, src); err != nil {
+		return nil, err
+	}
+
+	// the destination is checked and taken under one lock, so two moves don't replace each other
+	s.renameMu.Lock()
+	defer s.renameMu.Unlock()
+	if _, err := os.Stat(dst.path(s.StoragePath)); err == nil {
+		return nil, grpc.Errorf(codes.AlreadyExists, "file %q already exists", destination)
+	}
+	if err := s.moveFile(src, dst); err != nil {
+		log.Errorf("Client [%s]: failed to move %q to %q: %v", client, req.GetSource(), destination, err)
+		return nil, grpc.Errorf(codes.Internal, "failed to move file")
+	}
+
+	s.audit("file moved", client, logrus.Fields{"name": src.prefix + src.name, "destination": destination})
+	return &empty.Empty{}, nil
+}
+
+// moveFile renames the file, its metadata and its versions. On failure, the done renames are
+// undone.
+func (s *Server) moveFile(src, dst location) error {
+	renames := [][2]string{
+		{src.path(s.StoragePath), dst.path(s.StoragePath)},
+		{s.metadataPath(src.owner, src.name), s.metadataPath(dst.owner, dst.name)},
+		{s.versionsDir(src.owner, src.name), s.versionsDir(dst.owner, dst.name)},
+	}
+	done := [][2]string{}
+	undo := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			if err := os.Rename(done[i][1], done[i][0]); err != nil {
+				log.Errorf("Failed to move %q back to %q: %v", done[i][1], done[i][0], err)
+			}
+		}
+	}
+	for i, rename := range renames {
+		if _, err := os.Stat(rename[0]); os.IsNotExist(err) && i > 0 {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(rename[1]), os.ModePerm); err != nil {
+			undo()
+			return fmt.Errorf("failed to create directories: %v", err)
+		}
+		if err := os.Rename(rename[0], rename[1]); err != nil {
+			undo()
+			return err
+		}
+		done = append(done, rename)
+	}
+	if err := s.index.move(src.owner, src.name, dst.name); err != nil {
+		log.Errorf("Failed to move %q to %q in index: %v", src.prefix+src.name, dst.prefix+dst.name, err)
+	}
+	s.replicate(replicaOp{Op: replicaMove, Owner: src.owner, Name: src.name, Destination: dst.name})
+	return nil
+}
+
+// Undelete restores a file from the trash, it counts in the quota again.
+func (s *Server) Undelete(ctx context.Context, req *pb.UndeleteRequest) (*pb.FileInfo, error) {
+	client := s.identityFromCtx(ctx)
+
+	name := cleanName(req.GetName())
+	loc, err := s.locate(client, name, true)
+	if err != nil {
+		return nil, err
+	}
+	if loc.name == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "expected filename")
+	}
+
+	entries, err := s.trashEntries(loc.owner, loc.name)
+	if err != nil {
+		log.Errorf("Client [%s]: failed to read trash: %v", client, err)
+		return nil, grpc.Errorf(codes.Internal, "failed to read trash")
+	}
+	var entry *trashEntry
+	for i := range entries {
+		if entries[i].Name == loc.name && (req.GetId() == "" || entries[i].ID == req.GetId()) {
+			entry = &entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		return nil, grpc.Errorf(codes.NotFound, "%q is not in the trash", name)
+	}
+
+	s.renameMu.Lock()
+	defer s.renameMu.Unlock()
+	fullname := loc.path(s.StoragePath)
+	if _, err := os.Stat(fullname); err == nil {
+		return nil, grpc.Errorf(codes.AlreadyExists, "file %q already exists", name)
+	}
+	reserved, err := s.usage.reserve(loc, -1, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := reserved.grow(entry.Size); err != nil {
+		reserved.release()
+		return nil, err
+	}
+
+	dir := filepath.Join(s.trashDir(loc.owner), entry.ID)
+	err = os.MkdirAll(filepath.Dir(fullname), os.ModePerm)
+	if err == nil {
+		err = os.Rename(filepath.Join(dir, trashData), fullname)
+	}
+	if err != nil {
+		reserved.release()
+		log.Errorf("Client [%s]: failed to restore %q: %v", client, name, err)
+		return nil, grpc.Errorf(codes.Internal, "failed to restore file")
+	}
+	reserved.commit(entry.Size)
+
+	metadata := s.metadataPath(loc.owner, loc.name)
+	if err := os.MkdirAll(filepath.Dir(metadata), os.ModePerm); err == nil {
+		if err := os.Rename(filepath.Join(dir, trashMetadata), metadata); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Client [%s]: failed to restore metadata of %q: %v", client, name, err)
+		}
+	}
+	os.RemoveAll(dir)
+	if err := s.index.restore(loc.owner, entry.ID); err != nil {
+		log.Errorf("Client [%s]: failed to restore %q in index: %v", client, name, err)
+	}
+	s.replicate(replicaOp{Op: replicaUpload, Owner: loc.owner, Name: loc.name})
+
+	s.audit("file restored", client, logrus.Fields{"name": name, "trash_id": entry.ID})
+
+	info := &pb.FileInfo{Name: name, Size: entry.Size, Current: true}
+	if stat, err := os.Stat(fullname); err == nil {
+		info.Modified, _ = ptypes.TimestampProto(stat.ModTime())
+		info.Version = versionID(stat.ModTime())
+	}
+	return info, nil
+}
+
+// ListTrash lists the deleted files in the client's folder, or in a shared space, under the prefix.
+func (s *Server) ListTrash(ctx context.Context, req *pb.ListRequest) (*pb.TrashList, error) {
+	client := s.identityFromCtx(ctx)
+
+	loc, err := s.locate(client, cleanName(req.GetPrefix()), true)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := s.trashEntries(loc.owner, loc.name)
+	if err != nil {
+		log.Errorf("Client [%s]: failed to read trash: %v", client, err)
+		return nil, grpc.Errorf(codes.Internal, "failed to read trash")
+	}
+
+	list := &pb.TrashList{}
+	for _, entry := range entries {
+		deleted, _ := ptypes.TimestampProto(entry.Deleted)
+		expires, _ := ptypes.TimestampProto(entry.Deleted.Add(s.Trash.expiry()))
+		list.Entries = append(list.Entries, &pb.TrashEntry{
+			Name:    loc.prefix + entry.Name,
+			Id:      entry.ID,
+			Size:    entry.Size,
+			Deleted: deleted,
+			By:      entry.By,
+			Expires: expires,
+		})
+	}
+	return list, nil
+}
+
+// TrashEntry is a deleted file, which could be restored until it expires.
+type TrashEntry struct {
+	Name    string
+	ID      string
+	Size    int64
+	Deleted time.Time
+	// By is the identity of the client who deleted the file
+	By      string
+	Expires time.Time
+}
+
+// Delete moves the file to the trash on the server, Undelete restores it.
+func (c *Client) Delete(ctx context.Context, name string) error {
+	if c.cli == nil {
+		return ErrNotConnected
+	}
+
+	if _, err := c.cli.DeleteFile(ctx, &pb.DeleteRequest{Name: name}); err != nil {
+		return &Error{Op: "delete", Name: name, Err: err}
+	}
+	return nil
+}
+
+// Move renames the file, the destination must be in the same folder or space.
+func (c *Client) Move(ctx context.Context, source, destination string) error {
+	if c.cli == nil {
+		return ErrNotConnected
+	}
+
+	if _, err := c.cli.MoveFile(ctx, &pb.MoveRequest{Source: source, Destination: destination}); err != nil {
+		return &Error{Op: "move", Name: source, Err: err}
+	}
+	return nil
+}
+
+// Undelete restores the deleted file from the trash. id selects one of the deleted files
+// with the name, empty is the last deleted one.
+func (c *Client) Undelete(ctx context.Context, name, id string) (FileInfo, error) {
+	if c.cli == nil {
+		return FileInfo{}, ErrNotConnected
+	}
+
+	resp, err := c.cli.Undelete(ctx, &pb.UndeleteRequest{Name: name, Id: id})
+	if err != nil {
+		return FileInfo{}, &Error{Op: "undelete", Name: name, Err: err}
+	}
+
+	modified, _ := ptypes.Timestamp(resp.GetModified())
+	return FileInfo{Name: resp.GetName(), Size: resp.GetSize(), Modified: modified, Version: resp.GetVersion(), Current: true}, nil
+}
+
+// Trash returns the deleted files under the prefix folder, the last deleted first.
+func (c *Client) Trash(ctx context.Context, prefix string) ([]TrashEntry, error) {
+	if c.cli == nil {
+		return nil, ErrNotConnected
+	}
+
+	resp, err := c.cli.ListTrash(ctx, &pb.ListRequest{Prefix: prefix})
+	if err != nil {
+		return nil, &Error{Op: "trash", Name: prefix, Err: err}
+	}
+
+	entries := []TrashEntry{}
+	for _, entry := range resp.GetEntries() {
+		deleted, _ := ptypes.Timestamp(entry.GetDeleted())
+		expires, _ := ptypes.Timestamp(entry.GetExpires())
+		entries = append(entries, TrashEntry{
+			Name:    entry.GetName(),
+			ID:      entry.GetId(),
+			Size:    entry.GetSize(),
+			Deleted: deleted,
+			By:      entry.GetBy(),
+			Expires: expires,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,192 @@
+package alcatraz
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDeleteMove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients: []ClientACL{
+			{
+				Identity:    "Malcolm",
+				Permissions: []Permission{PermissionUpload, PermissionDownload, PermissionList, PermissionDelete},
+				Quota:       Quota{MaxFiles: 2},
+			},
+			{Identity: "Dewey", Permissions: []Permission{PermissionUpload}},
+		},
+		LogLevel: "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	clients := map[string]*Client{}
+	for _, name := range []string{"Malcolm", "Dewey"} {
+		_, files := ca.issue(t, name, nil)
+		client, err := NewClient(ClientConfig{Host: addr, Certificates: files, LogLevel: "info"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients[name] = client
+	}
+	malcolm, ctx := clients["Malcolm"], context.Background()
+
+	if err := malcolm.Upload(ctx, "a.txt", strings.NewReader("first"), WithMetadata(map[string]string{"by": "Malcolm"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := malcolm.Upload(ctx, "b.txt", strings.NewReader("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := clients["Dewey"].Delete(ctx, "a.txt"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied without delete permission, got %v", err)
+	}
+
+	// the deleted file goes to the trash and is freed from the quota
+	if err := malcolm.Delete(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := malcolm.Delete(ctx, "a.txt"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+	if files, _ := malcolm.List(ctx, ""); len(files) != 1 || files[0].Name != "b.txt" {
+		t.Errorf("expected only b.txt, got %+v", files)
+	}
+	if usage, _ := malcolm.Quota(ctx, ""); usage.UsedFiles != 1 || usage.UsedBytes != 6 {
+		t.Errorf("expected the deleted file to be freed, got %+v", usage)
+	}
+	entries, err := malcolm.Trash(ctx, "")
+	if err != nil || len(entries) != 1 || entries[0].Name != "a.txt" || entries[0].By != "Malcolm" || entries[0].Size != 5 {
+		t.Errorf("expected a.txt in the trash, got %+v, %v", entries, err)
+	}
+
+	if err := malcolm.Move(ctx, "b.txt", "docs/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if _, err := malcolm.Download(ctx, "docs/c.txt", buf); err != nil || buf.String() != "second" {
+		t.Errorf("expected the moved file, got %q, %v", buf.String(), err)
+	}
+	if err := malcolm.Upload(ctx, "b.txt", strings.NewReader("third")); err != nil {
+		t.Fatal(err)
+	}
+	if err := malcolm.Move(ctx, "b.txt", "docs/c.txt"); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists, got %v", err)
+	}
+	if err := malcolm.Move(ctx, "b.txt", "@team/b.txt"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for unknown space, got %v", err)
+	}
+
+	// the restored file counts in the quota again
+	if _, err := malcolm.Undelete(ctx, "a.txt", ""); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted over the quota, got %v", err)
+	}
+	if err := malcolm.Delete(ctx, "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := malcolm.Undelete(ctx, "a.txt", entries[0].ID)
+	if err != nil || info.Size != 5 {
+		t.Fatalf("expected a.txt to be restored, got %+v, %v", info, err)
+	}
+	buf.Reset()
+	if info, err := malcolm.Download(ctx, "a.txt", buf); err != nil || buf.String() != "first" || info.Metadata["by"] != "Malcolm" {
+		t.Errorf("expected the restored file with metadata, got %q, %+v, %v", buf.String(), info, err)
+	}
+	if _, err := malcolm.Undelete(ctx, "a.txt", ""); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+
+	// held files can't be deleted
+	if err := server.holds.place("Malcolm", "docs", hold{Reason: "case 42"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := malcolm.Delete(ctx, "docs/c.txt"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for held file, got %v", err)
+	}
+	if err := malcolm.Move(ctx, "docs/c.txt", "c.txt"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for held file, got %v", err)
+	}
+
+	// the expired files are removed from the trash
+	server.emptyTrash(time.Now().Add(time.Hour))
+	if entries, _ := malcolm.Trash(ctx, ""); len(entries) != 1 {
+		t.Errorf("expected b.txt in the trash, got %+v", entries)
+	}
+	server.emptyTrash(time.Now().Add(31 * 24 * time.Hour))
+	if entries, _ := malcolm.Trash(ctx, ""); len(entries) != 0 {
+		t.Errorf("expected empty trash, got %+v", entries)
+	}
+}
+
+func TestMoveFileUndo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients:      []ClientACL{{Identity: "Malcolm", Permissions: []Permission{PermissionUpload}}},
+		LogLevel:     "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.index.Close()
+
+	src, _ := server.locate("Malcolm", "a.txt", true)
+	dst, _ := server.locate("Malcolm", "b.txt", true)
+	files := []string{src.path(server.StoragePath), server.metadataPath("Malcolm", "a.txt"), filepath.Join(server.versionsDir("Malcolm", "a.txt"), "1")}
+	// the versions can't be moved over the existing ones
+	for _, filename := range append(files, filepath.Join(server.versionsDir("Malcolm", "b.txt"), "2")) {
+		if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := server.moveFile(src, dst); err == nil {
+		t.Fatal("expected error")
+	}
+	for _, filename := range files {
+		if _, err := os.Stat(filename); err != nil {
+			t.Errorf("expected %s to be moved back, got %v", filename, err)
+		}
+	}
+	if _, err := os.Stat(dst.path(server.StoragePath)); !os.IsNotExist(err) {
+		t.Errorf("expected no destination, got %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package alcatraz
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/avalchev94/alcatraz/pb"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNotConnected is returned when Upload is called before Connect.
+var ErrNotConnected = errors.New("client is not connected")
+
+// Error is returned by the client when the server fails the request.
+// It wraps the gRPC status, so status.FromError and status.Code work on it.
+type Error struct {
+	// Op is the failed operation, e.g. "upload"
+	Op   string
+	Name string
+	Err  error
+	// RetryAfter is the wait advised by the server, when the request is over its limits
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %q: %v", e.Op, e.Name, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Code returns the gRPC code of the error, codes.Unknown if the error didn't come from the server.
+func (e *Error) Code() codes.Code {
+	return status.Code(e.Err)
+}
+
+// GRPCStatus returns the status sent by the server.
+func (e *Error) GRPCStatus() *status.Status {
+	s, _ := status.FromError(e.Err)
+	return s
+}
+
+// Compression of the uploaded data on the wire.
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+)
+
+// Progress is reported to the progress callback after each sent chunk.
+type Progress struct {
+	Name string
+	// Sent is the number of bytes read from the source so far
+	Sent int64
+	// Total is the size of the source, -1 when unknown
+	Total   int64
+	Elapsed time.Duration
+	// Throughput is in bytes per second
+	Throughput float64
+}
+
+// UploadOption configures a single Upload call.
+type UploadOption func(*uploadOptions)
+
+type uploadOptions struct {
+	size        int64
+	metadata    map[string]string
+	compression Compression
+	chunkSize   int
+	progress    func(Progress)
+	limiters    []*rateLimiter
+	// owner and uploadedBy are set by a replicating primary
+	owner, uploadedBy string
+}
+
+// WithSize declares the length of the uploaded data, the server verifies it.
+func WithSize(size int64) UploadOption {
+	return func(o *uploadOptions) { o.size = size }
+}
+
+// WithMetadata attaches key-value metadata to the uploaded file.
+func WithMetadata(metadata map[string]string) UploadOption {
+	return func(o *uploadOptions) { o.metadata = metadata }
+}
+
+// WithCompression compresses the data on the wire, the server stores it uncompressed.
+func WithCompression(compression Compression) UploadOption {
+	return func(o *uploadOptions) { o.compression = compression }
+}
+
+// WithChunkSize overrides the client's chunk size.
+func WithChunkSize(size int) UploadOption {
+	return func(o *uploadOptions) { o.chunkSize = size }
+}
+
+// WithProgress sets a callback, called after every sent chunk.
+func WithProgress(fn func(Progress)) UploadOption {
+	return func(o *uploadOptions) { o.progress = fn }
+}
+
+// Upload streams the content of r to the server, where it's stored under name.
+// The length of r is unknown, unless WithSize is given. The client has to be connected, see Connect.
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader, options ...UploadOption) error {
+	if c.cli == nil {
+		return ErrNotConnected
+	}
+
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return fmt.Errorf("empty remote name")
+	}
+
+	opts := uploadOptions{
+		size:      -1,
+		chunkSize: c.ChunkSize,
+		limiters:  []*rateLimiter{c.limiter},
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	switch opts.compression {
+	case CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("unknown compression %q", opts.compression)
+	}
+	if opts.chunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size %d", opts.chunkSize)
+	}
+
+	return c.send(ctx, name, r, opts)
+}
+
+// send uploads the file. When the server is over its limits, the upload is retried after the
+// advised wait, if r could be rewound.
+func (c *Client) send(ctx context.Context, name string, r io.Reader, opts uploadOptions) error {
+	start := int64(-1)
+	seeker, ok := r.(io.Seeker)
+	if ok {
+		if pos, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			start = pos
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.sendOnce(ctx, name, r, opts)
+
+		var uploadErr *Error
+		if !errors.As(err, &uploadErr) || uploadErr.RetryAfter == 0 || start < 0 || attempt == maxRetries {
+			return err
+		}
+		log.Warnf("Server is busy, retrying %q in %s: %v", name, uploadErr.RetryAfter, uploadErr.Err)
+		if sleep(ctx, uploadErr.RetryAfter) != nil {
+			return err
+		}
+		if _, seekErr := seeker.Seek(start, io.SeekStart); seekErr != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) sendOnce(ctx context.Context, name string, r io.Reader, opts uploadOptions) error {
+	// wait for the pause windows to end, before the stream is opened
+	for _, limiter := range opts.limiters {
+		if err := limiter.resume(ctx); err != nil {
+			return err
+		}
+	}
+
+	// create stream for uploading the file
+	stream, err := c.cli.UploadFile(ctx)
+	if err != nil {
+		return &Error{Op: "upload", Name: name, Err: err}
+	}
+
+	// stream the file
+	if err := c.streamFile(ctx, name, r, opts, stream); err != nil {
+		// even if the streaming is not successful, we want to close and recieve message from the server
+		// the server might have some useful error, that he sent for the client
+		if _, closeErr := stream.CloseAndRecv(); closeErr != nil {
+			return &Error{Op: "upload", Name: name, Err: closeErr, RetryAfter: retryAfter(stream.Trailer())}
+		}
+		return fmt.Errorf("failed to stream the file: %v", err)
+	}
+
+	// close the stream
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return &Error{Op: "upload", Name: name, Err: err, RetryAfter: retryAfter(stream.Trailer())}
+	}
+
+	return nil
+}
+
+func (c *Client) streamFile(ctx context.Context, name string, r io.Reader, opts uploadOptions, stream pb.Alcatraz_UploadFileClient) error {
+	// always send the header first
+	compression := pb.Compression_NONE
+	if opts.compression == CompressionGzip {
+		compression = pb.Compression_GZIP
+	}
+	err := stream.Send(&pb.UploadRequest{
+		TestOneof: &pb.UploadRequest_Header{
+			Header: &pb.Header{
+				Name:        name,
+				Size:        opts.size,
+				Metadata:    opts.metadata,
+				Compression: compression,
+				Owner:       opts.owner,
+				UploadedBy:  opts.uploadedBy,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send file's header: %v", err)
+	}
+
+	sender := &chunkSender{ctx: ctx, stream: stream, size: opts.chunkSize, limiters: opts.limiters}
+	var w io.Writer = sender
+	var gz *gzip.Writer
+	if opts.compression == CompressionGzip {
+		gz = gzip.NewWriter(sender)
+		w = gz
+	}
+
+	// send the file data chunk by chunk
+	hash := sha256.New()
+	start := time.Now()
+	sent := int64(0)
+	for {
+		chunk := make([]byte, opts.chunkSize)
+		n, err := io.ReadFull(r, chunk)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read chunk from file: %v", err)
+		}
+		chunk = chunk[:n]
+
+		if _, err := hash.Write(chunk); err != nil {
+			return fmt.Errorf("failed to write to hash: %v", err)
+		}
+
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+
+		sent += int64(n)
+		if opts.progress != nil {
+			elapsed, throughput := time.Since(start), 0.0
+			// a coarse clock could report no time yet
+			if elapsed > 0 {
+				throughput = float64(sent) / elapsed.Seconds()
+			}
+			opts.progress(Progress{
+				Name:       name,
+				Sent:       sent,
+				Total:      opts.size,
+				Elapsed:    elapsed,
+				Throughput: throughput,
+			})
+		}
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to compress: %v", err)
+		}
+	}
+	if err := sender.flush(); err != nil {
+		return err
+	}
+
+	// always send the hash last
+	err = stream.Send(&pb.UploadRequest{
+		TestOneof: &pb.UploadRequest_Hash{
+			Hash: hex.EncodeToString(hash.Sum(nil)),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send file's hash: %v", err)
+	}
+
+	return nil
+}
+
+// chunkSender sends the written data as chunks of the given size.
+type chunkSender struct {
+	ctx      context.Context
+	stream   pb.Alcatraz_UploadFileClient
+	size     int
+	limiters []*rateLimiter
+	buf      []byte
+}
+
+func (s *chunkSender) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for len(s.buf) >= s.size {
+		if err := s.send(s.buf[:s.size]); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[s.size:]
+	}
+	return len(p), nil
+}
+
+func (s *chunkSender) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.send(s.buf)
+	s.buf = nil
+	return err
+}
+
+func (s *chunkSender) send(chunk []byte) error {
+	for _, limiter := range s.limiters {
+		if err := limiter.wait(s.ctx, len(chunk)); err != nil {
+			return fmt.Errorf("bandwidth wait interrupted: %v", err)
+		}
+	}
+
+	// the message must not be modified after Send, so it gets its own copy
+	err := s.stream.Send(&pb.UploadRequest{
+		TestOneof: &pb.UploadRequest_Chunk{
+			Chunk: append([]byte(nil), chunk...),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send chunck: %v", err)
+	}
+	return nil
+}
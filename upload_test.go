@@ -0,0 +1,134 @@
+package alcatraz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avalchev94/alcatraz/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestConnection runs the server without TLS and returns a client connected to it.
+func newTestConnection(t *testing.T, server *Server, config ClientConfig) (*Client, func()) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	pb.RegisterAlcatrazServer(srv, server)
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{ClientConfig: config, conn: conn, cli: pb.NewAlcatrazClient(conn)}
+	return client, func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestClientUpload(t *testing.T) {
+	storage, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storage)
+
+	client, stop := newTestConnection(t, &Server{ServerConfig: ServerConfig{StoragePath: storage}}, ClientConfig{ChunkSize: 10})
+	defer stop()
+
+	if err := (&Client{}).Upload(context.Background(), "a.txt", nil); err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+
+	// compressed upload with unknown size
+	content := strings.Repeat("alcatraz ", 100)
+	progress := []Progress{}
+	err = client.Upload(context.Background(), "/dir/../a.txt", strings.NewReader(content),
+		WithCompression(CompressionGzip),
+		WithMetadata(map[string]string{"build": "42"}),
+		WithProgress(func(p Progress) { progress = append(progress, p) }),
+	)
+	if err != nil {
+		t.Fatalf("upload should be successful, but %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(storage, "a.txt"))
+	if err != nil || string(data) != content {
+		t.Errorf("stored file differs from the uploaded: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storage, internalDir, "metadata", "a.txt.json")); err != nil {
+		t.Errorf("metadata was not stored: %v", err)
+	}
+	if len(progress) != 90 || progress[89].Sent != int64(len(content)) || progress[89].Total != -1 {
+		t.Errorf("unexpected progress: %d reports", len(progress))
+	}
+
+	// the declared size is verified by the server
+	err = client.Upload(context.Background(), "b.txt", bytes.NewReader([]byte("hello")), WithSize(10))
+	uploadErr := &Error{}
+	if !errors.As(err, &uploadErr) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if uploadErr.Code() != codes.DataLoss || status.Code(err) != codes.DataLoss {
+		t.Errorf("expected DataLoss, got %v", uploadErr.Code())
+	}
+	if _, err := os.Stat(filepath.Join(storage, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("file should be deleted when upload fails")
+	}
+
+	if err := client.Upload(context.Background(), "c.txt", strings.NewReader(""), WithCompression("zip")); err == nil {
+		t.Error("unknown compression, error should be returned")
+	}
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	storage, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storage)
+
+	server := &Server{ServerConfig: ServerConfig{StoragePath: storage, Limits: Limits{MaxUploadSize: 1000}}}
+	client, stop := newTestConnection(t, server, ClientConfig{ChunkSize: 100})
+	defer stop()
+
+	// the compressed data is small, it's decompressed over the declared size
+	content := strings.Repeat("0", 10000)
+	err = client.Upload(context.Background(), "a.txt", strings.NewReader(content), WithCompression(CompressionGzip), WithSize(10))
+	if status.Code(err) != codes.DataLoss {
+		t.Errorf("expected DataLoss, got %v", err)
+	}
+
+	// without declared size, it's limited by the maximum
+	err = client.Upload(context.Background(), "b.txt", strings.NewReader(content), WithCompression(CompressionGzip))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted, got %v", err)
+	}
+	err = client.Upload(context.Background(), "c.txt", strings.NewReader(content), WithSize(int64(len(content))))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the declared size to be rejected, got %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if _, err := os.Stat(filepath.Join(storage, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should not be stored", name)
+		}
+	}
+
+	if limit, _ := (Limits{}).sizeLimit(-1); limit != DefaultMaxUploadSize {
+		t.Errorf("expected the default limit, got %d", limit)
+	}
+}
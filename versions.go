@@ -0,0 +1,283 @@
+package alcatraz
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Versioning keeps the replaced files as versions. A version is kept while any of the
+// retention rules keeps it, with no rules all versions are kept. The versions count in the
+// bytes quota of their owner.
+type Versioning struct {
+	Enabled bool `yaml:"enabled"`
+	// KeepLast keeps the newest versions of every file
+	KeepLast int `yaml:"keep_last"`
+	// KeepDays keeps the versions replaced in the last days
+	KeepDays int `yaml:"keep_days"`
+	// PruneInterval is how often the old versions are removed, default is 1 hour
+	PruneInterval time.Duration `yaml:"prune_interval"`
+}
+
+func (v Versioning) validate() error {
+	if v.KeepLast < 0 || v.KeepDays < 0 || v.PruneInterval < 0 {
+		return fmt.Errorf("retention can't be negative")
+	}
+	return nil
+}
+
+// keeps returns whether the version, the index-th newest of the file, is kept at now.
+func (v Versioning) keeps(index int, replaced, now time.Time) bool {
+	if v.KeepLast == 0 && v.KeepDays == 0 {
+		return true
+	}
+	return index < v.KeepLast || (v.KeepDays > 0 && now.Sub(replaced) < time.Duration(v.KeepDays)*24*time.Hour)
+}
+
+// versionFormat is the version ID, the time the file was stored in UTC. It sorts as the times.
+const versionFormat = "20060102T150405.000000000Z"
+
+// version is a stored version of a file.
+type version struct {
+	id       string
+	modified time.Time
+	size     int64
+	// filename is the stored file, metadata is next to it with .json extension
+	filename string
+}
+
+func versionID(t time.Time) string {
+	return t.UTC().Format(versionFormat)
+}
+
+// versionsDir is the folder with the old versions of the owner's file.
+func (s *Server) versionsDir(owner, filename string) string {
+	return filepath.Join(s.StoragePath, internalDir, "versions", owner, filename)
+}
+
+// keepVersion moves the current file and its metadata to the versions, if there is one.
+// It returns the ID of the kept version, empty if there was no file.
+func (s *Server) keepVersion(loc location) (string, error) {
+	fullname := loc.path(s.StoragePath)
+	info, err := os.Stat(fullname)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	dir := s.versionsDir(loc.owner, loc.name)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create directories: %v", err)
+	}
+
+	// the versions stored in the same nanosecond get the next free ID
+	modified := info.ModTime()
+	target := filepath.Join(dir, versionID(modified))
+	for {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			break
+		}
+		modified = modified.Add(time.Nanosecond)
+		target = filepath.Join(dir, versionID(modified))
+	}
+
+	if err := os.Rename(s.metadataPath(loc.owner, loc.name), target+".json"); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to keep metadata: %v", err)
+	}
+	if err := os.Rename(fullname, target); err != nil {
+		return "", fmt.Errorf("failed to keep version: %v", err)
+	}
+	return filepath.Base(target), nil
+}
+
+// versions returns the old versions of the owner's file, the newest first.
+func (s *Server) versions(owner, filename string) ([]version, error) {
+	dir := s.versionsDir(owner, filename)
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	versions := []version{}
+	for _, info := range infos {
+		if info.IsDir() || strings.HasSuffix(info.Name(), ".json") {
+			continue
+		}
+		modified, err := time.Parse(versionFormat, info.Name())
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version{
+			id:       info.Name(),
+			modified: modified,
+			size:     info.Size(),
+			filename: filepath.Join(dir, info.Name()),
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].id > versions[j].id })
+	return versions, nil
+}
+
+// selectVersion finds the version of the file by selector: empty is the current file,
+// a RFC3339 time is the last version stored at or before it, anything else is a version ID.
+// The current file is returned with empty filename.
+func (s *Server) selectVersion(loc location, selector string) (version, error) {
+	current := version{}
+	if info, err := s.statCopy(loc); err == nil {
+		if info.IsDir() {
+			return version{}, grpc.Errorf(codes.InvalidArgument, "%q is a folder", loc.prefix+loc.name)
+		}
+		current = version{id: versionID(info.ModTime()), modified: info.ModTime(), size: info.Size()}
+	}
+	if selector == "" {
+		if current.id == "" {
+			return version{}, grpc.Errorf(codes.NotFound, "file %q not found", loc.prefix+loc.name)
+		}
+		return current, nil
+	}
+
+	versions, err := s.versions(loc.owner, loc.name)
+	if err != nil {
+		log.Errorf("Failed to read versions of %q: %v", loc.prefix+loc.name, err)
+		return version{}, grpc.Errorf(codes.Internal, "failed to read versions")
+	}
+	if current.id != "" {
+		versions = append([]version{current}, versions...)
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, selector)
+	for _, v := range versions {
+		if (err == nil && !v.modified.After(at)) || v.id == selector {
+			return v, nil
+		}
+	}
+	return version{}, grpc.Errorf(codes.NotFound, "version %q of %q not found", selector, loc.prefix+loc.name)
+}
+
+// pruneFile removes the versions of the file, which are not kept by the retention rules.
+// The versions under WORM retention or legal hold are kept, and all versions of the unknown
+// owners, their retention is not known.
+func (s *Server) pruneFile(owner, filename string, now time.Time) (int, error) {
+	loc, ok := s.ownerLocation(owner)
+	if !ok {
+		log.Warnf("Owner %q is not known anymore, the versions of %q are kept", owner, filename)
+		return 0, nil
+	}
+	loc.name = filename
+
+	versions, err := s.versions(owner, filename)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for i, v := range versions {
+		if s.Versioning.keeps(i, v.modified, now) || s.checkRetention(loc, v.modified, now) != nil {
+			continue
+		}
+		if err := os.Remove(v.filename); err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+		os.Remove(v.filename + ".json")
+		if err := s.index.removeVersion(owner, filename, v.id); err != nil {
+			log.Errorf("Failed to remove version %q of %q from index: %v", v.id, filename, err)
+		}
+		s.usage.add(loc, -v.size, 0)
+		pruned++
+	}
+
+	// remove the empty folders, up to the owner's one
+	for dir := s.versionsDir(owner, filename); dir != s.versionsDir(owner, ""); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return pruned, nil
+}
+
+// versionedFiles returns the names of the files with versions under root, relative to it.
+func versionedFiles(root string) ([]string, error) {
+	files := map[string]bool{}
+	err := filepath.Walk(root, func(fullname string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && fullname == root {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		// the versions are <name>/<id>
+		rel, err := filepath.Rel(root, filepath.Dir(fullname))
+		if err != nil {
+			return err
+		}
+		files[rel] = true
+		return nil
+	})
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, err
+}
+
+// prune removes the old versions of the files of the known owners.
+func (s *Server) prune(now time.Time) {
+	pruned := 0
+	for _, loc := range s.owners() {
+		files, err := versionedFiles(s.versionsDir(loc.owner, ""))
+		if err != nil {
+			log.Errorf("Failed to find versions of %q: %v", loc.owner, err)
+		}
+		for _, file := range files {
+			n, err := s.pruneFile(loc.owner, filepath.ToSlash(file), now)
+			if err != nil {
+				log.Errorf("Failed to prune versions of %q: %v", loc.prefix+file, err)
+			}
+			pruned += n
+		}
+	}
+	if pruned > 0 {
+		log.Infof("Pruned %d old versions", pruned)
+	}
+}
+
+// pruneVersions removes the old versions periodically, until ctx is done.
+func (s *Server) pruneVersions(ctx context.Context) {
+	if !s.Versioning.Enabled {
+		return
+	}
+	interval := s.Versioning.PruneInterval
+	if interval == 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.prune(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package alcatraz
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVersioningKeeps(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		versioning Versioning
+		index      int
+		age        time.Duration
+		keep       bool
+	}{
+		{Versioning{}, 100, 1000 * time.Hour, true},
+		{Versioning{KeepLast: 2}, 1, 1000 * time.Hour, true},
+		{Versioning{KeepLast: 2}, 2, time.Minute, false},
+		{Versioning{KeepDays: 1}, 5, 23 * time.Hour, true},
+		{Versioning{KeepDays: 1}, 0, 25 * time.Hour, false},
+		{Versioning{KeepLast: 1, KeepDays: 1}, 0, 25 * time.Hour, true},
+		{Versioning{KeepLast: 1, KeepDays: 1}, 3, 2 * time.Hour, true},
+		{Versioning{KeepLast: 1, KeepDays: 1}, 3, 25 * time.Hour, false},
+	}
+
+	for _, test := range tests {
+		if keep := test.versioning.keeps(test.index, now.Add(-test.age), now); keep != test.keep {
+			t.Errorf("%+v, version %d of age %s: expected %v, got %v", test.versioning, test.index, test.age, test.keep, keep)
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Reese", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients: []ClientACL{{
+			Identity:    "Reese",
+			Permissions: []Permission{PermissionUpload, PermissionDownload, PermissionList},
+			Quota:       Quota{MaxFiles: 1},
+		}},
+		Versioning: Versioning{Enabled: true, KeepLast: 2},
+		LogLevel:   "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	// the versions don't count in the quota
+	for i, content := range []string{"one", "two", "three", "four"} {
+		metadata := map[string]string{"n": content}
+		if err := client.Upload(ctx, "a.txt", strings.NewReader(content), WithMetadata(metadata)); err != nil {
+			t.Fatalf("upload %d: %v", i, err)
+		}
+	}
+
+	// the oldest version is pruned
+	files, err := client.List(ctx, "", WithVersions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || !files[0].Current || files[1].Current || files[1].Size != 5 || files[2].Size != 3 {
+		t.Fatalf("expected the current file and 2 versions, got %+v", files)
+	}
+	if current, _ := client.List(ctx, ""); len(current) != 1 || current[0].Version != files[0].Version {
+		t.Errorf("expected only the current file, got %+v", current)
+	}
+
+	buf := &bytes.Buffer{}
+	info, err := client.Download(ctx, "a.txt", buf, WithVersion(files[2].Version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "two" || info.Version != files[2].Version || info.Metadata["n"] != "two" {
+		t.Errorf("expected the version by ID, got %q, %+v", buf.String(), info)
+	}
+
+	// the time selects the last version stored before it
+	buf.Reset()
+	at := files[1].Modified.Add(time.Nanosecond).Format(time.RFC3339Nano)
+	if _, err := client.Download(ctx, "a.txt", buf, WithVersion(at)); err != nil || buf.String() != "three" {
+		t.Errorf("expected the version by time, got %q, %v", buf.String(), err)
+	}
+
+	buf.Reset()
+	if info, err := client.Download(ctx, "a.txt", buf); err != nil || buf.String() != "four" || info.Version != files[0].Version {
+		t.Errorf("expected the current file, got %q, %+v, %v", buf.String(), info, err)
+	}
+
+	for _, selector := range []string{"2000-01-01T00:00:00Z", "unknown"} {
+		if _, err := client.Download(ctx, "a.txt", ioutil.Discard, WithVersion(selector)); status.Code(err) != codes.NotFound {
+			t.Errorf("%s: expected NotFound, got %v", selector, err)
+		}
+	}
+
+	// the retention by age is applied by the pruner
+	server.Versioning = Versioning{Enabled: true, KeepDays: 1}
+	server.prune(time.Now().Add(48 * time.Hour))
+	if files, _ := client.List(ctx, "", WithVersions()); len(files) != 1 {
+		t.Errorf("expected the versions to be pruned, got %+v", files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "storage", internalDir, "versions", "Reese", "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the empty versions folder to be removed, got %v", err)
+	}
+}
+
+func TestPruneOwners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+
+	spiffe := "spiffe://example.org/reese"
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients: []ClientACL{
+			{Identity: "Reese", Permissions: []Permission{PermissionUpload}},
+			{Identity: spiffe, Permissions: []Permission{PermissionUpload}, WORM: WORM{Enabled: true, Retention: 1000 * time.Hour}},
+		},
+		Versioning: Versioning{Enabled: true, KeepDays: 1},
+		LogLevel:   "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.index.Close()
+
+	// the version of the removed owner has no known retention
+	stored := time.Now().Add(-time.Hour)
+	for _, owner := range []string{"Reese", spiffe, "Dewey"} {
+		versionsDir := server.versionsDir(owner, "a.txt")
+		if err := os.MkdirAll(versionsDir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(versionsDir, versionID(stored)), []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server.prune(time.Now().Add(48 * time.Hour))
+	for owner, kept := range map[string]bool{"Reese": false, spiffe: true, "Dewey": true} {
+		if versions, _ := server.versions(owner, "a.txt"); (len(versions) == 1) != kept {
+			t.Errorf("%s: expected kept %v, got %+v", owner, kept, versions)
+		}
+	}
+}
+
+func TestVersionsQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alcatraz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	_, serverFiles := ca.issue(t, "localhost", nil)
+	_, clientFiles := ca.issue(t, "Reese", nil)
+
+	server, err := NewServer(ServerConfig{
+		StoragePath:  filepath.Join(dir, "storage"),
+		Certificates: serverFiles,
+		Clients: []ClientACL{{
+			Identity:    "Reese",
+			Permissions: []Permission{PermissionUpload, PermissionList},
+			Quota:       Quota{MaxBytes: 10},
+		}},
+		Versioning: Versioning{Enabled: true},
+		LogLevel:   "info",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, stop := serveTLS(t, server)
+	defer stop()
+
+	client, err := NewClient(ClientConfig{Host: addr, Certificates: clientFiles, LogLevel: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	// the replaced files are kept as versions, they fill the quota
+	for _, content := range []string{"one.", "two."} {
+		if err := client.Upload(ctx, "a.txt", strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if usage, _ := client.Quota(ctx, ""); usage.UsedBytes != 8 || usage.UsedFiles != 1 {
+		t.Errorf("expected 8 bytes in 1 file, got %+v", usage)
+	}
+	if err := client.Upload(ctx, "a.txt", strings.NewReader("six.")); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted, got %v", err)
+	}
+
+	// the pruned versions free the quota
+	server.Versioning.KeepDays = 1
+	server.prune(time.Now().Add(48 * time.Hour))
+	if usage, _ := client.Quota(ctx, ""); usage.UsedBytes != 4 {
+		t.Errorf("expected 4 bytes without the version, got %+v", usage)
+	}
+	if err := client.Upload(ctx, "a.txt", strings.NewReader("six.")); err != nil {
+		t.Errorf("expected success after pruning, got %v", err)
+	}
+}